@@ -1,7 +1,8 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"flag"
 	"fmt"
 	"github.com/cfagiani/gomosaic/util"
 	"github.com/nmrshll/oauth2-noserver"
@@ -13,17 +14,23 @@ import (
 
 func usage() {
 	fmt.Println("Too few command line arguments.\n\nUsage:\n")
-	fmt.Println("authtoken <configFile> <tokenFile>\n")
+	fmt.Println("authtoken [flags] <configFile> <tokenFile>\n")
+	flag.PrintDefaults()
 }
 
 // Utility program to validate that we can get an authentication token using the user-supplied clientId and secret.
 // It will update the config file with the token so it can be used by the indexer later.
 func main() {
-	if len(os.Args) != 3 {
+	paste := flag.Bool("paste", false, "print the authorization url and read the resulting code from stdin instead of opening a browser")
+	device := flag.Bool("device", false, "use the OAuth device flow instead of opening a browser")
+	flag.Usage = usage
+	flag.Parse()
+	args := flag.Args()
+	if len(args) != 2 || (*paste && *device) {
 		usage()
 		os.Exit(1)
 	}
-	config, e := util.ReadConfig(os.Args[1])
+	config, e := util.ReadConfig(args[0])
 	if e != nil {
 		fmt.Printf("Could not read configuration file: %v\n", e)
 		os.Exit(1)
@@ -35,13 +42,33 @@ func main() {
 		Scopes:       []string{photoslibrary.PhotoslibraryReadonlyScope},
 		Endpoint:     google.Endpoint,
 	}
-	client := oauth2ns.Authorize(conf)
-	fmt.Printf("Writing token to %s", os.Args[2])
-	f, err := os.OpenFile(os.Args[2], os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
-	defer f.Close()
+	ctx := context.Background()
+	var token *oauth2.Token
+	var err error
+	switch {
+	case *paste:
+		token, err = util.PasteAuthorize(ctx, conf, os.Stdin, os.Stdout)
+	case *device:
+		token, err = util.DeviceAuthorize(ctx, conf, os.Stdout)
+	default:
+		token = oauth2ns.Authorize(conf).Token
+	}
+	if err != nil {
+		fmt.Printf("Could not obtain token: %v\n", err)
+		os.Exit(1)
+	}
+
+	service, err := photoslibrary.New(conf.Client(ctx, token))
+	if err == nil {
+		err = util.VerifyPhotosAccess(service)
+	}
 	if err != nil {
+		fmt.Printf("Could not validate token: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Writing token to %s", args[1])
+	if err := util.WriteToken(args[1], token); err != nil {
 		fmt.Printf("Could not write token file: %v", err)
 		os.Exit(1)
 	}
-	json.NewEncoder(f).Encode(client.Token)
 }
@@ -1,26 +1,59 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"github.com/cfagiani/gomosaic/indexer"
 	"os"
+	"os/signal"
+	"syscall"
 )
 
 //This command will run the mosaic indexer on all the directories passed in via the command line. The index will be
-//written to the output directory as specified on the command line.
+//written to the output directory as specified on the command line. With -watch, it keeps running after indexing and
+//updates the index as the files in local sources change, until it is interrupted.
 func main() {
-	if len(os.Args) < 3 {
+	duplicates := flag.Int("duplicates", -1,
+		"after indexing, list the groups of tiles whose perceptual hashes differ in at most this many bits")
+	checkpoint := flag.Duration("checkpoint", indexer.DefaultCheckpointInterval,
+		"how often to save the images analyzed so far so an interrupted run can resume (0 disables checkpoints)")
+	watch := flag.Bool("watch", false, "keep the index up to date as files in local sources change until interrupted")
+	debounce := flag.Duration("debounce", indexer.DefaultDebounce,
+		"when watching, how long to wait after the last change before updating the index")
+	poll := flag.Duration("poll", 0,
+		"when watching, scan for changes at this interval instead of having the operating system report them")
+	flag.Usage = usage
+	flag.Parse()
+	args := flag.Args()
+	if len(args) < 2 {
 		usage()
 		os.Exit(1)
 	}
-	err := indexer.Index(os.Args[1], os.Args[2])
+	var err error
+	if *watch {
+		stop := make(chan struct{})
+		interrupted := make(chan os.Signal, 1)
+		signal.Notify(interrupted, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-interrupted
+			close(stop)
+		}()
+		err = indexer.Watch(args[0], args[1], indexer.WatchOptions{Debounce: *debounce, PollInterval: *poll, Poll: *poll > 0,
+			CheckpointInterval: *checkpoint}, stop)
+	} else {
+		err = indexer.IndexWithCheckpoints(args[0], args[1], *checkpoint)
+	}
 	if err != nil {
 		fmt.Println("Error while indexing %v", err)
 		os.Exit(1)
 	}
+	if *duplicates >= 0 {
+		indexer.DuplicateReport(os.Stdout, indexer.ReadIndex(args[1]), *duplicates)
+	}
 }
 
 func usage() {
 	fmt.Println("Too few command line arguments.\n\nUsage:\n")
-	fmt.Println("indexer <configFile> <indexFile>\n")
+	fmt.Println("indexer [flags] <configFile> <indexFile>\n")
+	flag.PrintDefaults()
 }
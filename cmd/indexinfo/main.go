@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/cfagiani/gomosaic/indexer"
+	"github.com/cfagiani/gomosaic/mosaicimages"
+	"os"
+)
+
+//This command prints a summary of an index: the number of tiles by location and source and a histogram of their
+//average colors. Given a target image and grid size, it also reports the colors of the segments that have no tile
+//within a distance threshold.
+func main() {
+	bins := flag.Int("bins", 4, "number of ranges each color channel is divided into for the histogram")
+	target := flag.String("image", "", "target image whose segments are checked for a close tile")
+	gridSize := flag.Int("grid", 10, "size, in target image pixels, of each grid cell")
+	layout := flag.String("layout", mosaicimages.SquareLayout, "arrangement of the grid cells: square, brick or hex")
+	threshold := flag.Float64("threshold", 24, "largest acceptable distance (in 8 bit color units) between a segment and its closest tile")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() != 1 || *bins < 1 || *bins > 16 || *gridSize < 1 {
+		usage()
+		os.Exit(1)
+	}
+	filename, exists := indexer.GetIndexFileName(flag.Arg(0))
+	if !exists {
+		fmt.Printf("Index does not exist at %s\n", flag.Arg(0))
+		os.Exit(1)
+	}
+	index, err := indexer.LoadIndex(filename)
+	if err != nil {
+		fmt.Printf("Could not read index: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Index %s\n", filename)
+	indexer.WriteStats(os.Stdout, index, *bins)
+
+	if len(*target) > 0 {
+		if err := mosaicimages.ValidateLayout(*layout); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		segments, _, _, err := mosaicimages.SegmentImage(*target, *gridSize, *layout)
+		if err != nil {
+			fmt.Printf("Could not read %s: %v\n", *target, err)
+			os.Exit(1)
+		}
+		fmt.Printf("\nCoverage of %s:\n", *target)
+		indexer.WriteCoverageGaps(os.Stdout, indexer.FindCoverageGaps(segments, index, *threshold), len(segments),
+			*threshold, *bins)
+	}
+}
+
+func usage() {
+	fmt.Print("Usage:\n\n")
+	fmt.Print("indexinfo [flags] <indexFile>\n\n")
+	flag.PrintDefaults()
+}
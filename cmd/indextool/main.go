@@ -0,0 +1,236 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/cfagiani/gomosaic"
+	"github.com/cfagiani/gomosaic/indexer"
+	"os"
+	"sort"
+	"strings"
+)
+
+//This command combines, filters, splits and converts indexes without re-indexing the images they reference:
+//merge writes the tiles of several indexes into one, filter writes the tiles of an index that match some conditions
+//to a new index, split divides an index into several and convert rewrites an index in the text or binary format.
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(1)
+	}
+	var err error
+	switch os.Args[1] {
+	case "merge":
+		err = merge(os.Args[2:])
+	case "filter":
+		err = filter(os.Args[2:])
+	case "split":
+		err = split(os.Args[2:])
+	case "convert":
+		err = convert(os.Args[2:])
+	default:
+		usage()
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+//merge writes the tiles of the input indexes to the output index, leaving out duplicates.
+func merge(args []string) error {
+	if len(args) < 2 {
+		usage()
+		os.Exit(1)
+	}
+	var indexes []gomosaic.MosaicTiles
+	for _, input := range args[1:] {
+		index, err := readIndex(input)
+		if err != nil {
+			return err
+		}
+		indexes = append(indexes, index)
+	}
+	merged := indexer.MergeIndexes(indexes...)
+	fmt.Printf("Writing %d tiles to %s\n", len(merged), args[0])
+	return indexer.WriteIndex(args[0], merged)
+}
+
+//filter writes the tiles of the input index selected by the flags to the output index.
+func filter(args []string) error {
+	flags := flag.NewFlagSet("filter", flag.ExitOnError)
+	locs := flags.String("loc", "", "comma separated location codes of the tiles to keep (e.g. L,G)")
+	sources := flags.String("source", "", "comma separated prefixes of the sources of the tiles to keep (e.g. a directory)")
+	patterns := flags.String("pattern", "", "comma separated glob patterns of the names of the tiles to keep")
+	colors := flags.String("color", "", "range of average colors of the tiles to keep, as r,g,b-r,g,b")
+	invert := flags.Bool("exclude", false, "keep the tiles that don't match instead")
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		usage()
+		os.Exit(1)
+	}
+	f := indexer.TileFilter{Locs: splitList(*locs), Sources: splitList(*sources), Patterns: splitList(*patterns),
+		Invert: *invert}
+	if len(*colors) > 0 {
+		colorRange, err := indexer.ParseColorRange(*colors)
+		if err != nil {
+			return err
+		}
+		f.Colors = &colorRange
+	}
+	if err := f.Validate(); err != nil {
+		return err
+	}
+	index, err := readIndex(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	filtered := indexer.FilterIndex(index, f)
+	fmt.Printf("Writing %d of %d tiles to %s\n", len(filtered), len(index), flags.Arg(1))
+	return indexer.WriteIndex(flags.Arg(1), filtered)
+}
+
+//split divides the input index into several, written to files named after the output prefix.
+func split(args []string) error {
+	flags := flag.NewFlagSet("split", flag.ExitOnError)
+	parts := flags.Int("parts", 0, "number of indexes of (nearly) equal size to split the index into")
+	by := flags.String("by", "", "split the index by loc (location code) or source instead")
+	flags.Parse(args)
+	if flags.NArg() != 2 || (*parts > 0) == (len(*by) > 0) {
+		usage()
+		os.Exit(1)
+	}
+	index, err := readIndex(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	prefix := flags.Arg(1)
+	if *parts > 0 {
+		for i, part := range indexer.SplitIndex(index, *parts) {
+			if err := writePart(fmt.Sprintf("%s-%d.dat", prefix, i+1), part); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	var split map[string]gomosaic.MosaicTiles
+	switch *by {
+	case "loc":
+		split = indexer.SplitIndexBy(index, func(tile gomosaic.MosaicTile) string { return tile.Loc })
+	case "source":
+		split = indexer.SplitIndexBy(index, indexer.TileSource)
+	default:
+		return fmt.Errorf("cannot split by %q: use loc or source", *by)
+	}
+	keys := make([]string, 0, len(split))
+	for key := range split {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	used := make(map[string]bool)
+	for _, key := range keys {
+		name := fmt.Sprintf("%s-%s.dat", prefix, fileSafe(key))
+		for n := 2; used[name]; n++ {
+			name = fmt.Sprintf("%s-%s-%d.dat", prefix, fileSafe(key), n)
+		}
+		used[name] = true
+		fmt.Printf("%s: ", key)
+		if err := writePart(name, split[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//convert rewrites the input index in the format passed in. Any format can be read, so the input can be a text or
+//binary index or a database.
+func convert(args []string) error {
+	flags := flag.NewFlagSet("convert", flag.ExitOnError)
+	format := flags.String("format", "binary", "format of the output index: text, binary or db (an embedded database)")
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		usage()
+		os.Exit(1)
+	}
+	index, err := readIndex(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Writing %d tiles to %s\n", len(index), flags.Arg(1))
+	switch *format {
+	case "text":
+		return indexer.WriteIndex(flags.Arg(1), index)
+	case "binary":
+		return indexer.WriteBinaryIndex(flags.Arg(1), index)
+	case "db":
+		return writeDatabase(flags.Arg(1), index)
+	}
+	return fmt.Errorf("unknown format %q: use text, binary or db", *format)
+}
+
+//writeDatabase replaces the tiles of the database at dest, whose name must end in indexer.BoltExtension, with those of
+//the index. An existing file that isn't a database is left alone.
+func writeDatabase(dest string, index gomosaic.MosaicTiles) error {
+	if !strings.HasSuffix(dest, indexer.BoltExtension) {
+		return fmt.Errorf("the name of a database must end in %s", indexer.BoltExtension)
+	}
+	if !indexer.IsBoltIndex(dest) {
+		return fmt.Errorf("%s already exists and is not a database", dest)
+	}
+	store, err := indexer.OpenStore(dest)
+	if err != nil {
+		return err
+	}
+	oldIndex, err := indexer.ReadStore(store)
+	if err == nil {
+		_, _, err = indexer.UpdateStore(store, oldIndex, index)
+	}
+	if closeErr := store.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func writePart(name string, part gomosaic.MosaicTiles) error {
+	fmt.Printf("writing %d tiles to %s\n", len(part), name)
+	return indexer.WriteIndex(name, part)
+}
+
+//readIndex reads an index, returning an error if it doesn't exist or can't be read.
+func readIndex(name string) (gomosaic.MosaicTiles, error) {
+	filename, exists := indexer.GetIndexFileName(name)
+	if !exists {
+		return nil, fmt.Errorf("index does not exist at %s", name)
+	}
+	return indexer.LoadIndex(filename)
+}
+
+//splitList splits a comma separated list, ignoring blank elements.
+func splitList(s string) []string {
+	var values []string
+	for _, value := range strings.Split(s, ",") {
+		if value = strings.TrimSpace(value); len(value) > 0 {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+//fileSafe replaces the characters of a key that can't safely be used in a file name.
+func fileSafe(key string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' || r == '.' {
+			return r
+		}
+		return '_'
+	}, strings.Trim(key, "/"))
+}
+
+func usage() {
+	fmt.Print("Usage:\n\n")
+	fmt.Println("indextool merge <outputIndex> <index>...")
+	fmt.Println("indextool filter [-loc L,G] [-source prefix] [-pattern glob] [-color r,g,b-r,g,b] [-exclude] <index> <outputIndex>")
+	fmt.Println("indextool split (-parts n | -by loc|source) <index> <outputPrefix>")
+	fmt.Print("indextool convert [-format text|binary|db] <index> <outputIndex>\n\n")
+}
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"github.com/cfagiani/gomosaic/mosaicimages"
 	"github.com/cfagiani/gomosaic/mosaicmaker"
+	"github.com/cfagiani/gomosaic/tilecache"
 	"os"
 	"strconv"
 	"github.com/cfagiani/gomosaic/util"
@@ -10,21 +13,47 @@ import (
 
 //This command wil run the mosaic maker. It assumes that we have already computed an index to use for tiles.
 func main() {
-	if len(os.Args) < 6 {
+	var options mosaicmaker.Options
+	flag.StringVar(&options.Layout, "layout", mosaicimages.SquareLayout, "arrangement of tiles: square, brick or hex")
+	flag.IntVar(&options.Columns, "columns", 0, "number of tiles across the mosaic (used when gridSize is 0)")
+	flag.IntVar(&options.Rows, "rows", 0, "number of tiles down the mosaic (used when gridSize is 0)")
+	flag.IntVar(&options.OutputWidth, "width", 0, "width of the mosaic in pixels (used when tileSize is 0)")
+	flag.IntVar(&options.OutputHeight, "height", 0, "height of the mosaic in pixels (used when tileSize is 0)")
+	flag.Float64Var(&options.PrintWidth, "print-width", 0, "printed width of the mosaic (used when tileSize is 0)")
+	flag.Float64Var(&options.PrintHeight, "print-height", 0, "printed height of the mosaic (used when tileSize is 0)")
+	flag.StringVar(&options.PrintUnit, "unit", mosaicmaker.Inches, "unit of the print size: in or cm")
+	flag.IntVar(&options.DPI, "dpi", 300, "print resolution in dots per inch")
+	flag.IntVar(&options.Workers, "workers", 0, "number of tiles to match and render concurrently (defaults to the number of CPUs)")
+	defaultCacheDir, _ := tilecache.DefaultDir()
+	flag.StringVar(&options.CacheDir, "cache-dir", defaultCacheDir, "directory used to cache resized tiles")
+	cacheSizeMB := flag.Int64("cache-size", tilecache.DefaultMaxBytes>>20, "maximum size of the tile cache in MB")
+	noCache := flag.Bool("no-cache", false, "do not read or write the tile cache")
+	flag.IntVar(&options.DuplicateDistance, "duplicate-distance", 0,
+		"tiles whose perceptual hashes differ in at most this many bits are only used once between them (negative to disable)")
+	flag.Usage = usage
+	flag.Parse()
+	args := flag.Args()
+	if len(args) < 5 {
 		usage()
 		os.Exit(1)
 	}
-	gridSize, _ := strconv.Atoi(os.Args[3])
-	tileSize, _ := strconv.Atoi(os.Args[4])
+	options.CacheSize = *cacheSizeMB << 20
+	if *noCache {
+		options.CacheDir = ""
+	}
+	options.GridSize, _ = strconv.Atoi(args[2])
+	options.TileSize, _ = strconv.Atoi(args[3])
 	configFile := ""
-	if len(os.Args) == 7 {
-		configFile = os.Args[6]
+	if len(args) == 6 {
+		configFile = args[5]
 	}
-	err := mosaicmaker.MakeMosaic(os.Args[1], os.Args[2], gridSize, tileSize, os.Args[5], configFile)
+	err := mosaicmaker.MakeMosaic(args[0], args[1], options, args[4], configFile)
 	util.CheckError(err, "Could create mosaic", true)
 }
 
 func usage() {
 	fmt.Println("Too few command line arguments.\n\nUsage:\n")
-	fmt.Println("mosaicmaker <sourceImage> <index> <gridSize> <tileSize> <outputFile>\n")
+	fmt.Println("mosaicmaker [flags] <sourceImage> <index> <gridSize> <tileSize> <outputFile> [configFile]\n")
+	fmt.Println("Use 0 for gridSize or tileSize to derive them from the flags below.\n")
+	flag.PrintDefaults()
 }
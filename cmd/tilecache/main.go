@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"github.com/cfagiani/gomosaic/tilecache"
+	"os"
+)
+
+//This command manages the on-disk cache of resized tiles used by mosaicmaker. Currently the only supported operation is
+//clear, which removes every cached tile.
+func main() {
+	if len(os.Args) < 2 || len(os.Args) > 3 || os.Args[1] != "clear" {
+		usage()
+		os.Exit(1)
+	}
+	dir, err := tilecache.DefaultDir()
+	if len(os.Args) == 3 {
+		dir, err = os.Args[2], nil
+	}
+	if err != nil {
+		fmt.Printf("Could not determine cache directory: %v\n", err)
+		os.Exit(1)
+	}
+	freed, err := tilecache.Clear(dir)
+	if err != nil {
+		fmt.Printf("Could not clear cache: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Removed %d bytes of cached tiles from %s\n", freed, dir)
+}
+
+func usage() {
+	fmt.Print("Usage:\n\n")
+	fmt.Print("tilecache clear [cacheDir]\n\n")
+}
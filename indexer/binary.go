@@ -0,0 +1,155 @@
+package indexer
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/cfagiani/gomosaic"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+//The binary index starts with a header holding binaryMagic, the number of tiles and the length of the string table.
+//It is followed by a fixed-size record for each tile and then by the string table, which holds the location codes and
+//file names the records point to. All numbers are little endian.
+const (
+	//identifies a binary index; the last byte is the version of the format
+	binaryMagic = "GMOSIDX\x02"
+	//magic, tile count and string table length
+	binaryHeaderSize = 8 + 8 + 8
+	//offset and length of the location code, offset and length of the file name, the average color, the hash and
+	//whether the tile has a hash
+	binaryRecordSize = 8 + 4 + 8 + 4 + 3*4 + 8 + 1
+	//version 1 had no hash flag, a zero hash meant the tile wasn't hashed
+	binaryMagicV1      = "GMOSIDX\x01"
+	binaryRecordSizeV1 = binaryRecordSize - 1
+)
+
+//IsBinaryIndex returns true if the index at source (see GetIndexFileName) exists and is in the binary format.
+func IsBinaryIndex(source string) bool {
+	filename, exists := GetIndexFileName(source)
+	if !exists {
+		return false
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	magic := make([]byte, len(binaryMagic))
+	_, err = io.ReadFull(f, magic)
+	return err == nil && (string(magic) == binaryMagic || string(magic) == binaryMagicV1)
+}
+
+//WriteBinaryIndex writes the index to dest, or to the default index file in dest if it is a directory, in the binary
+//format, replacing it atomically (see writeIndexFile). Location codes are stored once in the string table, whatever
+//the number of tiles using them.
+func WriteBinaryIndex(dest string, index gomosaic.MosaicTiles) error {
+	filename, _ := GetIndexFileName(dest)
+	var table strings.Builder
+	locs := make(map[string]uint64)
+	records := make([]byte, 0, len(index)*binaryRecordSize)
+	record := make([]byte, binaryRecordSize)
+	count := 0
+	for _, tile := range index {
+		if tile.Filename == "" {
+			continue
+		}
+		locOffset, found := locs[tile.Loc]
+		if !found {
+			locOffset = uint64(table.Len())
+			locs[tile.Loc] = locOffset
+			table.WriteString(tile.Loc)
+		}
+		binary.LittleEndian.PutUint64(record, locOffset)
+		binary.LittleEndian.PutUint32(record[8:], uint32(len(tile.Loc)))
+		binary.LittleEndian.PutUint64(record[12:], uint64(table.Len()))
+		binary.LittleEndian.PutUint32(record[20:], uint32(len(tile.Filename)))
+		binary.LittleEndian.PutUint32(record[24:], tile.AvgR)
+		binary.LittleEndian.PutUint32(record[28:], tile.AvgG)
+		binary.LittleEndian.PutUint32(record[32:], tile.AvgB)
+		binary.LittleEndian.PutUint64(record[36:], tile.Hash)
+		record[44] = 0
+		if tile.HasHash {
+			record[44] = 1
+		}
+		records = append(records, record...)
+		table.WriteString(tile.Filename)
+		count++
+	}
+
+	return writeIndexFile(filename, func(w *bufio.Writer) error {
+		header := append([]byte(binaryMagic), make([]byte, binaryHeaderSize-len(binaryMagic))...)
+		binary.LittleEndian.PutUint64(header[8:], uint64(count))
+		binary.LittleEndian.PutUint64(header[16:], uint64(table.Len()))
+		w.Write(header)
+		w.Write(records)
+		_, err := w.WriteString(table.String())
+		return err
+	})
+}
+
+//ReadBinaryIndex reads an index in the binary format with a single read of the whole file. The location codes and
+//file names of the tiles all share the memory of one copy of the string table rather than being allocated one by one.
+func ReadBinaryIndex(source string) (gomosaic.MosaicTiles, error) {
+	filename, _ := GetIndexFileName(source)
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return decodeBinaryIndex(data)
+}
+
+//decodeBinaryIndex returns the tiles of a binary index, checking that the sizes and offsets it holds are consistent.
+//Indexes in version 1 of the format are still read.
+func decodeBinaryIndex(data []byte) (gomosaic.MosaicTiles, error) {
+	if len(data) < binaryHeaderSize {
+		return nil, errors.New("not a binary index")
+	}
+	var recordSize int
+	switch string(data[:len(binaryMagic)]) {
+	case binaryMagic:
+		recordSize = binaryRecordSize
+	case binaryMagicV1:
+		recordSize = binaryRecordSizeV1
+	default:
+		return nil, errors.New("not a binary index")
+	}
+	count := binary.LittleEndian.Uint64(data[8:])
+	tableLen := binary.LittleEndian.Uint64(data[16:])
+	available := uint64(len(data) - binaryHeaderSize)
+	if count > available/uint64(recordSize) || tableLen != available-count*uint64(recordSize) {
+		return nil, fmt.Errorf("binary index of %d bytes cannot hold %d tiles and a %d byte string table", len(data),
+			count, tableLen)
+	}
+	recordsEnd := binaryHeaderSize + int(count)*recordSize
+	table := string(data[recordsEnd:])
+	tableString := func(offset uint64, length uint32) (string, bool) {
+		if offset > tableLen || uint64(length) > tableLen-offset {
+			return "", false
+		}
+		return table[offset : offset+uint64(length)], true
+	}
+
+	index := make(gomosaic.MosaicTiles, count)
+	for i := range index {
+		record := data[binaryHeaderSize+i*recordSize:]
+		loc, locOK := tableString(binary.LittleEndian.Uint64(record), binary.LittleEndian.Uint32(record[8:]))
+		name, nameOK := tableString(binary.LittleEndian.Uint64(record[12:]), binary.LittleEndian.Uint32(record[20:]))
+		if !locOK || !nameOK {
+			return nil, fmt.Errorf("record %d of the binary index points outside the string table", i)
+		}
+		index[i] = gomosaic.MosaicTile{Loc: loc, Filename: name, AvgR: binary.LittleEndian.Uint32(record[24:]),
+			AvgG: binary.LittleEndian.Uint32(record[28:]), AvgB: binary.LittleEndian.Uint32(record[32:]),
+			Hash: binary.LittleEndian.Uint64(record[36:])}
+		if recordSize == binaryRecordSizeV1 {
+			index[i].HasHash = index[i].Hash != 0
+		} else {
+			index[i].HasHash = record[44] != 0
+		}
+	}
+	return index, nil
+}
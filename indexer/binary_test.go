@@ -0,0 +1,158 @@
+package indexer
+
+import (
+	"fmt"
+	"github.com/cfagiani/gomosaic"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+//TestBinaryIndex verifies that an index written in the binary format is read back unchanged, by ReadBinaryIndex and by
+//ReadIndex, and that re-indexing keeps the binary format.
+func TestBinaryIndex(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "binaryindex")
+	defer os.RemoveAll(dir)
+	index := gomosaic.MosaicTiles{{Loc: "L", Filename: "/photos/a;b.jpg", AvgR: 1, AvgG: 2, AvgB: 65535},
+		{Loc: "G", Filename: "id1", Hash: 0xfedcba9876543210, HasHash: true}, {Loc: "L", Filename: ""},
+		{Loc: "S", Filename: "s3://bucket/key#etag", AvgR: 4}, {Loc: "L", Filename: "/photos/c.png", AvgG: 5},
+		{Loc: "L", Filename: "/photos/zero.png", HasHash: true}}
+	expected := gomosaic.MosaicTiles{index[0], index[1], index[3], index[4], index[5]}
+	cases := []struct {
+		index    gomosaic.MosaicTiles
+		expected gomosaic.MosaicTiles
+	}{
+		{index, expected},
+		{gomosaic.MosaicTiles{}, gomosaic.MosaicTiles{}},
+	}
+	for i, c := range cases {
+		dest := filepath.Join(dir, fmt.Sprintf("index%d.dat", i))
+		if err := WriteBinaryIndex(dest, c.index); err != nil {
+			t.Fatalf("WriteBinaryIndex returned %v", err)
+		}
+		if !IsBinaryIndex(dest) {
+			t.Errorf("IsBinaryIndex(%q) returned false for a binary index", dest)
+		}
+		read, err := ReadBinaryIndex(dest)
+		if err != nil || !reflect.DeepEqual(read, c.expected) {
+			t.Errorf("ReadBinaryIndex returned %v, %v but %v was expected", read, err, c.expected)
+		}
+		if read := ReadIndex(dest); !reflect.DeepEqual(read, c.expected) {
+			t.Errorf("ReadIndex returned %v but %v was expected", read, c.expected)
+		}
+	}
+	if IsBinaryIndex("../testdata/testindex.dat") || IsBinaryIndex(filepath.Join(dir, "notthere")) {
+		t.Errorf("IsBinaryIndex returned true for an index that isn't binary")
+	}
+
+	dest := filepath.Join(dir, "reindexed.dat")
+	WriteBinaryIndex(dest, index)
+	if err := Index("../testdata/testconfig.json", dest); err != nil {
+		t.Fatalf("Could not index files %v", err)
+	}
+	if read, err := ReadBinaryIndex(dest); err != nil || len(read) != 4 {
+		t.Errorf("Re-indexing a binary index returned %d tiles, %v", len(read), err)
+	}
+}
+
+//TestDecodeBinaryIndex verifies that truncated and inconsistent binary indexes are rejected.
+func TestDecodeBinaryIndex(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "binaryindex")
+	defer os.RemoveAll(dir)
+	dest := filepath.Join(dir, "index.dat")
+	WriteBinaryIndex(dest, gomosaic.MosaicTiles{{Loc: "L", Filename: "a.jpg"}, {Loc: "L", Filename: "b.jpg"}})
+	data, _ := ioutil.ReadFile(dest)
+
+	corrupt := func(offset int, value byte) []byte {
+		copied := append([]byte{}, data...)
+		copied[offset] = value
+		return copied
+	}
+	cases := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", []byte{}},
+		{"text", []byte("L;a.jpg;1;2;3\n")},
+		{"truncated", data[:len(data)-1]},
+		{"extra byte", append(append([]byte{}, data...), 0)},
+		{"huge count", corrupt(15, 0xff)},
+		{"version", corrupt(7, 3)},
+		{"name offset", corrupt(binaryHeaderSize+12, 0xff)},
+		{"name length", corrupt(binaryHeaderSize+20, 0xff)},
+	}
+	if _, err := decodeBinaryIndex(data); err != nil {
+		t.Fatalf("decodeBinaryIndex rejected a valid index: %v", err)
+	}
+	for _, c := range cases {
+		if _, err := decodeBinaryIndex(c.data); err == nil {
+			t.Errorf("decodeBinaryIndex should have rejected the %s index", c.name)
+		}
+	}
+}
+
+//TestDecodeBinaryIndexV1 verifies that indexes written before the hash flag was added are still read, a zero hash
+//meaning that the tile wasn't hashed.
+func TestDecodeBinaryIndexV1(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "binaryindex")
+	defer os.RemoveAll(dir)
+	dest := filepath.Join(dir, "index.dat")
+	index := gomosaic.MosaicTiles{{Loc: "L", Filename: "a.jpg", AvgR: 1, Hash: 0xff, HasHash: true},
+		{Loc: "L", Filename: "b.jpg", AvgG: 2}}
+	WriteBinaryIndex(dest, index)
+	data, _ := ioutil.ReadFile(dest)
+
+	//drop the flag at the end of each record
+	v1 := append([]byte(binaryMagicV1), data[len(binaryMagicV1):binaryHeaderSize]...)
+	for i := range index {
+		record := data[binaryHeaderSize+i*binaryRecordSize:]
+		v1 = append(v1, record[:binaryRecordSizeV1]...)
+	}
+	v1 = append(v1, data[binaryHeaderSize+len(index)*binaryRecordSize:]...)
+	read, err := decodeBinaryIndex(v1)
+	if err != nil || !reflect.DeepEqual(read, index) {
+		t.Errorf("decodeBinaryIndex returned %v, %v for a version 1 index but %v was expected", read, err, index)
+	}
+}
+
+//benchmarkIndex writes an index of n tiles in both formats and returns the names of the text and binary files.
+func benchmarkIndex(b *testing.B, dir string, n int) (string, string) {
+	index := make(gomosaic.MosaicTiles, n)
+	for i := range index {
+		index[i] = gomosaic.MosaicTile{Loc: "L", Filename: fmt.Sprintf("/home/user/photos/%04d/IMG_%06d.jpg", i/1000, i),
+			AvgR: uint32(i * 7 % 65536), AvgG: uint32(i * 13 % 65536), AvgB: uint32(i * 31 % 65536),
+			Hash: uint64(i) * 0x9e3779b97f4a7c15, HasHash: true}
+	}
+	text, bin := filepath.Join(dir, "index.dat"), filepath.Join(dir, "index.bin")
+	if err := WriteIndex(text, index); err != nil {
+		b.Fatal(err)
+	}
+	if err := WriteBinaryIndex(bin, index); err != nil {
+		b.Fatal(err)
+	}
+	return text, bin
+}
+
+func BenchmarkReadIndexText(b *testing.B) {
+	dir, _ := ioutil.TempDir("", "binaryindex")
+	defer os.RemoveAll(dir)
+	text, _ := benchmarkIndex(b, dir, 100000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ReadIndex(text)
+	}
+}
+
+func BenchmarkReadIndexBinary(b *testing.B) {
+	dir, _ := ioutil.TempDir("", "binaryindex")
+	defer os.RemoveAll(dir)
+	_, bin := benchmarkIndex(b, dir, 100000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ReadIndex(bin)
+	}
+}
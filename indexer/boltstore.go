@@ -0,0 +1,159 @@
+package indexer
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/cfagiani/gomosaic"
+	bolt "go.etcd.io/bbolt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	//indexes whose file name ends with this are kept in an embedded database
+	BoltExtension = ".db"
+	//bucket holding the tiles, keyed by tileKey
+	tilesBucket = "tiles"
+	//the average color, the hash and whether the tile has a hash
+	boltValueSize = 3*4 + 8 + 1
+	//values written before the hash flag was added, where a zero hash meant the tile wasn't hashed
+	boltValueSizeV1 = boltValueSize - 1
+	//identifies a bbolt database; it follows the 16 byte header of the first page
+	boltMagic = 0xED0CDAED
+)
+
+//BoltStore keeps an index in an embedded bbolt database so that tiles can be upserted and deleted without rewriting
+//the whole index. Each call to Upsert or Delete is a single transaction.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+//IsBoltIndex returns true if the index at filename is kept in an embedded database: either the file is a database or it
+//doesn't exist yet and its name ends with BoltExtension. Other files are flat indexes, whatever their name.
+func IsBoltIndex(filename string) bool {
+	f, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return strings.HasSuffix(filename, BoltExtension)
+	}
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	header := make([]byte, 20)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return false
+	}
+	//the database is written in the byte order of the machine that created it
+	return binary.LittleEndian.Uint32(header[16:]) == boltMagic || binary.BigEndian.Uint32(header[16:]) == boltMagic
+}
+
+//OpenBoltStore opens the database in filename, creating it if it doesn't exist. It fails if another process has the
+//database open.
+func OpenBoltStore(filename string) (*BoltStore, error) {
+	db, err := openBolt(filename, false)
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(tilesBucket))
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStore{db: db}, nil
+}
+
+//readBoltIndex reads all the tiles of an existing database, opening it read only.
+func readBoltIndex(filename string) (gomosaic.MosaicTiles, error) {
+	db, err := openBolt(filename, true)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	return ReadStore(&BoltStore{db: db})
+}
+
+//openBolt opens the database in filename, waiting a second for a process that has it open (for instance a running
+//indexer) to release it.
+func openBolt(filename string, readOnly bool) (*bolt.DB, error) {
+	db, err := bolt.Open(filename, 0644, &bolt.Options{Timeout: time.Second, ReadOnly: readOnly})
+	if err == bolt.ErrTimeout {
+		return nil, fmt.Errorf("index database %s is in use by another process", filename)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not open index database %s: %v", filename, err)
+	}
+	return db, nil
+}
+
+func (s *BoltStore) Upsert(tiles ...gomosaic.MosaicTile) error {
+	if len(tiles) == 0 {
+		return nil
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(tilesBucket))
+		for _, tile := range tiles {
+			value := make([]byte, boltValueSize)
+			binary.LittleEndian.PutUint32(value, tile.AvgR)
+			binary.LittleEndian.PutUint32(value[4:], tile.AvgG)
+			binary.LittleEndian.PutUint32(value[8:], tile.AvgB)
+			binary.LittleEndian.PutUint64(value[12:], tile.Hash)
+			if tile.HasHash {
+				value[20] = 1
+			}
+			if err := bucket.Put([]byte(tileKey(tile)), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltStore) Delete(tiles ...gomosaic.MosaicTile) error {
+	if len(tiles) == 0 {
+		return nil
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(tilesBucket))
+		for _, tile := range tiles {
+			if err := bucket.Delete([]byte(tileKey(tile))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltStore) Iterate(fn func(tile gomosaic.MosaicTile) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(tilesBucket))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(key []byte, value []byte) error {
+			//keys and values are only valid during the transaction so they are copied
+			parts := strings.SplitN(string(key), "\x00", 2)
+			if len(parts) != 2 || (len(value) != boltValueSize && len(value) != boltValueSizeV1) {
+				return errors.New("invalid tile in database: " + string(key))
+			}
+			tile := gomosaic.MosaicTile{Loc: parts[1], Filename: parts[0],
+				AvgR: binary.LittleEndian.Uint32(value), AvgG: binary.LittleEndian.Uint32(value[4:]),
+				AvgB: binary.LittleEndian.Uint32(value[8:]), Hash: binary.LittleEndian.Uint64(value[12:])}
+			if len(value) == boltValueSizeV1 {
+				tile.HasHash = tile.Hash != 0
+			} else {
+				tile.HasHash = value[20] != 0
+			}
+			return fn(tile)
+		})
+	})
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,123 @@
+package indexer
+
+import (
+	"bytes"
+	"github.com/cfagiani/gomosaic"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	//appended to the name of an index to get the name of its checkpoint
+	checkpointSuffix = ".checkpoint"
+	//how often the tiles analyzed during a run are saved by default
+	DefaultCheckpointInterval = time.Minute
+)
+
+//checkpoint saves the tiles analyzed during a run so that, if the indexer stops before it writes the index, the next
+//run doesn't have to analyze them again. Tiles are appended to the checkpoint file in the text format at most every
+//interval, so a crash loses at most interval's worth of work. A crash while appending can only leave a partial last
+//line, which is ignored when resuming.
+type checkpoint struct {
+	filename string
+	interval time.Duration
+	mu       sync.Mutex
+	//tiles recorded since the last save
+	pending gomosaic.MosaicTiles
+	saved   time.Time
+}
+
+//checkpointFileName returns the name of the checkpoint of the index in filename.
+func checkpointFileName(filename string) string {
+	return filename + checkpointSuffix
+}
+
+func newCheckpoint(filename string, interval time.Duration) *checkpoint {
+	return &checkpoint{filename: filename, interval: interval, saved: time.Now()}
+}
+
+//record is a processor.TileRecorder that saves the tiles recorded so far once interval has passed since the last save.
+func (c *checkpoint) record(tile gomosaic.MosaicTile) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pending = append(c.pending, tile)
+	if time.Since(c.saved) >= c.interval {
+		c.saveLocked()
+	}
+}
+
+//save appends the tiles recorded since the last save to the checkpoint file.
+func (c *checkpoint) save() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.saveLocked()
+}
+
+func (c *checkpoint) saveLocked() {
+	c.saved = time.Now()
+	if len(c.pending) == 0 {
+		return
+	}
+	f, err := os.OpenFile(c.filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		log.Printf("Could not save checkpoint: %v\n", err)
+		return
+	}
+	var lines []byte
+	for _, tile := range c.pending {
+		lines = append(lines, tile.ToString()+"\n"...)
+	}
+	_, err = f.Write(lines)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		//the tiles are kept so they are saved again with the next ones
+		log.Printf("Could not save checkpoint: %v\n", err)
+		return
+	}
+	c.pending = nil
+}
+
+//remove deletes the checkpoint file once the index it belongs to has been written.
+func (c *checkpoint) remove() {
+	if err := os.Remove(c.filename); err != nil && !os.IsNotExist(err) {
+		log.Printf("Could not remove checkpoint: %v\n", err)
+	}
+}
+
+//resumeFromCheckpoint returns the old index along with the tiles in the checkpoint file, if there is one, sorted by
+//file name. Tiles from the checkpoint replace those of the old index with the same location code and file name.
+func resumeFromCheckpoint(filename string, oldIndex gomosaic.MosaicTiles) gomosaic.MosaicTiles {
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return oldIndex
+	}
+	if err != nil {
+		log.Printf("Could not read checkpoint: %v\n", err)
+		return oldIndex
+	}
+	//every tile saved is followed by a line break, so anything after the last one was cut short and could hold a
+	//truncated number that still parses
+	saved := readTiles(bytes.NewReader(data[:bytes.LastIndexByte(data, '\n')+1]), filename)
+	log.Printf("Resuming from a checkpoint of %d entries\n", len(saved))
+	tiles := make(map[string]gomosaic.MosaicTile, len(oldIndex)+len(saved))
+	for _, index := range []gomosaic.MosaicTiles{oldIndex, saved} {
+		for _, tile := range index {
+			tiles[tileKey(tile)] = tile
+		}
+	}
+	resumed := make(gomosaic.MosaicTiles, 0, len(tiles))
+	for _, tile := range tiles {
+		resumed = append(resumed, tile)
+	}
+	sort.Slice(resumed, func(i, j int) bool { return tileKey(resumed[i]) < tileKey(resumed[j]) })
+	return resumed
+}
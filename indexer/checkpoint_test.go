@@ -0,0 +1,75 @@
+package indexer
+
+import (
+	"github.com/cfagiani/gomosaic"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+//TestCheckpoint verifies that recorded tiles are only saved once the interval has passed or save is called.
+func TestCheckpoint(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "checkpoint")
+	defer os.RemoveAll(dir)
+	a := gomosaic.MosaicTile{Loc: "L", Filename: "a.jpg", AvgR: 1, AvgG: 2, AvgB: 3}
+	b := gomosaic.MosaicTile{Loc: "G", Filename: "b", Hash: 0xff, HasHash: true}
+	cases := []struct {
+		interval time.Duration
+		record   gomosaic.MosaicTiles
+		save     bool
+		expected int
+	}{
+		{time.Hour, gomosaic.MosaicTiles{a, b}, false, 0},
+		{time.Hour, gomosaic.MosaicTiles{a, b}, true, 2},
+		{time.Nanosecond, gomosaic.MosaicTiles{a, b}, false, 2},
+		{time.Nanosecond, gomosaic.MosaicTiles{}, true, 0},
+	}
+	for i, c := range cases {
+		filename := filepath.Join(dir, "index.dat"+checkpointSuffix)
+		cp := newCheckpoint(filename, c.interval)
+		for _, tile := range c.record {
+			cp.record(tile)
+		}
+		if c.save {
+			cp.save()
+		}
+		if saved := ReadIndex(filename); len(saved) != c.expected {
+			t.Errorf("Case %d saved %v but %d tiles were expected", i, saved, c.expected)
+		}
+		cp.remove()
+		if _, exists := GetIndexFileName(filename); exists {
+			t.Errorf("Case %d did not remove the checkpoint", i)
+		}
+	}
+}
+
+//TestResumeFromCheckpoint verifies that the indexer uses the tiles saved in a checkpoint instead of analyzing their
+//images again and removes the checkpoint once the index is written.
+func TestResumeFromCheckpoint(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "checkpoint")
+	defer os.RemoveAll(dir)
+	dest := filepath.Join(dir, "index.dat")
+	saved := gomosaic.MosaicTile{Loc: "L", Filename: "../testdata/img2.png", AvgR: 1, AvgG: 2, AvgB: 3, Hash: 1,
+		HasHash: true}
+	//the last line was cut short by a crash but still parses
+	ioutil.WriteFile(checkpointFileName(dest), []byte(saved.ToString()+"\nL;../testdata/img3.jpg;1;2;3"), 0644)
+
+	if err := Index("../testdata/testconfig.json", dest); err != nil {
+		t.Fatalf("Could not index files %v", err)
+	}
+	index := ReadIndex(dest)
+	if len(index) != 4 || !reflect.DeepEqual(index[1], saved) || index[2].AvgB == 3 {
+		t.Errorf("Expected the index to hold the tile saved in the checkpoint but got %v", index)
+	}
+	if _, exists := GetIndexFileName(checkpointFileName(dest)); exists {
+		t.Errorf("The checkpoint was not removed after the index was written")
+	}
+
+	merged := resumeFromCheckpoint(checkpointFileName(dest), gomosaic.MosaicTiles{saved})
+	if !reflect.DeepEqual(merged, gomosaic.MosaicTiles{saved}) {
+		t.Errorf("resumeFromCheckpoint without a checkpoint returned %v", merged)
+	}
+}
@@ -7,11 +7,13 @@ import (
 	"github.com/cfagiani/gomosaic"
 	"github.com/cfagiani/gomosaic/indexer/processor"
 	"github.com/cfagiani/gomosaic/util"
-	"io"
+	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
 	"sort"
-	"strings"
+	"strconv"
+	"time"
 )
 
 const (
@@ -23,8 +25,16 @@ const (
 
 //Index will process all the readable images in the sources defined in the configuration file. For each image found,
 //the average color values will be calculated and the results will be written to the dest file so it can be used in
-//subsequent mosaic creations.
+//subsequent mosaic creations. Progress is checkpointed every DefaultCheckpointInterval.
 func Index(configFile string, dest string) error {
+	return IndexWithCheckpoints(configFile, dest, DefaultCheckpointInterval)
+}
+
+//IndexWithCheckpoints does the same as Index, saving the tiles analyzed so far to a checkpoint file next to the index
+//at most every interval (never if interval is 0 or less). If the indexer stops before writing the index, the next run
+//resumes from the checkpoint rather than analyzing those images again. The checkpoint is removed once the index has
+//been written.
+func IndexWithCheckpoints(configFile string, dest string, interval time.Duration) error {
 
 	config, e := util.ReadConfig(configFile)
 	if e != nil {
@@ -32,10 +42,22 @@ func Index(configFile string, dest string) error {
 		return e
 	}
 
-	//first read existing index file if present
-	log.Println("Reading file")
-	oldIndex := ReadIndex(dest)
+	//first read existing index if present
+	log.Println("Reading index")
+	filename, _ := GetIndexFileName(dest)
+	store, err := OpenStore(filename)
+	if err != nil {
+		return err
+	}
+	oldIndex, err := ReadStore(store)
+	if err != nil {
+		store.Close()
+		return err
+	}
 	log.Printf("Old index has %d entries\n", len(oldIndex))
+	//processors look up the tiles analyzed before a crash along with those already in the index
+	knownTiles := resumeFromCheckpoint(checkpointFileName(filename), oldIndex)
+	cp := newCheckpoint(checkpointFileName(filename), interval)
 
 	// TODO: use a goroutine for each source?
 	var newIndex gomosaic.MosaicTiles = make([]gomosaic.MosaicTile, 0, 100)
@@ -43,45 +65,66 @@ func Index(configFile string, dest string) error {
 		sourceProcessor := getProcessor(config.Sources[i], config)
 		if sourceProcessor == nil {
 			log.Println("Skipping source.")
+			continue
+		}
+		if recorder, ok := sourceProcessor.(processor.RecordingProcessor); ok && interval > 0 {
+			newIndex = recorder.ProcessRecording(knownTiles, newIndex, cp.record)
+			cp.save()
+		} else {
+			newIndex = sourceProcessor.Process(knownTiles, newIndex)
 		}
-		newIndex = sourceProcessor.Process(oldIndex, newIndex)
 	}
-	oldIndex = nil // we don't need the old index anymore
 	sort.Sort(newIndex)
 
-	log.Printf("Writing new index with %d entries\n", len(newIndex))
-	writeIndex(dest, newIndex)
+	//only the tiles that changed are written to the store
+	upserted, deleted, err := UpdateStore(store, oldIndex, newIndex)
+	if err != nil {
+		store.Close()
+		return err
+	}
+	log.Printf("New index has %d entries: %d added or changed, %d removed\n", len(newIndex), upserted, deleted)
+	if err = store.Close(); err != nil {
+		return err
+	}
+	cp.remove()
 	return nil
 }
 
-//ReadIndex reads an existing index and returns it as a MosaicTiles type. If the index does not exist, the MosaicTiles slice will
-//be empty.
+//ReadIndex reads an existing index, in the text or binary format or from an embedded database, and returns it as a
+//MosaicTiles type. If the index does not exist or can't be read (see LoadIndex), the MosaicTiles slice will be empty.
 func ReadIndex(source string) gomosaic.MosaicTiles {
-	var index = make([]gomosaic.MosaicTile, 0, 100)
-	filename, exists := GetIndexFileName(source)
-	if exists {
-		f, err := os.Open(filename)
-		util.CheckError(err, "Error opening file", true)
-		//close file when block exits
-		defer f.Close()
-		r := bufio.NewReader(f)
+	index, err := LoadIndex(source)
+	if err != nil {
+		log.Printf("Ignoring index: %v\n", err)
+		return make([]gomosaic.MosaicTile, 0, 100)
+	}
+	return index
+}
 
-		for {
-			line, err := r.ReadString(10) // 0x0A separator = newline
-			if err == nil {
-				tile, lineErr := createNodeFromLine(line)
-				if lineErr == nil {
-					index = append(index, *tile)
-				} else {
-					log.Println("Ignoring invalid index line")
-				}
-			}
-			if err == io.EOF {
-				break
-			}
+//LoadIndex is like ReadIndex but returns an error if the index exists but can't be read: if it can't be opened, if it
+//is a binary index that is corrupt or if it is a database that another process has open. Invalid tiles of an index in
+//the text format are logged and skipped.
+func LoadIndex(source string) (gomosaic.MosaicTiles, error) {
+	filename, exists := GetIndexFileName(source)
+	switch {
+	case !exists:
+		return make([]gomosaic.MosaicTile, 0, 100), nil
+	case IsBoltIndex(filename):
+		return readBoltIndex(filename)
+	case IsBinaryIndex(filename):
+		index, err := ReadBinaryIndex(filename)
+		if err != nil {
+			return nil, fmt.Errorf("invalid binary index %s: %v", filename, err)
 		}
+		return index, nil
 	}
-	return index
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	//close file when block exits
+	defer f.Close()
+	return readTiles(f, filename), nil
 }
 
 //GetIndexFileName returns the filename that should be used for the index along with a flag indicating if the file exists
@@ -102,41 +145,96 @@ func GetIndexFileName(source string) (string, bool) {
 	}
 }
 
-//writeIndex writes the index file to the destDir.
-func writeIndex(dest string, index gomosaic.MosaicTiles) {
+//WriteIndex writes the index to dest, or to the default index file in dest if it is a directory. The index is replaced
+//atomically (see writeIndexFile).
+func WriteIndex(dest string, index gomosaic.MosaicTiles) error {
 	filename, _ := GetIndexFileName(dest)
-	f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
-	util.CheckError(err, "error opening file", true)
-	// close file when block exits
-	defer f.Close()
-	w := bufio.NewWriter(f)
-	defer w.Flush()
-	for _, node := range index {
-		if node.Filename != "" {
-			fmt.Fprintf(w, "%s\n", node.ToString())
+	return writeIndexFile(filename, func(w *bufio.Writer) error {
+		for _, node := range index {
+			if node.Filename != "" {
+				fmt.Fprintf(w, "%s\n", node.ToString())
+			}
 		}
+		return nil
+	})
+}
+
+//writeIndexFile calls write with a temporary file in the same directory as filename. Once the temporary file has been
+//flushed to disk, it is renamed to filename, so a crash while writing leaves the previous index intact rather than a
+//partially written one. The index keeps the permissions of the file it replaces.
+func writeIndexFile(filename string, write func(w *bufio.Writer) error) error {
+	var mode os.FileMode = 0755
+	if info, err := os.Stat(filename); err == nil {
+		mode = info.Mode().Perm()
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(tmp)
+	err = write(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), mode)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filename)
 	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
 }
 
-//getProcessor will return an instance of a type that implements the IndexProcessor interface.
+//getProcessor will return an instance of a type that implements the IndexProcessor interface using the factory
+//registered for the kind of the source (see processor.Register). If the kind is unknown or the source is invalid, the
+//problem is logged and nil is returned.
 func getProcessor(source gomosaic.ImageSource, config gomosaic.Config) processor.IndexProcessor {
-	if source.Kind == processor.LocalKind {
-		return processor.LocalProcessor{Source: source}
-	} else if source.Kind == processor.GoogleKind {
-		return processor.GooglePhotosProcessor{Source: source, Config: config}
+	p, err := processor.New(source, config)
+	if err != nil {
+		log.Println(err)
+		return nil
 	}
-	log.Printf("Unrecognized source kind: %s\n", source.Kind)
-	return nil
+	return p
 }
 
-//Parses a line from the index and uses it to initialize a new MosaicTile
+//Parses a line from the index and uses it to initialize a new MosaicTile. The perceptual hash is optional since it
+//isn't written for tiles that don't have one. The location code and file name may be quoted (see splitFields) and
+//must not be blank; the averages must be numbers that fit in 32 bits.
 func createNodeFromLine(line string) (*gomosaic.MosaicTile, error) {
-	// construct node
-	parts := strings.Split(line, delimiter)
-	if len(parts) != 5 {
-		return nil, errors.New("invalid index line")
+	parts, err := splitFields(line)
+	if err != nil {
+		return nil, err
 	}
-	return &gomosaic.MosaicTile{Loc: parts[0], Filename: parts[1], AvgR: util.GetInt32(parts[2]),
-		AvgG: util.GetInt32(parts[3]), AvgB: util.GetInt32(parts[4])}, nil
-
+	if len(parts) != 5 && len(parts) != 6 {
+		return nil, fmt.Errorf("expected 5 or 6 fields but found %d", len(parts))
+	}
+	if len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return nil, errors.New("location code and file name cannot be blank")
+	}
+	tile := &gomosaic.MosaicTile{Loc: parts[0], Filename: parts[1]}
+	for i, avg := range []*uint32{&tile.AvgR, &tile.AvgG, &tile.AvgB} {
+		value, err := strconv.ParseUint(parts[2+i], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid average color %q", parts[2+i])
+		}
+		*avg = uint32(value)
+	}
+	if len(parts) == 6 {
+		hash, err := strconv.ParseUint(parts[5], 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid perceptual hash %q", parts[5])
+		}
+		tile.Hash = hash
+		tile.HasHash = true
+	}
+	return tile, nil
 }
@@ -4,7 +4,9 @@ import (
 	"github.com/cfagiani/gomosaic"
 	"github.com/cfagiani/gomosaic/indexer/processor"
 	"github.com/cfagiani/gomosaic/util"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
@@ -34,15 +36,18 @@ func TestGetProcessor(t *testing.T) {
 	cases := []struct {
 		kind         string
 		expectedType processor.IndexProcessor
+		options      string
 	}{
 		{kind: processor.LocalKind, expectedType: processor.LocalProcessor{Source: gomosaic.ImageSource{}}},
 		{kind: processor.GoogleKind, expectedType: processor.GooglePhotosProcessor{Config: gomosaic.Config{},
 			Source: gomosaic.ImageSource{}}},
 		{kind: "junk", expectedType: nil},
 		{kind: "", expectedType: nil},
+		{kind: processor.LocalKind, expectedType: processor.LocalProcessor{}, options: processor.RecurseOption},
+		{kind: processor.LocalKind, expectedType: nil, options: "sideways"},
 	}
 	for _, c := range cases {
-		processor := getProcessor(gomosaic.ImageSource{Kind: c.kind, Path: "", Options: ""}, gomosaic.Config{})
+		processor := getProcessor(gomosaic.ImageSource{Kind: c.kind, Path: "", Options: c.options}, gomosaic.Config{})
 		if reflect.TypeOf(processor) != reflect.TypeOf(c.expectedType) {
 			t.Errorf("GetProcessor for %q returned wrong type. Got %q wanted %q", c.kind,
 				reflect.TypeOf(processor), reflect.TypeOf(c.expectedType))
@@ -97,3 +102,71 @@ func TestIndex(t *testing.T) {
 		prevFile = tile.Filename
 	}
 }
+
+//TestCreateNodeFromLine verifies that index lines are parsed with and without a perceptual hash and that tiles are
+//written back the same way.
+func TestCreateNodeFromLine(t *testing.T) {
+	cases := []struct {
+		line        string
+		expected    gomosaic.MosaicTile
+		expectError bool
+	}{
+		{"L;a.jpg;1;2;3\n", gomosaic.MosaicTile{Loc: "L", Filename: "a.jpg", AvgR: 1, AvgG: 2, AvgB: 3}, false},
+		{"G;id;1;2;3;00000000000000ff\n", gomosaic.MosaicTile{Loc: "G", Filename: "id", AvgR: 1, AvgG: 2, AvgB: 3,
+			Hash: 0xff, HasHash: true}, false},
+		{"L;a.jpg;1;2;3;nothex\n", gomosaic.MosaicTile{}, true},
+		{"L;a.jpg;1;2\n", gomosaic.MosaicTile{}, true},
+		{"L;\"a;b.jpg\";1;2;3\n", gomosaic.MosaicTile{Loc: "L", Filename: "a;b.jpg", AvgR: 1, AvgG: 2, AvgB: 3}, false},
+		{"L;\"\"\"quoted\"\".jpg\";1;2;3\n", gomosaic.MosaicTile{Loc: "L", Filename: "\"quoted\".jpg", AvgR: 1, AvgG: 2,
+			AvgB: 3}, false},
+		{"L;it's \"fine\".jpg;1;2;3\n", gomosaic.MosaicTile{Loc: "L", Filename: "it's \"fine\".jpg", AvgR: 1, AvgG: 2,
+			AvgB: 3}, false},
+		{"L;a.jpg;1;2;\n", gomosaic.MosaicTile{}, true},
+		{"L;a.jpg;1;2;4294967296\n", gomosaic.MosaicTile{}, true},
+		{";a.jpg;1;2;3\n", gomosaic.MosaicTile{}, true},
+		{"L;\"a.jpg\"x;1;2;3\n", gomosaic.MosaicTile{}, true},
+		{"L;\"a.jpg;1;2;3\n", gomosaic.MosaicTile{}, true},
+	}
+	for _, c := range cases {
+		tile, err := createNodeFromLine(c.line)
+		if c.expectError {
+			if err == nil {
+				t.Errorf("createNodeFromLine(%q) should have returned an error", c.line)
+			}
+			continue
+		}
+		if err != nil || *tile != c.expected {
+			t.Errorf("createNodeFromLine(%q) returned %+v, %v", c.line, tile, err)
+		} else if tile.ToString()+"\n" != c.line {
+			t.Errorf("%+v was written as %q but was read from %q", tile, tile.ToString(), c.line)
+		}
+	}
+}
+
+//TestWriteIndexAtomically verifies that an index is replaced without leaving temporary files behind, keeping its
+//permissions, and that a failed write leaves nothing behind.
+func TestWriteIndexAtomically(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "atomic")
+	defer os.RemoveAll(dir)
+	dest := filepath.Join(dir, "index.dat")
+	ioutil.WriteFile(dest, []byte("L;old.jpg;1;2;3\n"), 0600)
+	index := gomosaic.MosaicTiles{{Loc: "L", Filename: "new.jpg", AvgR: 4, AvgG: 5, AvgB: 6}}
+
+	for _, write := range []func(string, gomosaic.MosaicTiles) error{WriteIndex, WriteBinaryIndex} {
+		if err := write(dest, index); err != nil {
+			t.Fatalf("Could not write the index: %v", err)
+		}
+		if read := ReadIndex(dest); !reflect.DeepEqual(read, index) {
+			t.Errorf("Expected %v to be written but read %v", index, read)
+		}
+		if info, _ := os.Stat(dest); info.Mode().Perm() != 0600 {
+			t.Errorf("The index has permissions %v but 0600 was expected", info.Mode().Perm())
+		}
+		if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+			t.Errorf("Expected only the index in %s but found %d files", dir, len(files))
+		}
+	}
+	if err := WriteIndex(filepath.Join(dir, "missing", "index.dat"), index); err == nil {
+		t.Errorf("Writing to a missing directory should have failed")
+	}
+}
@@ -0,0 +1,182 @@
+package indexer
+
+import (
+	"fmt"
+	"github.com/cfagiani/gomosaic"
+	"path"
+	"sort"
+	"strings"
+)
+
+//TileFilter selects tiles from an index. A tile is selected if it meets every condition that is set.
+type TileFilter struct {
+	//location codes of the tiles to select (e.g. L or G)
+	Locs []string
+	//prefixes of the sources (see TileSource) of the tiles to select, e.g. a directory to select the local files in it
+	//and its subdirectories
+	Sources []string
+	//glob patterns (see path.Match) of the tiles to select. Patterns containing a '/' are matched against the whole
+	//file name and others against its last element.
+	Patterns []string
+	//range of average colors of the tiles to select
+	Colors *ColorRange
+	//select the tiles that don't meet the conditions instead
+	Invert bool
+}
+
+//ColorRange is an inclusive range of colors, with channels from 0 to 255.
+type ColorRange struct {
+	Min [3]uint8
+	Max [3]uint8
+}
+
+//Contains returns true if each channel of the 16 bit color passed in is within the range.
+func (c ColorRange) Contains(r uint32, g uint32, b uint32) bool {
+	for i, v := range []uint32{r >> 8, g >> 8, b >> 8} {
+		if v < uint32(c.Min[i]) || v > uint32(c.Max[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+//ParseColorRange parses a range of the form "r,g,b-r,g,b", e.g. "0,0,128-64,64,255" for dark blues.
+func ParseColorRange(s string) (ColorRange, error) {
+	var c ColorRange
+	var min, max [3]int
+	_, err := fmt.Sscanf(strings.Replace(s, " ", "", -1), "%d,%d,%d-%d,%d,%d", &min[0], &min[1], &min[2], &max[0],
+		&max[1], &max[2])
+	if err != nil {
+		return c, fmt.Errorf("color range %q must be of the form r,g,b-r,g,b", s)
+	}
+	for i := range min {
+		if min[i] < 0 || max[i] > 255 || min[i] > max[i] {
+			return c, fmt.Errorf("color range %q must have channels from 0 to 255 with each minimum below its maximum", s)
+		}
+		c.Min[i], c.Max[i] = uint8(min[i]), uint8(max[i])
+	}
+	return c, nil
+}
+
+//Validate checks that the patterns of the filter are well formed.
+func (f TileFilter) Validate() error {
+	for _, pattern := range f.Patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+//Matches returns true if the tile is selected by the filter.
+func (f TileFilter) Matches(tile gomosaic.MosaicTile) bool {
+	return f.matchesAll(tile) != f.Invert
+}
+
+func (f TileFilter) matchesAll(tile gomosaic.MosaicTile) bool {
+	if len(f.Locs) > 0 && !contains(f.Locs, tile.Loc) {
+		return false
+	}
+	if len(f.Sources) > 0 {
+		source := TileSource(tile)
+		found := false
+		for _, prefix := range f.Sources {
+			found = found || strings.HasPrefix(source, prefix)
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(f.Patterns) > 0 {
+		found := false
+		for _, pattern := range f.Patterns {
+			target := path.Base(tile.Filename)
+			if strings.Contains(pattern, "/") {
+				target = tile.Filename
+			}
+			matched, _ := path.Match(pattern, target)
+			found = found || matched
+		}
+		if !found {
+			return false
+		}
+	}
+	return f.Colors == nil || f.Colors.Contains(tile.AvgR, tile.AvgG, tile.AvgB)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+//MergeIndexes combines indexes into a single sorted index. A tile is left out if an earlier tile has the same location
+//(Loc and Filename) or the same fingerprint: the same average color and perceptual hash, which identifies copies of
+//the same image indexed from different places. Tiles without a hash are only compared by location. When tiles are
+//left out, those of the indexes that come first are kept.
+func MergeIndexes(indexes ...gomosaic.MosaicTiles) gomosaic.MosaicTiles {
+	type location struct {
+		loc      string
+		filename string
+	}
+	type fingerprint struct {
+		r, g, b uint32
+		hash    uint64
+	}
+	locations := make(map[location]bool)
+	fingerprints := make(map[fingerprint]bool)
+	var merged gomosaic.MosaicTiles
+	for _, index := range indexes {
+		for _, tile := range index {
+			l := location{tile.Loc, tile.Filename}
+			fp := fingerprint{tile.AvgR, tile.AvgG, tile.AvgB, tile.Hash}
+			if locations[l] || (tile.HasHash && fingerprints[fp]) {
+				continue
+			}
+			locations[l] = true
+			if tile.HasHash {
+				fingerprints[fp] = true
+			}
+			merged = append(merged, tile)
+		}
+	}
+	sort.Stable(merged)
+	return merged
+}
+
+//FilterIndex returns the tiles of the index selected by the filter, in the same order.
+func FilterIndex(index gomosaic.MosaicTiles, filter TileFilter) gomosaic.MosaicTiles {
+	filtered := gomosaic.MosaicTiles{}
+	for _, tile := range index {
+		if filter.Matches(tile) {
+			filtered = append(filtered, tile)
+		}
+	}
+	return filtered
+}
+
+//SplitIndex divides the index into at most parts indexes of (nearly) equal size. Since each part holds consecutive
+//tiles, the parts of a sorted index are sorted.
+func SplitIndex(index gomosaic.MosaicTiles, parts int) []gomosaic.MosaicTiles {
+	if parts > len(index) {
+		parts = len(index)
+	}
+	var split []gomosaic.MosaicTiles
+	for i := 0; i < parts; i++ {
+		split = append(split, index[i*len(index)/parts:(i+1)*len(index)/parts])
+	}
+	return split
+}
+
+//SplitIndexBy divides the index by the key returned for each tile (e.g. TileSource), keeping the order of the tiles.
+func SplitIndexBy(index gomosaic.MosaicTiles, key func(tile gomosaic.MosaicTile) string) map[string]gomosaic.MosaicTiles {
+	split := make(map[string]gomosaic.MosaicTiles)
+	for _, tile := range index {
+		k := key(tile)
+		split[k] = append(split[k], tile)
+	}
+	return split
+}
@@ -0,0 +1,82 @@
+package indexer
+
+import (
+	"github.com/cfagiani/gomosaic"
+	"reflect"
+	"testing"
+)
+
+//TestMergeIndexes verifies that tiles are deduplicated by location and fingerprint, keeping those of earlier indexes.
+func TestMergeIndexes(t *testing.T) {
+	first := gomosaic.MosaicTiles{{Loc: "L", Filename: "/a/1.jpg", AvgR: 1, Hash: 0xa, HasHash: true},
+		{Loc: "L", Filename: "/a/2.jpg", AvgR: 2}}
+	second := gomosaic.MosaicTiles{{Loc: "L", Filename: "/a/0.jpg", AvgR: 3}, {Loc: "L", Filename: "/a/2.jpg", AvgR: 4},
+		{Loc: "G", Filename: "/a/2.jpg", AvgR: 5}, {Loc: "G", Filename: "copy", AvgR: 1, Hash: 0xa, HasHash: true},
+		{Loc: "G", Filename: "close", AvgR: 1, Hash: 0xb, HasHash: true}, {Loc: "L", Filename: "/b/3.jpg", AvgR: 2}}
+	merged := MergeIndexes(first, second)
+	expected := gomosaic.MosaicTiles{{Loc: "L", Filename: "/a/0.jpg", AvgR: 3}, {Loc: "L", Filename: "/a/1.jpg", AvgR: 1,
+		Hash: 0xa, HasHash: true}, {Loc: "L", Filename: "/a/2.jpg", AvgR: 2}, {Loc: "G", Filename: "/a/2.jpg", AvgR: 5},
+		{Loc: "L", Filename: "/b/3.jpg", AvgR: 2}, {Loc: "G", Filename: "close", AvgR: 1, Hash: 0xb, HasHash: true}}
+	if !reflect.DeepEqual(merged, expected) {
+		t.Errorf("MergeIndexes returned %v but %v was expected", merged, expected)
+	}
+}
+
+//TestFilterIndex verifies each of the conditions of a filter.
+func TestFilterIndex(t *testing.T) {
+	index := gomosaic.MosaicTiles{{Loc: "L", Filename: "/photos/2019/a.jpg", AvgR: 65535},
+		{Loc: "L", Filename: "/photos/2020/b.png", AvgB: 65535}, {Loc: "G", Filename: "id1"},
+		{Loc: "A", Filename: "/photos/old.zip!/c.jpg", AvgR: 30000, AvgG: 30000, AvgB: 30000}}
+	dark, _ := ParseColorRange("0,0,0-128,128,128")
+	cases := []struct {
+		filter   TileFilter
+		expected []string
+	}{
+		{TileFilter{}, []string{"/photos/2019/a.jpg", "/photos/2020/b.png", "id1", "/photos/old.zip!/c.jpg"}},
+		{TileFilter{Locs: []string{"L", "A"}, Invert: true}, []string{"id1"}},
+		{TileFilter{Sources: []string{"/photos/2019", "Google"}}, []string{"/photos/2019/a.jpg", "id1"}},
+		{TileFilter{Sources: []string{"/photos"}, Patterns: []string{"*.jpg"}}, []string{"/photos/2019/a.jpg",
+			"/photos/old.zip!/c.jpg"}},
+		{TileFilter{Patterns: []string{"/photos/*/*.png"}}, []string{"/photos/2020/b.png"}},
+		{TileFilter{Colors: &dark}, []string{"id1", "/photos/old.zip!/c.jpg"}},
+		{TileFilter{Locs: []string{"L"}, Colors: &dark}, []string{}},
+	}
+	for _, c := range cases {
+		var names []string
+		for _, tile := range FilterIndex(index, c.filter) {
+			names = append(names, tile.Filename)
+		}
+		if len(names) != len(c.expected) || (len(names) > 0 && !reflect.DeepEqual(names, c.expected)) {
+			t.Errorf("FilterIndex(%+v) returned %v but %v was expected", c.filter, names, c.expected)
+		}
+	}
+
+	for _, s := range []string{"1,2,3", "0,0,0-256,0,0", "10,0,0-5,0,0", "a,b,c-d,e,f"} {
+		if _, err := ParseColorRange(s); err == nil {
+			t.Errorf("ParseColorRange(%q) should have returned an error", s)
+		}
+	}
+	if err := (TileFilter{Patterns: []string{"[a-"}}).Validate(); err == nil {
+		t.Errorf("Validate should reject a malformed pattern")
+	}
+}
+
+//TestSplitIndex verifies splitting by size and by key.
+func TestSplitIndex(t *testing.T) {
+	index := gomosaic.MosaicTiles{{Loc: "L", Filename: "a"}, {Loc: "G", Filename: "b"}, {Loc: "L", Filename: "c"},
+		{Loc: "L", Filename: "d"}, {Loc: "G", Filename: "e"}}
+	sizes := []int{}
+	for _, part := range SplitIndex(index, 3) {
+		sizes = append(sizes, len(part))
+	}
+	if !reflect.DeepEqual(sizes, []int{1, 2, 2}) {
+		t.Errorf("SplitIndex returned parts of sizes %v", sizes)
+	}
+	if parts := SplitIndex(index, 10); len(parts) != 5 {
+		t.Errorf("SplitIndex returned %d parts for a 5 tile index", len(parts))
+	}
+	byLoc := SplitIndexBy(index, func(tile gomosaic.MosaicTile) string { return tile.Loc })
+	if len(byLoc) != 2 || len(byLoc["L"]) != 3 || byLoc["G"][1].Filename != "e" {
+		t.Errorf("SplitIndexBy returned %v", byLoc)
+	}
+}
@@ -0,0 +1,226 @@
+package indexer
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/cfagiani/gomosaic"
+	"io"
+	"log"
+	"strings"
+)
+
+//errUnterminatedQuote is returned when a quoted field continues past the end of the text, or past maxRecordLines lines.
+var errUnterminatedQuote = errors.New("quoted field is not terminated")
+
+//ParseError reports an invalid tile in an index along with the line it starts on.
+type ParseError struct {
+	Line int
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+//ParseIndex reads an index in the text format, stopping at the first invalid tile with a *ParseError giving its line.
+//The last tile doesn't need to be followed by a line break, and blank lines are ignored.
+func ParseIndex(r io.Reader) (gomosaic.MosaicTiles, error) {
+	index := make(gomosaic.MosaicTiles, 0, 100)
+	ir := newIndexReader(r)
+	for {
+		tile, err := ir.next()
+		if err == io.EOF {
+			return index, nil
+		}
+		if err != nil {
+			return index, err
+		}
+		index = append(index, *tile)
+	}
+}
+
+//readTiles reads an index in the text format, logging and skipping invalid tiles. It stops if the index can't be read.
+func readTiles(r io.Reader, name string) gomosaic.MosaicTiles {
+	index := make(gomosaic.MosaicTiles, 0, 100)
+	ir := newIndexReader(r)
+	for {
+		tile, err := ir.next()
+		if err == io.EOF {
+			break
+		}
+		if parseErr, ok := err.(*ParseError); ok {
+			log.Printf("Ignoring invalid tile in %s: %v\n", name, parseErr)
+			continue
+		}
+		if err != nil {
+			log.Printf("Could not read %s: %v\n", name, err)
+			break
+		}
+		index = append(index, *tile)
+	}
+	return index
+}
+
+//maxRecordLines is the most lines a tile can span. A quoted field that runs on for longer is reported as unterminated
+//rather than joining the rest of the index into one tile.
+const maxRecordLines = 64
+
+//indexReader reads the tiles of an index in the text format one at a time, keeping track of the line numbers.
+type indexReader struct {
+	r *bufio.Reader
+	//number of lines read so far
+	line int
+	//lines that were read as part of a tile that couldn't be parsed and are to be read again
+	unread []string
+}
+
+func newIndexReader(r io.Reader) *indexReader {
+	return &indexReader{r: bufio.NewReader(r)}
+}
+
+//next returns the next tile, or io.EOF once all have been read. A tile that can't be parsed is reported with a
+//*ParseError, after which reading can continue with the following tile. Since quoted fields can contain line breaks, a
+//tile can span several lines. If such a tile can't be parsed, reading continues with the line after the one it starts
+//on, so that a stray quote (for instance at the start of a file name written by a version that didn't quote them) only
+//loses that tile.
+func (ir *indexReader) next() (*gomosaic.MosaicTile, error) {
+	for {
+		line, err := ir.readLine()
+		if err != nil {
+			return nil, err
+		}
+		start := ir.line
+		lines := []string{line}
+		var quotes quoteState
+		for quotes.scan(line) {
+			if len(lines) == maxRecordLines {
+				return nil, ir.resync(start, lines, errUnterminatedQuote)
+			}
+			line, err = ir.readLine()
+			if err == io.EOF {
+				return nil, ir.resync(start, lines, errUnterminatedQuote)
+			}
+			if err != nil {
+				return nil, err
+			}
+			lines = append(lines, line)
+		}
+		record := strings.Join(lines, "")
+		if len(strings.TrimRight(record, "\r\n")) == 0 {
+			continue
+		}
+		tile, err := createNodeFromLine(record)
+		if err != nil {
+			return nil, ir.resync(start, lines, err)
+		}
+		return tile, nil
+	}
+}
+
+//resync returns a *ParseError for the tile made of lines, which starts on line start, and arranges for all but its
+//first line to be read again.
+func (ir *indexReader) resync(start int, lines []string, err error) error {
+	ir.unread = append(lines[1:len(lines):len(lines)], ir.unread...)
+	ir.line = start
+	return &ParseError{Line: start, Err: err}
+}
+
+//readLine returns the next line including its line break, if it has one. io.EOF is only returned once there is nothing
+//left to read.
+func (ir *indexReader) readLine() (string, error) {
+	if len(ir.unread) > 0 {
+		line := ir.unread[0]
+		ir.unread = ir.unread[1:]
+		ir.line++
+		return line, nil
+	}
+	line, err := ir.r.ReadString('\n')
+	if err == io.EOF && len(line) > 0 {
+		err = nil
+	}
+	if err != nil {
+		return "", err
+	}
+	ir.line++
+	return line, nil
+}
+
+//quoteState follows the quoted fields of a tile one line at a time, the way splitFields reads them, to find the line
+//the tile ends on.
+type quoteState struct {
+	//past the first character of the current field
+	inField bool
+	//within a quoted field
+	quoted bool
+}
+
+//scan updates the state with the next line of a tile and returns true if a quoted field is still open at its end.
+func (s *quoteState) scan(line string) bool {
+	for i := 0; i < len(line); i++ {
+		switch {
+		case s.quoted:
+			if line[i] == '"' {
+				if i+1 < len(line) && line[i+1] == '"' {
+					i++
+				} else {
+					s.quoted = false
+				}
+			}
+		case line[i] == delimiter[0]:
+			s.inField = false
+		case !s.inField:
+			s.quoted = line[i] == '"'
+			s.inField = true
+		}
+	}
+	return s.quoted
+}
+
+//splitFields splits an index line into its fields. Fields are separated by the delimiter and a field that starts with
+//a double quote runs to the next lone quote, with doubled quotes standing for one (see gomosaic.MosaicTile.ToString).
+//Quotes within a field that doesn't start with one have no special meaning. The line break ending the line is not
+//part of the last field.
+func splitFields(line string) ([]string, error) {
+	var fields []string
+	for {
+		if !strings.HasPrefix(line, `"`) {
+			end := strings.Index(line, delimiter)
+			if end < 0 {
+				return append(fields, strings.TrimRight(line, "\r\n")), nil
+			}
+			fields = append(fields, line[:end])
+			line = line[end+len(delimiter):]
+			continue
+		}
+		var field strings.Builder
+		i := 1
+		for {
+			quote := strings.IndexByte(line[i:], '"')
+			if quote < 0 {
+				return nil, errUnterminatedQuote
+			}
+			field.WriteString(line[i : i+quote])
+			i += quote + 1
+			if i < len(line) && line[i] == '"' {
+				field.WriteByte('"')
+				i++
+				continue
+			}
+			break
+		}
+		fields = append(fields, field.String())
+		line = line[i:]
+		if len(strings.TrimRight(line, "\r\n")) == 0 {
+			return fields, nil
+		}
+		if !strings.HasPrefix(line, delimiter) {
+			return nil, errors.New("quoted field is followed by more than a delimiter")
+		}
+		line = line[len(delimiter):]
+	}
+}
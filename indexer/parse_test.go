@@ -0,0 +1,154 @@
+package indexer
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/cfagiani/gomosaic"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+//TestParseIndex verifies that tiles are read with or without a final line break, that quoted fields can span lines
+//and that the first invalid tile is reported with its line.
+func TestParseIndex(t *testing.T) {
+	a := gomosaic.MosaicTile{Loc: "L", Filename: "a.jpg", AvgR: 1, AvgG: 2, AvgB: 3}
+	b := gomosaic.MosaicTile{Loc: "L", Filename: "b\nc;d.jpg", AvgR: 4, AvgG: 5, AvgB: 6, Hash: 0xff, HasHash: true}
+	cases := []struct {
+		text      string
+		expected  gomosaic.MosaicTiles
+		errorLine int
+	}{
+		{"", gomosaic.MosaicTiles{}, 0},
+		{"L;a.jpg;1;2;3", gomosaic.MosaicTiles{a}, 0},
+		{"L;a.jpg;1;2;3\r\n\nL;\"b\nc;d.jpg\";4;5;6;00000000000000ff\n", gomosaic.MosaicTiles{a, b}, 0},
+		{"L;\"b\nc;d.jpg\";4;5;6;00000000000000ff\nL;a.jpg;1;2;3", gomosaic.MosaicTiles{b, a}, 0},
+		{"L;a.jpg;1;2;3\nL;a.jpg;1;2\nL;a.jpg;1;2;3\n", gomosaic.MosaicTiles{a}, 2},
+		{"L;\"b\nc;d.jpg\";4;5;6;00000000000000ff\nL;a.jpg;x;2;3\n", gomosaic.MosaicTiles{b}, 3},
+		{"L;a.jpg;1;2;3\nL;\"unterminated;1;2;3\n\n", gomosaic.MosaicTiles{a}, 2},
+		{"L;\"stray.jpg;1;2;3\nL;a.jpg;1;2;3\n", gomosaic.MosaicTiles{}, 1},
+	}
+	for _, c := range cases {
+		index, err := ParseIndex(strings.NewReader(c.text))
+		if !reflect.DeepEqual(index, c.expected) {
+			t.Errorf("ParseIndex(%q) returned %v but %v was expected", c.text, index, c.expected)
+		}
+		if c.errorLine == 0 && err != nil {
+			t.Errorf("ParseIndex(%q) returned error %v", c.text, err)
+		} else if parseErr, ok := err.(*ParseError); c.errorLine > 0 && (!ok || parseErr.Line != c.errorLine) {
+			t.Errorf("ParseIndex(%q) returned error %v but one on line %d was expected", c.text, err, c.errorLine)
+		}
+	}
+
+	//invalid tiles are skipped by readTiles
+	if index := readTiles(strings.NewReader("L;a.jpg;1;2\nL;a.jpg;1;2;3"), "test"); !reflect.DeepEqual(index,
+		gomosaic.MosaicTiles{a}) {
+		t.Errorf("readTiles returned %v", index)
+	}
+}
+
+//TestParseIndexStrayQuote verifies that a quoted field that is never terminated only loses its own tile, however many
+//tiles follow it.
+func TestParseIndexStrayQuote(t *testing.T) {
+	var text strings.Builder
+	text.WriteString("L;\"stray.jpg;1;2;3\n")
+	expected := gomosaic.MosaicTiles{}
+	for i := 0; i < 40000; i++ {
+		tile := gomosaic.MosaicTile{Loc: "L", Filename: fmt.Sprintf("tile%d.jpg", i), AvgR: uint32(i)}
+		if i == 100 {
+			tile.Filename = "line\nbreak.jpg"
+		}
+		text.WriteString(tile.ToString() + "\n")
+		expected = append(expected, tile)
+	}
+	index := readTiles(strings.NewReader(text.String()), "test")
+	if !reflect.DeepEqual(index, expected) {
+		t.Errorf("readTiles returned %d tiles but the %d after the stray quote were expected", len(index),
+			len(expected))
+	}
+
+	//a stray quote that is closed by the quoted field of a later tile doesn't lose that tile either
+	index = readTiles(strings.NewReader("L;\"stray.jpg;1;2;3\nL;a.jpg;1;2;3\nL;\"b\nc;d.jpg\";4;5;6;00000000000000ff\n"),
+		"test")
+	if len(index) != 2 || index[0].Filename != "a.jpg" || index[1].Filename != "b\nc;d.jpg" {
+		t.Errorf("readTiles returned %v", index)
+	}
+}
+
+//TestTileRoundTrip verifies that tiles with file names that need quoting are written and read back unchanged, and that
+//file names that don't need it are written as before.
+func TestTileRoundTrip(t *testing.T) {
+	cases := []struct {
+		tile     gomosaic.MosaicTile
+		expected string
+	}{
+		{gomosaic.MosaicTile{Loc: "L", Filename: `C:\photos\a "b".jpg`, AvgR: 1}, `L;C:\photos\a "b".jpg;1;0;0`},
+		{gomosaic.MosaicTile{Loc: "U", Filename: "http://host/a;v=1.jpg", AvgG: 2}, `U;"http://host/a;v=1.jpg";0;2;0`},
+		{gomosaic.MosaicTile{Loc: "L", Filename: `"start.jpg`, Hash: 1, HasHash: true},
+			`L;"""start.jpg";0;0;0;0000000000000001`},
+		{gomosaic.MosaicTile{Loc: "L", Filename: "zero.jpg", HasHash: true}, "L;zero.jpg;0;0;0;0000000000000000"},
+		{gomosaic.MosaicTile{Loc: "L", Filename: "line\r\nbreak.jpg"}, "L;\"line\r\nbreak.jpg\";0;0;0"},
+	}
+	for _, c := range cases {
+		line := c.tile.ToString()
+		if line != c.expected {
+			t.Errorf("%+v was written as %q but %q was expected", c.tile, line, c.expected)
+		}
+		index, err := ParseIndex(strings.NewReader(line + "\n"))
+		if err != nil || len(index) != 1 || index[0] != c.tile {
+			t.Errorf("%q was read as %v, %v but %+v was expected", line, index, err, c.tile)
+		}
+	}
+}
+
+//FuzzParseIndex checks that the reader never panics and that any index it accepts is written back in a form that reads
+//the same.
+func FuzzParseIndex(f *testing.F) {
+	for _, seed := range []string{"L;a.jpg;1;2;3\n", "L;\"b\nc;d.jpg\";4;5;6;00000000000000ff", "L;\"\"\"q\";1;2;3\r\n",
+		"L;a;1;2\n\n", "G;\"x", "L;a\"b;1;2;3;ffffffffffffffff\n"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, text string) {
+		index, err := ParseIndex(strings.NewReader(text))
+		if err != nil {
+			if _, ok := err.(*ParseError); !ok {
+				t.Fatalf("ParseIndex(%q) returned an error that isn't a *ParseError: %v", text, err)
+			}
+			return
+		}
+		var written bytes.Buffer
+		for _, tile := range index {
+			written.WriteString(tile.ToString() + "\n")
+		}
+		reread, err := ParseIndex(&written)
+		if err != nil || !reflect.DeepEqual(reread, index) {
+			t.Fatalf("%v was read back as %v, %v", index, reread, err)
+		}
+	})
+}
+
+//FuzzTileRoundTrip checks that any tile with a location code and file name is read back unchanged.
+func FuzzTileRoundTrip(f *testing.F) {
+	f.Add("L", "a.jpg", uint32(1), uint64(0))
+	f.Add("U", "http://host/a;b\"c\nd", uint32(65535), uint64(0xff))
+	f.Fuzz(func(t *testing.T, loc string, filename string, avg uint32, hash uint64) {
+		if len(loc) == 0 || len(filename) == 0 {
+			return
+		}
+		tile := gomosaic.MosaicTile{Loc: loc, Filename: filename, AvgR: avg, AvgG: avg / 2, AvgB: avg / 3, Hash: hash,
+			HasHash: true}
+		index, err := ParseIndex(strings.NewReader(tile.ToString()))
+		if err != nil || len(index) != 1 || index[0] != tile {
+			t.Fatalf("%q was read as %v, %v but %+v was expected", tile.ToString(), index, err, tile)
+		}
+	})
+}
+
+//FuzzDecodeBinaryIndex checks that corrupt binary indexes are rejected rather than causing a panic.
+func FuzzDecodeBinaryIndex(f *testing.F) {
+	f.Add([]byte(binaryMagic))
+	f.Add(append([]byte(binaryMagic), make([]byte, 16)...))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		decodeBinaryIndex(data)
+	})
+}
@@ -0,0 +1,105 @@
+package processor
+
+import (
+	"errors"
+	"fmt"
+	"github.com/cfagiani/gomosaic"
+	"github.com/cfagiani/gomosaic/mosaicimages"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+const (
+	ArchiveKind = "archive"
+	//location recorded in tiles read from archives
+	archiveLoc = "A"
+)
+
+//ArchiveProcessor indexes the images stored in zip and tar (optionally gzipped) archives without extracting them. The
+//Path of the source is either an archive or a directory whose archives are all indexed. Tiles are named using
+//mosaicimages.ArchiveEntryName.
+type ArchiveProcessor struct {
+	Source gomosaic.ImageSource
+}
+
+func init() {
+	Register(ArchiveKind, newArchiveProcessor)
+}
+
+//newArchiveProcessor validates an archive source, which doesn't support any options.
+func newArchiveProcessor(source gomosaic.ImageSource, config gomosaic.Config) (IndexProcessor, error) {
+	if len(source.Path) == 0 {
+		return nil, errors.New("path must be an archive or a directory of archives")
+	}
+	if len(source.Options) > 0 {
+		return nil, fmt.Errorf("unsupported option %q", source.Options)
+	}
+	return ArchiveProcessor{Source: source}, nil
+}
+
+//Process analyzes the images in each archive that aren't already in the index. Archives that can't be read are logged
+//and skipped.
+func (p ArchiveProcessor) Process(oldIndex gomosaic.MosaicTiles, newIndex gomosaic.MosaicTiles) gomosaic.MosaicTiles {
+	return p.ProcessRecording(oldIndex, newIndex, nil)
+}
+
+//ProcessRecording does the same as Process and passes each tile it analyzes to record.
+func (p ArchiveProcessor) ProcessRecording(oldIndex gomosaic.MosaicTiles, newIndex gomosaic.MosaicTiles,
+	record TileRecorder) gomosaic.MosaicTiles {
+	archives, err := p.archives()
+	if err != nil {
+		log.Printf("Skipping archive source: %v\n", err)
+		return newIndex
+	}
+	count := 0
+	for _, archive := range archives {
+		log.Printf("Indexing %s\n", archive)
+		err := mosaicimages.WalkArchive(archive, func(entry string, r io.Reader) error {
+			name := mosaicimages.ArchiveEntryName(archive, entry)
+			if existingTile := findAnalyzed(name, oldIndex); existingTile != nil && existingTile.Loc == archiveLoc {
+				newIndex = append(newIndex, *existingTile)
+				return nil
+			}
+			tile, err := mosaicimages.AnalyzeTile(r)
+			if err != nil {
+				log.Printf("Could not analyze %s: %v\n", name, err)
+				return nil
+			}
+			tile.Loc, tile.Filename = archiveLoc, name
+			newIndex = append(newIndex, tile)
+			record.record(tile)
+			count++
+			return nil
+		})
+		if err != nil {
+			log.Printf("Could not read archive %s: %v\n", archive, err)
+		}
+	}
+	log.Printf("Added %d new files to index\n", count)
+	return newIndex
+}
+
+//archives returns the source path if it is a file or the archives it contains if it is a directory.
+func (p ArchiveProcessor) archives() ([]string, error) {
+	info, err := os.Stat(p.Source.Path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{p.Source.Path}, nil
+	}
+	files, err := ioutil.ReadDir(p.Source.Path)
+	if err != nil {
+		return nil, err
+	}
+	var archives []string
+	for _, file := range files {
+		if !file.IsDir() && mosaicimages.IsArchive(file.Name()) {
+			archives = append(archives, filepath.Join(p.Source.Path, file.Name()))
+		}
+	}
+	return archives, nil
+}
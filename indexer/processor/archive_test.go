@@ -0,0 +1,76 @@
+package processor
+
+import (
+	"archive/zip"
+	"github.com/cfagiani/gomosaic"
+	"github.com/cfagiani/gomosaic/mosaicimages"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+//writeTestZip creates a zip archive holding copies of the files passed in, keyed by entry name.
+func writeTestZip(t *testing.T, path string, entries map[string]string) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Could not create archive: %v", err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	defer zw.Close()
+	for name, source := range entries {
+		data, _ := ioutil.ReadFile(source)
+		w, _ := zw.Create(name)
+		w.Write(data)
+	}
+}
+
+//TestArchiveProcessor verifies that images in an archive, or in all the archives of a directory, are indexed and that
+//entries already in the index are kept.
+func TestArchiveProcessor(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "archives")
+	defer os.RemoveAll(dir)
+	first := filepath.Join(dir, "first.zip")
+	second := filepath.Join(dir, "second.zip")
+	writeTestZip(t, first, map[string]string{"a.png": "../../testdata/img1.png", "b.png": "../../testdata/img2.png",
+		"readme.txt": "archive.go"})
+	writeTestZip(t, second, map[string]string{"c.jpg": "../../testdata/img3.jpg"})
+	ioutil.WriteFile(filepath.Join(dir, "corrupt.zip"), []byte("not a zip"), 0644)
+
+	existing := gomosaic.MosaicTile{Loc: archiveLoc, Filename: mosaicimages.ArchiveEntryName(first, "b.png"), AvgR: 1,
+		Hash: 1, HasHash: true}
+	cases := []struct {
+		path     string
+		expected []string
+	}{
+		{first, []string{first + "!/a.png", first + "!/b.png"}},
+		{dir, []string{first + "!/a.png", first + "!/b.png", second + "!/c.jpg"}},
+		{filepath.Join(dir, "missing.zip"), nil},
+	}
+	for _, c := range cases {
+		p, err := New(gomosaic.ImageSource{Kind: ArchiveKind, Path: c.path}, gomosaic.Config{})
+		if err != nil {
+			t.Fatalf("Could not create processor: %v", err)
+		}
+		index := p.Process(gomosaic.MosaicTiles{existing}, gomosaic.MosaicTiles{})
+		sort.Sort(index)
+		var names []string
+		for _, tile := range index {
+			names = append(names, tile.Filename)
+			if tile.Filename == existing.Filename && tile != existing {
+				t.Errorf("Existing tile was analyzed again: %+v", tile)
+			}
+		}
+		if strings.Join(names, ",") != strings.Join(c.expected, ",") {
+			t.Errorf("Indexing %s returned %v but %v was expected", c.path, names, c.expected)
+		}
+	}
+	for _, source := range []gomosaic.ImageSource{{Kind: ArchiveKind}, {Kind: ArchiveKind, Path: dir, Options: "recurse"}} {
+		if _, err := New(source, gomosaic.Config{}); err == nil {
+			t.Errorf("New(%+v) should have failed", source)
+		}
+	}
+}
@@ -1,20 +1,146 @@
 package processor
 
 import (
+	"fmt"
 	"github.com/cfagiani/gomosaic"
+	"log"
 	"sort"
+	"strings"
+	"sync"
 )
 
 type IndexProcessor interface {
 	Process(oldIndex gomosaic.MosaicTiles, newIndex gomosaic.MosaicTiles) gomosaic.MosaicTiles
 }
 
+//TileRecorder is called with each tile a processor analyzes as soon as it has been analyzed, which lets the indexer
+//save its progress during long runs. It may be called from several goroutines at once.
+type TileRecorder func(tile gomosaic.MosaicTile)
+
+//RecordingProcessor is implemented by processors that can report the tiles they analyze. ProcessRecording does the same
+//as Process but also passes each tile it analyzes (rather than copies from the old index) to record.
+type RecordingProcessor interface {
+	IndexProcessor
+	ProcessRecording(oldIndex gomosaic.MosaicTiles, newIndex gomosaic.MosaicTiles, record TileRecorder) gomosaic.MosaicTiles
+}
+
+//PathProcessor is implemented by processors that can update the tiles of some of the files of their source without
+//indexing all of it. ProcessPaths returns the index with the tiles at or below the paths passed in brought up to date.
+type PathProcessor interface {
+	IndexProcessor
+	ProcessPaths(index gomosaic.MosaicTiles, paths []string) gomosaic.MosaicTiles
+}
+
+//record passes the tile to the recorder, if there is one.
+func (r TileRecorder) record(tile gomosaic.MosaicTile) {
+	if r != nil {
+		r(tile)
+	}
+}
+
 //find performs a binary search of the sorted index for an entry with the filename specified.
 func find(name string, index gomosaic.MosaicTiles) *gomosaic.MosaicTile {
-	i := sort.Search(len(index), func(i int) bool { return index[i].Filename == name })
+	i := sort.Search(len(index), func(i int) bool { return index[i].Filename >= name })
 	if i < len(index) && index[i].Filename == name {
 		return &index[i]
 	} else {
 		return nil
 	}
 }
+
+//findAnalyzed returns the tile of the sorted index with the filename specified, like find, unless it has no perceptual
+//hash. Tiles indexed before hashes were computed are then analyzed again so that duplicates can be found among them.
+func findAnalyzed(name string, index gomosaic.MosaicTiles) *gomosaic.MosaicTile {
+	tile := find(name, index)
+	if tile == nil || !tile.HasHash {
+		return nil
+	}
+	return tile
+}
+
+//keepUnseenTiles appends the tiles with the location code specified from the old index that were neither seen during
+//this run nor already in the new index. Processors use it when a source can't be listed so that the tiles indexed from
+//it aren't dropped from the index.
+func keepUnseenTiles(oldIndex gomosaic.MosaicTiles, newIndex gomosaic.MosaicTiles, loc string,
+	seen map[string]bool) gomosaic.MosaicTiles {
+	present := make(map[string]bool)
+	for _, tile := range newIndex {
+		if tile.Loc == loc {
+			present[tile.Filename] = true
+		}
+	}
+	for _, tile := range oldIndex {
+		if tile.Loc == loc && !seen[tile.Filename] && !present[tile.Filename] {
+			newIndex = append(newIndex, tile)
+		}
+	}
+	return newIndex
+}
+
+//parseOptions parses source options of the form "key=value,key2=value2" and returns them as a map. An error is
+//returned if an option is malformed or its key isn't one of those allowed.
+func parseOptions(options string, allowed ...string) (map[string]string, error) {
+	parsed := make(map[string]string)
+	for _, option := range strings.Split(options, ",") {
+		option = strings.TrimSpace(option)
+		if len(option) == 0 {
+			continue
+		}
+		parts := strings.SplitN(option, "=", 2)
+		key := strings.TrimSpace(parts[0])
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("option %q must be of the form key=value", option)
+		}
+		known := false
+		for _, a := range allowed {
+			known = known || a == key
+		}
+		if !known {
+			return nil, fmt.Errorf("unsupported option %q (supported options are %s)", key, strings.Join(allowed, ", "))
+		}
+		parsed[key] = strings.TrimSpace(parts[1])
+	}
+	return parsed, nil
+}
+
+//analyzeConcurrently calls analyze for each name using at most workers goroutines and returns the resulting tiles in the
+//order of the names. Names that can't be analyzed are logged and left out. Each tile is passed to record as soon as it
+//has been analyzed.
+func analyzeConcurrently(names []string, workers int, analyze func(name string) (*gomosaic.MosaicTile, error),
+	record TileRecorder) []gomosaic.MosaicTile {
+	//each worker writes to its own positions so the tiles can be returned in order once all are done
+	results := make([]*gomosaic.MosaicTile, len(names))
+	work := make(chan int)
+	var wg sync.WaitGroup
+	if workers < 1 {
+		workers = 1
+	}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				tile, err := analyze(names[i])
+				if err != nil {
+					log.Printf("Could not index %s: %v\n", names[i], err)
+					continue
+				}
+				results[i] = tile
+				record.record(*tile)
+			}
+		}()
+	}
+	for i := range names {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+
+	var tiles []gomosaic.MosaicTile
+	for _, tile := range results {
+		if tile != nil {
+			tiles = append(tiles, *tile)
+		}
+	}
+	return tiles
+}
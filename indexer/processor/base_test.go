@@ -0,0 +1,49 @@
+package processor
+
+import (
+	"github.com/cfagiani/gomosaic"
+	"testing"
+)
+
+//TestFind verifies that every entry of a sorted index can be found.
+func TestFind(t *testing.T) {
+	index := gomosaic.MosaicTiles{{Loc: "L", Filename: "a"}, {Loc: "L", Filename: "b"}, {Loc: "G", Filename: "c"},
+		{Loc: "L", Filename: "d"}, {Loc: "U", Filename: "e"}}
+	for _, tile := range index {
+		if found := find(tile.Filename, index); found == nil || *found != tile {
+			t.Errorf("find(%s) returned %v", tile.Filename, found)
+		}
+	}
+	for _, name := range []string{"", "bb", "z"} {
+		if found := find(name, index); found != nil {
+			t.Errorf("find(%s) returned %v but nothing was expected", name, found)
+		}
+	}
+}
+
+//TestParseOptions verifies that key=value options are parsed and unknown keys are rejected.
+func TestParseOptions(t *testing.T) {
+	cases := []struct {
+		options     string
+		expected    map[string]string
+		expectError bool
+	}{
+		{"", map[string]string{}, false},
+		{"a=1, b = two ,", map[string]string{"a": "1", "b": "two"}, false},
+		{"a=x=y", map[string]string{"a": "x=y"}, false},
+		{"a", nil, true},
+		{"c=1", nil, true},
+	}
+	for _, c := range cases {
+		options, err := parseOptions(c.options, "a", "b")
+		if (err != nil) != c.expectError || len(options) != len(c.expected) {
+			t.Errorf("parseOptions(%q) returned %v, %v", c.options, options, err)
+			continue
+		}
+		for k, v := range c.expected {
+			if options[k] != v {
+				t.Errorf("parseOptions(%q) returned %v", c.options, options)
+			}
+		}
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !windows
+// +build !windows
+
+package processor
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// fileID returns a string identifying the file described by info: its device and inode numbers.
+func fileID(path string, info os.FileInfo) (string, error) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", fmt.Errorf("could not read the inode of %s", path)
+	}
+	return fmt.Sprintf("%d:%d", stat.Dev, stat.Ino), nil
+}
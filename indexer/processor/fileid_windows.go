@@ -0,0 +1,19 @@
+//go:build windows
+// +build windows
+
+package processor
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// fileID returns a string identifying the file at path. Since there are no inode numbers, the absolute path with all
+// links resolved is used.
+func fileID(path string, info os.FileInfo) (string, error) {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Abs(resolved)
+}
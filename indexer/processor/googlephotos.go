@@ -1,47 +1,96 @@
 package processor
 
 import (
+	"errors"
+	"fmt"
 	"github.com/cfagiani/gomosaic"
 	"github.com/cfagiani/gomosaic/mosaicimages"
 	"github.com/cfagiani/gomosaic/util"
 	"google.golang.org/api/photoslibrary/v1"
 	"log"
+	"strings"
+	"time"
 )
 
 type GooglePhotosProcessor struct {
 	Config gomosaic.Config
 	Source gomosaic.ImageSource
+	//Service is the client used to query Google Photos. If nil, one is created using the credentials in the Config and
+	//the token file named in the Source options.
+	Service *photoslibrary.Service
+	//Retry controls how failed api calls are retried. The zero value uses util.DefaultRetryPolicy.
+	Retry util.RetryPolicy
 }
 
 const (
 	desiredPageSize    = 500
+	albumPageSize      = 50
 	indexTileDimension = "=w100"
 	GoogleKind         = "google"
+	dateFormat         = "2006-01-02"
 )
 
+//content categories that can be used in filters
+var contentCategories = map[string]bool{
+	"NONE": true, "LANDSCAPES": true, "RECEIPTS": true, "CITYSCAPES": true, "LANDMARKS": true, "SELFIES": true,
+	"PEOPLE": true, "PETS": true, "WEDDINGS": true, "BIRTHDAYS": true, "DOCUMENTS": true, "TRAVEL": true,
+	"ANIMALS": true, "FOOD": true, "SPORT": true, "NIGHT": true, "PERFORMANCES": true, "WHITEBOARDS": true,
+	"SCREENSHOTS": true, "UTILITY": true, "ARTS": true, "CRAFTS": true, "FASHION": true, "HOUSES": true,
+	"GARDENS": true, "FLOWERS": true, "HOLIDAYS": true,
+}
+
 //Process will populate the index of MosaicTiles by querying the Google Photos api to get a list of mediaItems and then
-//analyzing each to calculate average pixel values.
+//analyzing each to calculate average pixel values. If the source names one or more albums, only the photos in those
+//albums are indexed. Otherwise the source's filters (if any) restrict which photos are indexed.
 func (p GooglePhotosProcessor) Process(oldIndex gomosaic.MosaicTiles, newIndex gomosaic.MosaicTiles) gomosaic.MosaicTiles {
+	return p.ProcessRecording(oldIndex, newIndex, nil)
+}
 
-	photoService, err := util.GetPhotosService(p.Config.GoogleClientId, p.Config.GoogleClientSecret, p.Source.Options)
+//ProcessRecording does the same as Process and passes each tile it analyzes to record.
+func (p GooglePhotosProcessor) ProcessRecording(oldIndex gomosaic.MosaicTiles, newIndex gomosaic.MosaicTiles,
+	record TileRecorder) gomosaic.MosaicTiles {
 
-	count := 0
+	filters, err := p.searchFilters()
+	if err != nil {
+		log.Printf("Skipping Google Photos source: %v\n", err)
+		return p.keepOldTiles(oldIndex, newIndex, nil)
+	}
+
+	photoService := p.Service
+	if photoService == nil {
+		photoService, err = util.GetPhotosService(p.Config.GoogleClientId, p.Config.GoogleClientSecret, p.Source.Options)
+		if err != nil {
+			log.Printf("Skipping Google Photos source: could not create client: %v\n", err)
+			return p.keepOldTiles(oldIndex, newIndex, nil)
+		}
+	}
+
+	albumIds := []string{""}
+	if albumNames := p.albumNames(); len(albumNames) > 0 {
+		albumIds, err = resolveAlbumIds(photoService, p.Retry, albumNames)
+		if err != nil {
+			log.Printf("Skipping Google Photos source: %v\n", err)
+			return p.keepOldTiles(oldIndex, newIndex, nil)
+		}
+	}
 
-	if err == nil {
-		//TODO: handle album restriction
-		var nextPage = ""
-		for {
-			pageResp := getPage(photoService, "", nextPage)
+	count := 0
+	//photos can be in more than one album but should only be indexed once
+	seen := make(map[string]bool)
+	for _, albumId := range albumIds {
+		err = p.searchAll(photoService, albumId, filters, func(items []*photoslibrary.MediaItem) {
 			//TODO: refactor this as most of the logic is the same as the localdir indexer.
-			for _, item := range pageResp.MediaItems {
-				if item.MediaMetadata.Photo != nil { // don't index videos
-					existingTile := find(item.Id, oldIndex)
+			for _, item := range items {
+				if item.MediaMetadata != nil && item.MediaMetadata.Photo != nil && !seen[item.Id] { // don't index videos
+					seen[item.Id] = true
+					existingTile := findAnalyzed(item.Id, oldIndex)
 					if existingTile == nil {
-						imageSegment, err := mosaicimages.AnalyzeImage(item.BaseUrl + indexTileDimension)
+						tile, err := mosaicimages.AnalyzeTileImage(item.BaseUrl + indexTileDimension)
 						if err == nil {
 							//now add to index
-							newIndex = append(newIndex,
-								gomosaic.MosaicTile{Loc: "G", Filename: item.Id, AvgR: imageSegment.RVal, AvgG: imageSegment.GVal, AvgB: imageSegment.BVal})
+							tile.Loc, tile.Filename = "G", item.Id
+							newIndex = append(newIndex, tile)
+							record.record(tile)
 							count++
 						}
 					} else {
@@ -49,23 +98,261 @@ func (p GooglePhotosProcessor) Process(oldIndex gomosaic.MosaicTiles, newIndex g
 					}
 				}
 			}
-			nextPage = pageResp.NextPageToken
-			if len(nextPage) == 0 {
-				break
-			}
+		})
+		if err != nil {
+			log.Printf("Stopped indexing Google Photos source: %v\n", err)
+			newIndex = p.keepOldTiles(oldIndex, newIndex, seen)
+			break
 		}
 	}
 	log.Printf("Added %d new files to index\n", count)
 	return newIndex
 }
 
-//getPage will fetch a page of MediaItems from the Google Photos api.
-func getPage(photoService *photoslibrary.Service, albumId string, nextPageToken string) *photoslibrary.SearchMediaItemsResponse {
-	resp, apiErr := photoService.MediaItems.Search(&photoslibrary.SearchMediaItemsRequest{AlbumId: albumId,
-		PageSize:  desiredPageSize,
-		PageToken: nextPageToken}).Do()
-	if apiErr != nil {
-		log.Printf("Could not fetch results from service: %v", apiErr)
+//PagingError is returned when a page of search results could not be fetched. Page is the number of the page that
+//failed, starting at 1.
+type PagingError struct {
+	AlbumId string
+	Page    int
+	Err     error
+}
+
+func (e *PagingError) Error() string {
+	return fmt.Sprintf("could not fetch page %d of album %q: %v", e.Page, e.AlbumId, e.Err)
+}
+
+func (e *PagingError) Unwrap() error {
+	return e.Err
+}
+
+//searchAll fetches every page of search results and passes the media items in each page to visit. Each page is
+//retried according to the processor's RetryPolicy; if a page still cannot be fetched a *PagingError is returned.
+func (p GooglePhotosProcessor) searchAll(photoService *photoslibrary.Service, albumId string,
+	filters *photoslibrary.Filters, visit func(items []*photoslibrary.MediaItem)) error {
+	pageToken := ""
+	for page := 1; ; page++ {
+		pageResp, err := getPage(photoService, p.Retry, albumId, filters, pageToken)
+		if err != nil {
+			return &PagingError{AlbumId: albumId, Page: page, Err: err}
+		}
+		visit(pageResp.MediaItems)
+		pageToken = pageResp.NextPageToken
+		if len(pageToken) == 0 {
+			return nil
+		}
+	}
+}
+
+//keepOldTiles is used when the photos of the source can't all be listed: it keeps the previously indexed photos that
+//weren't seen rather than dropping them from the index. Tiles don't record the source they came from, so this is only
+//possible when there is a single google source.
+func (p GooglePhotosProcessor) keepOldTiles(oldIndex gomosaic.MosaicTiles, newIndex gomosaic.MosaicTiles,
+	seen map[string]bool) gomosaic.MosaicTiles {
+	if googleSourceCount(p.Config) > 1 {
+		log.Println("Photos of this source that weren't listed will be indexed again by the next run")
+		return newIndex
+	}
+	return keepUnseenTiles(oldIndex, newIndex, "G", seen)
+}
+
+//googleSourceCount returns the number of google sources in the config.
+func googleSourceCount(config gomosaic.Config) int {
+	count := 0
+	for _, source := range config.Sources {
+		if source.Kind == GoogleKind {
+			count++
+		}
+	}
+	return count
+}
+
+func init() {
+	Register(GoogleKind, newGooglePhotosProcessor)
+}
+
+//newGooglePhotosProcessor validates the filters of a google source. The token file named in the options is only read
+//when the source is processed.
+func newGooglePhotosProcessor(source gomosaic.ImageSource, config gomosaic.Config) (IndexProcessor, error) {
+	p := GooglePhotosProcessor{Source: source, Config: config}
+	if _, err := p.searchFilters(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+//searchFilters returns the api filters for the source, or nil if it has none.
+func (p GooglePhotosProcessor) searchFilters() (*photoslibrary.Filters, error) {
+	filters, err := buildFilters(p.Source.Filters)
+	if err == nil && filters != nil && len(p.albumNames()) > 0 {
+		err = errors.New("filters cannot be combined with an album restriction")
+	}
+	return filters, err
+}
+
+//albumNames returns the names of the albums the source is restricted to: the Albums list if present, otherwise the
+//Path (if not blank).
+func (p GooglePhotosProcessor) albumNames() []string {
+	if len(p.Source.Albums) > 0 {
+		return p.Source.Albums
 	}
-	return resp
+	if len(p.Source.Path) > 0 {
+		return []string{p.Source.Path}
+	}
+	return nil
+}
+
+//resolveAlbumIds looks up the IDs of the albums with the titles passed in, considering both the albums owned by the user
+//and those shared with them. An error is returned if a title does not match any album or matches more than one.
+func resolveAlbumIds(photoService *photoslibrary.Service, retry util.RetryPolicy, titles []string) ([]string, error) {
+	albums, err := listAlbums(photoService, retry)
+	if err != nil {
+		return nil, fmt.Errorf("could not list albums: %v", err)
+	}
+	idsByTitle := make(map[string][]string)
+	for _, album := range albums {
+		idsByTitle[album.Title] = append(idsByTitle[album.Title], album.Id)
+	}
+	ids := make([]string, 0, len(titles))
+	for _, title := range titles {
+		matches := idsByTitle[title]
+		switch len(matches) {
+		case 0:
+			return nil, fmt.Errorf("no album named %q was found", title)
+		case 1:
+			ids = append(ids, matches[0])
+		default:
+			return nil, fmt.Errorf("album name %q is ambiguous: it matches %d albums", title, len(matches))
+		}
+	}
+	return ids, nil
+}
+
+//listAlbums returns all the albums owned by or shared with the user. Albums that are both owned and shared are only
+//returned once.
+func listAlbums(photoService *photoslibrary.Service, retry util.RetryPolicy) ([]*photoslibrary.Album, error) {
+	var albums []*photoslibrary.Album
+	seen := make(map[string]bool)
+	add := func(page []*photoslibrary.Album) {
+		for _, album := range page {
+			if !seen[album.Id] {
+				seen[album.Id] = true
+				albums = append(albums, album)
+			}
+		}
+	}
+	for nextPage := ""; ; {
+		var resp *photoslibrary.ListAlbumsResponse
+		err := retry.Do("listing albums", func() (err error) {
+			resp, err = photoService.Albums.List().PageSize(albumPageSize).PageToken(nextPage).Do()
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		add(resp.Albums)
+		if nextPage = resp.NextPageToken; len(nextPage) == 0 {
+			break
+		}
+	}
+	for nextPage := ""; ; {
+		var resp *photoslibrary.ListSharedAlbumsResponse
+		err := retry.Do("listing shared albums", func() (err error) {
+			resp, err = photoService.SharedAlbums.List().PageSize(albumPageSize).PageToken(nextPage).Do()
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		add(resp.SharedAlbums)
+		if nextPage = resp.NextPageToken; len(nextPage) == 0 {
+			break
+		}
+	}
+	return albums, nil
+}
+
+//buildFilters converts the filters configured for a source into the filters understood by the Google Photos api. It
+//returns nil if the source has no filters.
+func buildFilters(f *gomosaic.PhotoFilters) (*photoslibrary.Filters, error) {
+	if f == nil {
+		return nil, nil
+	}
+	filters := &photoslibrary.Filters{}
+	if len(f.DateRanges) > 0 {
+		filters.DateFilter = &photoslibrary.DateFilter{}
+		for _, r := range f.DateRanges {
+			start, err := parseDate(r.Start)
+			if err != nil {
+				return nil, err
+			}
+			end, err := parseDate(r.End)
+			if err != nil {
+				return nil, err
+			}
+			filters.DateFilter.Ranges = append(filters.DateFilter.Ranges,
+				&photoslibrary.DateRange{StartDate: start, EndDate: end})
+		}
+	}
+	if len(f.IncludeCategories) > 0 || len(f.ExcludeCategories) > 0 {
+		include, err := normalizeCategories(f.IncludeCategories)
+		if err != nil {
+			return nil, err
+		}
+		exclude, err := normalizeCategories(f.ExcludeCategories)
+		if err != nil {
+			return nil, err
+		}
+		filters.ContentFilter = &photoslibrary.ContentFilter{IncludedContentCategories: include,
+			ExcludedContentCategories: exclude}
+	}
+	if f.FavoritesOnly {
+		filters.FeatureFilter = &photoslibrary.FeatureFilter{IncludedFeatures: []string{"FAVORITES"}}
+	}
+	mediaType := strings.ToUpper(f.MediaType)
+	switch mediaType {
+	case "":
+		mediaType = "PHOTO"
+	case "PHOTO", "VIDEO", "ALL_MEDIA":
+	default:
+		return nil, fmt.Errorf("unrecognized media type %q", f.MediaType)
+	}
+	filters.MediaTypeFilter = &photoslibrary.MediaTypeFilter{MediaTypes: []string{mediaType}}
+	return filters, nil
+}
+
+//parseDate converts a date formatted as YYYY-MM-DD into a Date.
+func parseDate(value string) (*photoslibrary.Date, error) {
+	t, err := time.Parse(dateFormat, value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid date %q, expected YYYY-MM-DD", value)
+	}
+	return &photoslibrary.Date{Year: int64(t.Year()), Month: int64(t.Month()), Day: int64(t.Day())}, nil
+}
+
+//normalizeCategories upper-cases the content categories passed in and checks that they are supported by the api.
+func normalizeCategories(categories []string) ([]string, error) {
+	var normalized []string
+	for _, category := range categories {
+		category = strings.ToUpper(category)
+		if !contentCategories[category] {
+			return nil, fmt.Errorf("unrecognized content category %q", category)
+		}
+		normalized = append(normalized, category)
+	}
+	return normalized, nil
+}
+
+//getPage will fetch a page of MediaItems from the Google Photos api, retrying according to the policy passed in. The
+//filters may be nil.
+func getPage(photoService *photoslibrary.Service, retry util.RetryPolicy, albumId string, filters *photoslibrary.Filters,
+	nextPageToken string) (*photoslibrary.SearchMediaItemsResponse, error) {
+	var resp *photoslibrary.SearchMediaItemsResponse
+	err := retry.Do("searching media items", func() (err error) {
+		resp, err = photoService.MediaItems.Search(&photoslibrary.SearchMediaItemsRequest{AlbumId: albumId,
+			Filters:   filters,
+			PageSize:  desiredPageSize,
+			PageToken: nextPageToken}).Do()
+		return err
+	})
+	return resp, err
 }
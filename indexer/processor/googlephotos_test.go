@@ -0,0 +1,346 @@
+package processor
+
+import (
+	"encoding/json"
+	"github.com/cfagiani/gomosaic"
+	"github.com/cfagiani/gomosaic/util"
+	"google.golang.org/api/photoslibrary/v1"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+//fakePhotosServer is a stand-in for the subset of the Google Photos Library API used by the processor. Each media item
+//is served as a small solid-color image.
+type fakePhotosServer struct {
+	*httptest.Server
+	albums       []*photoslibrary.Album
+	sharedAlbums []*photoslibrary.Album
+	//media item IDs keyed by album ID. The items of the "" album are returned when no album is specified.
+	albumItems map[string][]string
+	//requests received by the search endpoint
+	searches []photoslibrary.SearchMediaItemsRequest
+	//number of media items returned per search page; 0 returns them all in one page
+	searchPageSize int
+	//status codes returned, in order, by the search endpoint before it starts to succeed
+	searchFailures []int
+	//value of the Retry-After header sent with failures
+	retryAfter string
+}
+
+//newFakePhotosServer starts a fake server. The caller must Close it.
+func newFakePhotosServer() *fakePhotosServer {
+	f := &fakePhotosServer{albumItems: make(map[string][]string)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/albums", func(w http.ResponseWriter, r *http.Request) {
+		//serve one album per page to exercise paging
+		page, next := pageOf(f.albums, r.URL.Query().Get("pageToken"))
+		json.NewEncoder(w).Encode(photoslibrary.ListAlbumsResponse{Albums: page, NextPageToken: next})
+	})
+	mux.HandleFunc("/v1/sharedAlbums", func(w http.ResponseWriter, r *http.Request) {
+		page, next := pageOf(f.sharedAlbums, r.URL.Query().Get("pageToken"))
+		json.NewEncoder(w).Encode(photoslibrary.ListSharedAlbumsResponse{SharedAlbums: page, NextPageToken: next})
+	})
+	mux.HandleFunc("/v1/mediaItems:search", func(w http.ResponseWriter, r *http.Request) {
+		var req photoslibrary.SearchMediaItemsRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		f.searches = append(f.searches, req)
+		if len(f.searchFailures) > 0 {
+			if f.retryAfter != "" {
+				w.Header().Set("Retry-After", f.retryAfter)
+			}
+			http.Error(w, `{"error": {"message": "injected failure"}}`, f.searchFailures[0])
+			f.searchFailures = f.searchFailures[1:]
+			return
+		}
+		ids := f.albumItems[req.AlbumId]
+		start, _ := strconv.Atoi(req.PageToken)
+		end, next := len(ids), ""
+		if f.searchPageSize > 0 && start+f.searchPageSize < len(ids) {
+			end = start + f.searchPageSize
+			next = strconv.Itoa(end)
+		}
+		var items []*photoslibrary.MediaItem
+		for _, id := range ids[start:end] {
+			items = append(items, &photoslibrary.MediaItem{Id: id, BaseUrl: f.URL + "/img/" + id,
+				MediaMetadata: &photoslibrary.MediaMetadata{Photo: &photoslibrary.Photo{}}})
+		}
+		json.NewEncoder(w).Encode(photoslibrary.SearchMediaItemsResponse{MediaItems: items, NextPageToken: next})
+	})
+	mux.HandleFunc("/img/", func(w http.ResponseWriter, r *http.Request) {
+		img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+		shade := uint8(len(strings.TrimPrefix(r.URL.Path, "/img/")) * 10)
+		for i := 0; i < 16; i++ {
+			img.Set(i%4, i/4, color.RGBA{shade, shade, shade, 255})
+		}
+		png.Encode(w, img)
+	})
+	f.Server = httptest.NewServer(mux)
+	return f
+}
+
+//pageOf returns a single album from the list, starting at the position encoded in the page token, along with the token
+//of the next page.
+func pageOf(albums []*photoslibrary.Album, token string) ([]*photoslibrary.Album, string) {
+	pos := len(token)
+	if pos >= len(albums) {
+		return nil, ""
+	}
+	next := ""
+	if pos+1 < len(albums) {
+		next = strings.Repeat("p", pos+1)
+	}
+	return albums[pos : pos+1], next
+}
+
+//service returns a client that talks to the fake server.
+func (f *fakePhotosServer) service(t *testing.T) *photoslibrary.Service {
+	service, err := photoslibrary.New(f.Client())
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+	service.BasePath = f.URL + "/"
+	return service
+}
+
+//TestGooglePhotosAlbums verifies that album names are resolved to IDs, including shared albums, and that only the
+//photos in the named albums are indexed.
+func TestGooglePhotosAlbums(t *testing.T) {
+	server := newFakePhotosServer()
+	defer server.Close()
+	server.albums = []*photoslibrary.Album{{Id: "a1", Title: "Vacation"}, {Id: "a2", Title: "Pets"},
+		{Id: "a3", Title: "Pets"}, {Id: "a4", Title: "Both"}}
+	server.sharedAlbums = []*photoslibrary.Album{{Id: "s1", Title: "Family"}, {Id: "a4", Title: "Both"}}
+	server.albumItems[""] = []string{"i1", "i2", "i3", "i4"}
+	server.albumItems["a1"] = []string{"i1", "i2"}
+	server.albumItems["s1"] = []string{"i2", "i3"}
+	server.albumItems["a4"] = []string{"i4"}
+
+	cases := []struct {
+		source   gomosaic.ImageSource
+		expected []string
+	}{
+		{gomosaic.ImageSource{}, []string{"i1", "i2", "i3", "i4"}},
+		{gomosaic.ImageSource{Path: "Vacation"}, []string{"i1", "i2"}},
+		{gomosaic.ImageSource{Path: "Family"}, []string{"i2", "i3"}},
+		{gomosaic.ImageSource{Albums: []string{"Vacation", "Family"}}, []string{"i1", "i2", "i3"}},
+		{gomosaic.ImageSource{Path: "Both"}, []string{"i4"}},
+		{gomosaic.ImageSource{Path: "Pets"}, nil},
+		{gomosaic.ImageSource{Albums: []string{"Vacation", "Missing"}}, nil},
+	}
+	for _, c := range cases {
+		c.source.Kind = GoogleKind
+		processor := GooglePhotosProcessor{Source: c.source, Service: server.service(t)}
+		index := processor.Process(gomosaic.MosaicTiles{}, gomosaic.MosaicTiles{})
+		var ids []string
+		for _, tile := range index {
+			if tile.Loc != "G" {
+				t.Errorf("Google tile had location %s", tile.Loc)
+			}
+			ids = append(ids, tile.Filename)
+		}
+		sort.Strings(ids)
+		if strings.Join(ids, ",") != strings.Join(c.expected, ",") {
+			t.Errorf("Indexing %+v returned %v but %v was expected", c.source, ids, c.expected)
+		}
+	}
+}
+
+//TestResolveAlbumIds verifies the errors returned for missing and ambiguous album names.
+func TestResolveAlbumIds(t *testing.T) {
+	server := newFakePhotosServer()
+	defer server.Close()
+	server.albums = []*photoslibrary.Album{{Id: "a1", Title: "Vacation"}, {Id: "a2", Title: "Pets"},
+		{Id: "a3", Title: "Pets"}}
+	cases := []struct {
+		titles      []string
+		expected    string
+		errContains string
+	}{
+		{[]string{"Vacation"}, "a1", ""},
+		{[]string{"Pets"}, "", "ambiguous"},
+		{[]string{"Vacation", "Nope"}, "", "no album named \"Nope\""},
+	}
+	for _, c := range cases {
+		ids, err := resolveAlbumIds(server.service(t), util.RetryPolicy{}, c.titles)
+		if c.errContains != "" {
+			if err == nil || !strings.Contains(err.Error(), c.errContains) {
+				t.Errorf("resolveAlbumIds(%v) returned error %v but one containing %q was expected", c.titles, err,
+					c.errContains)
+			}
+		} else if err != nil || strings.Join(ids, ",") != c.expected {
+			t.Errorf("resolveAlbumIds(%v) returned %v,%v but %v was expected", c.titles, ids, err, c.expected)
+		}
+	}
+}
+
+//TestGooglePhotosSkipped verifies that the photos indexed previously are kept when a source can't be indexed at all.
+func TestGooglePhotosSkipped(t *testing.T) {
+	server := newFakePhotosServer()
+	defer server.Close()
+	server.albums = []*photoslibrary.Album{{Id: "a1", Title: "Vacation"}}
+	dir, _ := ioutil.TempDir("", "google")
+	defer os.RemoveAll(dir)
+	oldIndex := gomosaic.MosaicTiles{{Loc: "G", Filename: "old"}, {Loc: "L", Filename: "local"}}
+
+	cases := []GooglePhotosProcessor{
+		{Source: gomosaic.ImageSource{Kind: GoogleKind, Filters: &gomosaic.PhotoFilters{MediaType: "AUDIO"}},
+			Service: server.service(t)},
+		{Source: gomosaic.ImageSource{Kind: GoogleKind, Options: filepath.Join(dir, "missing.json")}},
+		{Source: gomosaic.ImageSource{Kind: GoogleKind, Albums: []string{"Nope"}}, Service: server.service(t)},
+	}
+	for _, p := range cases {
+		index := p.Process(oldIndex, gomosaic.MosaicTiles{})
+		if len(index) != 1 || index[0] != oldIndex[0] {
+			t.Errorf("Skipping %+v returned %v but the previously indexed photo was expected", p.Source, index)
+		}
+	}
+}
+
+//TestGooglePhotosFilters verifies that the filters configured for a source are sent with each search request.
+func TestGooglePhotosFilters(t *testing.T) {
+	server := newFakePhotosServer()
+	defer server.Close()
+	server.albumItems[""] = []string{"i1"}
+	source := gomosaic.ImageSource{Kind: GoogleKind, Filters: &gomosaic.PhotoFilters{
+		DateRanges:        []gomosaic.DateRange{{Start: "2017-01-01", End: "2017-12-31"}},
+		IncludeCategories: []string{"landscapes"},
+		ExcludeCategories: []string{"SCREENSHOTS", "receipts"},
+		FavoritesOnly:     true,
+	}}
+	processor := GooglePhotosProcessor{Source: source, Service: server.service(t)}
+	if index := processor.Process(gomosaic.MosaicTiles{}, gomosaic.MosaicTiles{}); len(index) != 1 {
+		t.Errorf("Expected 1 tile to be indexed but got %d", len(index))
+	}
+	if len(server.searches) != 1 || server.searches[0].Filters == nil {
+		t.Fatalf("Search request did not include filters")
+	}
+	filters := server.searches[0].Filters
+	dates := filters.DateFilter.Ranges[0]
+	if dates.StartDate.Year != 2017 || dates.StartDate.Month != 1 || dates.EndDate.Month != 12 || dates.EndDate.Day != 31 {
+		t.Errorf("Date range was not mapped correctly: %+v %+v", dates.StartDate, dates.EndDate)
+	}
+	if strings.Join(filters.ContentFilter.IncludedContentCategories, ",") != "LANDSCAPES" ||
+		strings.Join(filters.ContentFilter.ExcludedContentCategories, ",") != "SCREENSHOTS,RECEIPTS" {
+		t.Errorf("Content categories were not mapped correctly: %+v", filters.ContentFilter)
+	}
+	if filters.FeatureFilter == nil || filters.FeatureFilter.IncludedFeatures[0] != "FAVORITES" {
+		t.Errorf("Favorites filter was not mapped")
+	}
+	if filters.MediaTypeFilter == nil || filters.MediaTypeFilter.MediaTypes[0] != "PHOTO" {
+		t.Errorf("Media type did not default to PHOTO")
+	}
+
+	//filters can't be combined with albums so nothing should be searched
+	server.searches = nil
+	source.Path = "Vacation"
+	processor = GooglePhotosProcessor{Source: source, Service: server.service(t)}
+	if index := processor.Process(gomosaic.MosaicTiles{}, gomosaic.MosaicTiles{}); len(index) != 0 || len(server.searches) != 0 {
+		t.Errorf("Source combining filters and an album should have been skipped")
+	}
+}
+
+//TestGooglePhotosRetries verifies that failed searches are retried from the page that failed and that, once retries are
+//exhausted, the photos indexed previously are kept unless other google sources may have dropped some of them.
+func TestGooglePhotosRetries(t *testing.T) {
+	server := newFakePhotosServer()
+	defer server.Close()
+	server.albumItems[""] = []string{"i1", "i2", "i3", "i4", "i5"}
+	server.searchPageSize = 2
+	var delays []time.Duration
+	retry := util.RetryPolicy{MaxAttempts: 3, Sleep: func(d time.Duration) { delays = append(delays, d) }}
+
+	cases := []struct {
+		failures   []int
+		retryAfter string
+		oldIndex   gomosaic.MosaicTiles
+		expected   []string
+		//page tokens of the search requests that were sent
+		tokens string
+		//number of google sources in the config
+		googleSources int
+	}{
+		{nil, "", nil, []string{"i1", "i2", "i3", "i4", "i5"}, ",2,4", 1},
+		{[]int{503, 500}, "", nil, []string{"i1", "i2", "i3", "i4", "i5"}, ",,,2,4", 1},
+		{[]int{429}, "7", nil, []string{"i1", "i2", "i3", "i4", "i5"}, ",,2,4", 1},
+		//client errors aren't retried
+		{[]int{400}, "", nil, nil, "", 1},
+		//retries are exhausted; previously indexed photos are kept
+		{[]int{503, 503, 503}, "",
+			gomosaic.MosaicTiles{{Loc: "G", Filename: "i2", AvgR: 1, AvgG: 1, AvgB: 1},
+				{Loc: "G", Filename: "old", AvgR: 1, AvgG: 1, AvgB: 1}, {Loc: "L", Filename: "local", AvgR: 1, AvgG: 1, AvgB: 1}},
+			[]string{"i2", "old"}, ",,", 1},
+		//with another google source the photos it dropped can't be told apart from those of this one
+		{[]int{503, 503, 503}, "",
+			gomosaic.MosaicTiles{{Loc: "G", Filename: "i2", AvgR: 1, AvgG: 1, AvgB: 1},
+				{Loc: "G", Filename: "old", AvgR: 1, AvgG: 1, AvgB: 1}, {Loc: "L", Filename: "local", AvgR: 1, AvgG: 1, AvgB: 1}},
+			nil, ",,", 2},
+	}
+	for _, c := range cases {
+		server.searches = nil
+		server.searchFailures = c.failures
+		server.retryAfter = c.retryAfter
+		delays = nil
+		var config gomosaic.Config
+		for i := 0; i < c.googleSources; i++ {
+			config.Sources = append(config.Sources, gomosaic.ImageSource{Kind: GoogleKind})
+		}
+		processor := GooglePhotosProcessor{Source: gomosaic.ImageSource{Kind: GoogleKind}, Config: config,
+			Service: server.service(t), Retry: retry}
+		index := processor.Process(c.oldIndex, gomosaic.MosaicTiles{})
+		var ids []string
+		for _, tile := range index {
+			ids = append(ids, tile.Filename)
+		}
+		sort.Strings(ids)
+		if strings.Join(ids, ",") != strings.Join(c.expected, ",") {
+			t.Errorf("With failures %v indexed %v but %v was expected", c.failures, ids, c.expected)
+		}
+		var tokens []string
+		for _, req := range server.searches {
+			tokens = append(tokens, req.PageToken)
+		}
+		if strings.Join(tokens, ",") != c.tokens {
+			t.Errorf("With failures %v searched pages %q but %q was expected", c.failures, strings.Join(tokens, ","),
+				c.tokens)
+		}
+		if c.retryAfter != "" && (len(delays) != 1 || delays[0] != 7*time.Second) {
+			t.Errorf("Retry-After was not honored: waited %v", delays)
+		}
+	}
+}
+
+//TestBuildFilters verifies that invalid filters are rejected.
+func TestBuildFilters(t *testing.T) {
+	cases := []struct {
+		filters     *gomosaic.PhotoFilters
+		expectNil   bool
+		expectError bool
+	}{
+		{nil, true, false},
+		{&gomosaic.PhotoFilters{}, false, false},
+		{&gomosaic.PhotoFilters{MediaType: "all_media"}, false, false},
+		{&gomosaic.PhotoFilters{MediaType: "gif"}, false, true},
+		{&gomosaic.PhotoFilters{IncludeCategories: []string{"cats"}}, false, true},
+		{&gomosaic.PhotoFilters{DateRanges: []gomosaic.DateRange{{Start: "2017-01-01", End: "2017-13-01"}}}, false, true},
+		{&gomosaic.PhotoFilters{DateRanges: []gomosaic.DateRange{{Start: "yesterday", End: "2017-01-01"}}}, false, true},
+	}
+	for _, c := range cases {
+		filters, err := buildFilters(c.filters)
+		if (err != nil) != c.expectError {
+			t.Errorf("buildFilters(%+v) returned error %v", c.filters, err)
+		} else if err == nil && (filters == nil) != c.expectNil {
+			t.Errorf("buildFilters(%+v) returned %+v", c.filters, filters)
+		}
+	}
+}
@@ -1,13 +1,21 @@
 package processor
 
 import (
+	"fmt"
 	"github.com/cfagiani/gomosaic"
 	"github.com/cfagiani/gomosaic/mosaicimages"
 	"github.com/cfagiani/gomosaic/util"
 	"io/ioutil"
 	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
 )
 
+//LocalProcessor indexes the images in a local directory. The files indexed are selected by the Local options of the
+//source; for compatibility, an Options value of RecurseOption is equivalent to setting Local.Recurse.
 type LocalProcessor struct {
 	Source gomosaic.ImageSource
 }
@@ -15,36 +23,315 @@ type LocalProcessor struct {
 const RecurseOption = "recurse"
 const LocalKind = "local"
 
-//Process will traverse a directory in a depth-first manner (if the option is set to recurse), looking for and analyzing any images. If the image is already in the
-//index, the data will simply be copied to the new index without re-analyzing the image.
+func init() {
+	Register(LocalKind, newLocalProcessor)
+}
+
+//newLocalProcessor validates the options of a local source. Options must either be blank or RecurseOption and the
+//patterns and limits of the Local options must be valid.
+func newLocalProcessor(source gomosaic.ImageSource, config gomosaic.Config) (IndexProcessor, error) {
+	if len(source.Options) > 0 && source.Options != RecurseOption {
+		return nil, fmt.Errorf("unsupported option %q (only %q is supported)", source.Options, RecurseOption)
+	}
+	if source.Local != nil {
+		if err := validateLocalOptions(*source.Local); err != nil {
+			return nil, err
+		}
+	}
+	return LocalProcessor{Source: source}, nil
+}
+
+//validateLocalOptions checks that the glob patterns are well formed and the limits are consistent.
+func validateLocalOptions(options gomosaic.LocalOptions) error {
+	for _, pattern := range append(append([]string{}, options.Include...), options.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %v", pattern, err)
+		}
+	}
+	if options.MinWidth < 0 || options.MinHeight < 0 || options.MaxWidth < 0 || options.MaxHeight < 0 ||
+		options.MinFileSize < 0 || options.MaxDepth < 0 {
+		return fmt.Errorf("size limits and maxDepth cannot be negative")
+	}
+	if options.MaxWidth > 0 && options.MinWidth > options.MaxWidth {
+		return fmt.Errorf("minWidth (%d) is greater than maxWidth (%d)", options.MinWidth, options.MaxWidth)
+	}
+	if options.MaxHeight > 0 && options.MinHeight > options.MaxHeight {
+		return fmt.Errorf("minHeight (%d) is greater than maxHeight (%d)", options.MinHeight, options.MaxHeight)
+	}
+	return nil
+}
+
+//Process will traverse a directory in a depth-first manner (if the source recurses), looking for and analyzing any
+//images selected by the source's options. If the image is already in the index, the data will simply be copied to the
+//new index without re-analyzing the image. Since the options are checked for images that are already indexed as well,
+//images that no longer match them are dropped from the index. Directories that can't be read are skipped and reported
+//once the traversal is complete.
 func (p LocalProcessor) Process(oldIndex gomosaic.MosaicTiles, newIndex gomosaic.MosaicTiles) gomosaic.MosaicTiles {
-	files, err := ioutil.ReadDir(p.Source.Path)
+	return p.ProcessRecording(oldIndex, newIndex, nil)
+}
+
+//ProcessRecording does the same as Process and passes each tile it analyzes to record.
+func (p LocalProcessor) ProcessRecording(oldIndex gomosaic.MosaicTiles, newIndex gomosaic.MosaicTiles,
+	record TileRecorder) gomosaic.MosaicTiles {
+	w := localWalk{options: p.options(), oldIndex: oldIndex, newIndex: newIndex, visited: make(map[string]bool),
+		record: record}
+	w.walkDir(p.Source.Path, "", 0)
+	w.report(p.Source.Path)
+	return w.newIndex
+}
+
+//ProcessPaths updates the tiles of the files and directories at the paths passed in, which changed since the index was
+//built, without traversing the rest of the source. The tiles at or below those paths are dropped from the index and
+//the images found there that the source selects are added back: files whose path was passed in are analyzed again
+//while those below a directory whose path was passed in are copied from the index if they were already analyzed.
+//Paths outside the source are ignored.
+func (p LocalProcessor) ProcessPaths(index gomosaic.MosaicTiles, paths []string) gomosaic.MosaicTiles {
+	root := util.GetPath(p.Source.Path, "")
+	changed := make(map[string]bool)
+	for _, name := range paths {
+		if strings.HasPrefix(name, root) || name == p.Source.Path {
+			changed[name] = true
+		}
+	}
+	if len(changed) == 0 {
+		return index
+	}
+	var known, newIndex gomosaic.MosaicTiles
+	for _, tile := range index {
+		if tile.Loc != "L" || !underChanged(tile.Filename, p.Source.Path, changed) {
+			newIndex = append(newIndex, tile)
+		} else if !changed[tile.Filename] {
+			known = append(known, tile)
+		}
+	}
+	sort.Sort(known)
+
+	w := localWalk{options: p.options(), oldIndex: known, newIndex: newIndex, visited: make(map[string]bool)}
+	for name := range changed {
+		//the paths below a directory that changed are handled along with it
+		if name == p.Source.Path || !underChanged(name[:strings.LastIndex(name, string(os.PathSeparator))], p.Source.Path,
+			changed) {
+			w.walkPath(p.Source.Path, name)
+		}
+	}
+	w.report(p.Source.Path)
+	return w.newIndex
+}
+
+//underChanged returns true if the file, or one of the directories between it and the source directory dir, is in
+//changed.
+func underChanged(filename string, dir string, changed map[string]bool) bool {
+	root := util.GetPath(dir, "")
+	for name := filename; strings.HasPrefix(name, root); name = name[:strings.LastIndex(name, string(os.PathSeparator))] {
+		if changed[name] {
+			return true
+		}
+	}
+	return changed[dir] && (filename == dir || strings.HasPrefix(filename, root))
+}
+
+//localWalk holds the state of the traversal of a local source.
+type localWalk struct {
+	options  gomosaic.LocalOptions
+	oldIndex gomosaic.MosaicTiles
+	newIndex gomosaic.MosaicTiles
+	//identities (see fileID) of the directories already searched
+	visited map[string]bool
+	errors  []error
+	//number of images analyzed
+	count int
+	//receives each tile analyzed
+	record TileRecorder
+}
+
+//report logs the number of images analyzed and the errors that occurred while indexing the source at dir.
+func (w *localWalk) report(dir string) {
+	log.Printf("Added %d new files to index\n", w.count)
+	if len(w.errors) > 0 {
+		log.Printf("%d errors occurred while indexing %s:\n", len(w.errors), dir)
+		for _, err := range w.errors {
+			log.Printf("  %v\n", err)
+		}
+	}
+}
+
+//walkPath indexes the file or directory at filename, a path below the source directory dir, if the source would reach
+//it when indexing dir. Nothing is added if it no longer exists.
+func (w *localWalk) walkPath(dir string, filename string) {
+	if filename == dir {
+		w.walkDir(dir, "", 0)
+		return
+	}
+	relName := filepath.ToSlash(strings.TrimPrefix(filename, util.GetPath(dir, "")))
+	parts := strings.Split(relName, "/")
+	depth := len(parts) - 1
+	if depth > 0 && (!w.options.Recurse || (w.options.MaxDepth > 0 && depth > w.options.MaxDepth)) {
+		return
+	}
+	for i, part := range parts {
+		if (!w.options.IncludeHidden && strings.HasPrefix(part, ".")) ||
+			matchesAny(w.options.Exclude, strings.Join(parts[:i+1], "/")) {
+			return
+		}
+	}
+	file, err := os.Lstat(filename)
 	if err != nil {
-		log.Fatal(err)
+		return
 	}
-	count := 0
-	log.Printf("Indexing %s\n", p.Source)
+	if file.Mode()&os.ModeSymlink != 0 {
+		if file, err = os.Stat(filename); err != nil {
+			w.errors = append(w.errors, fmt.Errorf("broken link %s: %v", filename, err))
+			return
+		}
+		if file.IsDir() && !w.options.FollowSymlinks {
+			return
+		}
+	}
+	if file.IsDir() {
+		if w.options.Recurse && (w.options.MaxDepth == 0 || depth < w.options.MaxDepth) {
+			w.walkDir(filename, relName, depth+1)
+		}
+		return
+	}
+	if selected(filepath.Dir(filename), file, relName, w.options) {
+		w.addFile(filename)
+	}
+}
+
+//walkDir indexes the images in dir, whose path relative to the source path is relDir and which is depth levels below
+//it.
+func (w *localWalk) walkDir(dir string, relDir string, depth int) {
+	if w.options.FollowSymlinks {
+		info, err := os.Stat(dir)
+		if err != nil {
+			w.dirError(dir, err)
+			return
+		}
+		id, err := fileID(dir, info)
+		if err != nil {
+			w.dirError(dir, err)
+			return
+		}
+		if w.visited[id] {
+			log.Printf("Skipping %s since it was already indexed\n", dir)
+			return
+		}
+		w.visited[id] = true
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		w.dirError(dir, err)
+		return
+	}
+	log.Printf("Indexing %s\n", dir)
 	for _, file := range files {
-		filename := util.GetPath(p.Source.Path, file.Name())
-		if file.IsDir() && p.Source.Options == RecurseOption {
-			processor := LocalProcessor{gomosaic.ImageSource{Options: RecurseOption, Path: filename, Kind: LocalKind}}
-			newIndex = processor.Process(oldIndex, newIndex)
-		} else if mosaicimages.IsSupportedImage(p.Source.Path, file) {
-			existingTile := find(filename, oldIndex)
-			if existingTile == nil {
-				imageSegment, err := mosaicimages.AnalyzeImage(filename)
-				if err == nil {
-					//now add to index
-					newIndex = append(newIndex,
-						gomosaic.MosaicTile{Loc: "L", Filename: filename, AvgR: imageSegment.RVal, AvgG: imageSegment.GVal, AvgB: imageSegment.BVal})
-					count++
-				}
-			} else {
-				newIndex = append(newIndex, *existingTile)
+		filename := util.GetPath(dir, file.Name())
+		relName := path.Join(relDir, file.Name())
+		if (!w.options.IncludeHidden && strings.HasPrefix(file.Name(), ".")) || matchesAny(w.options.Exclude, relName) {
+			continue
+		}
+		if file.Mode()&os.ModeSymlink != 0 {
+			//use the target of the link, which is how files behind links were always indexed
+			target, err := os.Stat(filename)
+			if err != nil {
+				w.errors = append(w.errors, fmt.Errorf("broken link %s: %v", filename, err))
+				continue
+			}
+			if target.IsDir() && !w.options.FollowSymlinks {
+				continue
 			}
+			file = target
+		}
+		if file.IsDir() {
+			if w.options.Recurse && (w.options.MaxDepth == 0 || depth < w.options.MaxDepth) {
+				w.walkDir(filename, relName, depth+1)
+			}
+			continue
+		}
+		if selected(dir, file, relName, w.options) {
+			w.addFile(filename)
+		}
+	}
+}
+
+//addFile adds the tile of the image to the new index, analyzing it unless it is already in the old index.
+func (w *localWalk) addFile(filename string) {
+	existingTile := findAnalyzed(filename, w.oldIndex)
+	if existingTile == nil {
+		tile, err := mosaicimages.AnalyzeTileImage(filename)
+		if err == nil {
+			//now add to index
+			tile.Loc, tile.Filename = "L", filename
+			w.newIndex = append(w.newIndex, tile)
+			w.record.record(tile)
+			w.count++
+		} else {
+			w.errors = append(w.errors, fmt.Errorf("could not analyze %s: %v", filename, err))
 		}
+	} else {
+		w.newIndex = append(w.newIndex, *existingTile)
+	}
+}
+
+//dirError records that dir couldn't be read. If that is because of its permissions, the tiles already indexed from it
+//are kept since the problem is likely to be temporary.
+func (w *localWalk) dirError(dir string, err error) {
+	w.errors = append(w.errors, fmt.Errorf("could not read directory %s: %v", dir, err))
+	if !os.IsPermission(err) {
+		return
 	}
-	log.Printf("Added %d new files to index\n", count)
-	return newIndex
+	prefix := util.GetPath(dir, "")
+	for i := sort.Search(len(w.oldIndex), func(i int) bool { return w.oldIndex[i].Filename >= prefix }); i < len(w.oldIndex) &&
+		strings.HasPrefix(w.oldIndex[i].Filename, prefix); i++ {
+		if w.oldIndex[i].Loc == "L" {
+			w.newIndex = append(w.newIndex, w.oldIndex[i])
+		}
+	}
+}
+
+//options returns the Local options of the source, taking the legacy RecurseOption into account. Sources without Local
+//options index hidden files, as they did before those options existed.
+func (p LocalProcessor) options() gomosaic.LocalOptions {
+	options := gomosaic.LocalOptions{IncludeHidden: true}
+	if p.Source.Local != nil {
+		options = *p.Source.Local
+	}
+	options.Recurse = options.Recurse || p.Source.Options == RecurseOption
+	return options
+}
 
+//selected returns true if the file is a supported image that matches the include patterns and the size limits. The
+//dimensions are only read if they are limited.
+func selected(dir string, file os.FileInfo, relName string, options gomosaic.LocalOptions) bool {
+	if len(options.Include) > 0 && !matchesAny(options.Include, relName) {
+		return false
+	}
+	if file.Size() < options.MinFileSize || !mosaicimages.IsSupportedImage(dir, file) {
+		return false
+	}
+	if options.MinWidth == 0 && options.MinHeight == 0 && options.MaxWidth == 0 && options.MaxHeight == 0 {
+		return true
+	}
+	width, height, err := mosaicimages.GetImageDimensions(util.GetPath(dir, file.Name()))
+	if err != nil {
+		log.Printf("Could not read the dimensions of %s: %v\n", relName, err)
+		return false
+	}
+	return width >= options.MinWidth && height >= options.MinHeight &&
+		(options.MaxWidth == 0 || width <= options.MaxWidth) && (options.MaxHeight == 0 || height <= options.MaxHeight)
+}
+
+//matchesAny returns true if one of the glob patterns matches the relative path (for patterns containing a '/') or the
+//last element of the path (for other patterns).
+func matchesAny(patterns []string, relName string) bool {
+	for _, pattern := range patterns {
+		target := path.Base(relName)
+		if strings.Contains(pattern, "/") {
+			target = relName
+		}
+		if matched, _ := path.Match(pattern, target); matched {
+			return true
+		}
+	}
+	return false
 }
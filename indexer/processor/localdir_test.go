@@ -0,0 +1,245 @@
+package processor
+
+import (
+	"github.com/cfagiani/gomosaic"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+//writeTestPNG writes a width x height png to path. If noisy is true the pixels vary so the file doesn't compress well.
+func writeTestPNG(t *testing.T, path string, width int, height int, noisy bool) {
+	os.MkdirAll(filepath.Dir(path), 0755)
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	seed := uint32(1)
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			shade := uint8(100)
+			if noisy {
+				seed = seed*1664525 + 1013904223
+				shade = uint8(seed >> 24)
+			}
+			img.Set(x, y, color.RGBA{shade, shade, shade, 255})
+		}
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Could not create %s: %v", path, err)
+	}
+	defer f.Close()
+	png.Encode(f, img)
+}
+
+//TestLocalProcessorOptions verifies that the patterns, hidden file skipping and size limits select the files indexed.
+func TestLocalProcessorOptions(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "local")
+	defer os.RemoveAll(dir)
+	writeTestPNG(t, filepath.Join(dir, "photo.png"), 64, 48, true)
+	writeTestPNG(t, filepath.Join(dir, "icon.png"), 16, 16, false)
+	writeTestPNG(t, filepath.Join(dir, "wide.png"), 120, 10, false)
+	writeTestPNG(t, filepath.Join(dir, ".git", "logo.png"), 64, 64, false)
+	writeTestPNG(t, filepath.Join(dir, ".hidden.png"), 64, 64, false)
+	writeTestPNG(t, filepath.Join(dir, "album", "beach.png"), 64, 64, false)
+	writeTestPNG(t, filepath.Join(dir, "album", "thumbnails", "beach.png"), 8, 8, false)
+	ioutil.WriteFile(filepath.Join(dir, "album", "notes.png"), []byte("not an image"), 0644)
+
+	cases := []struct {
+		options  string
+		local    *gomosaic.LocalOptions
+		expected string
+	}{
+		{"", nil, ".hidden.png,icon.png,photo.png,wide.png"},
+		{RecurseOption, nil,
+			".git/logo.png,.hidden.png,album/beach.png,album/thumbnails/beach.png,icon.png,photo.png,wide.png"},
+		{RecurseOption, &gomosaic.LocalOptions{}, "album/beach.png,album/thumbnails/beach.png,icon.png,photo.png,wide.png"},
+		{"", &gomosaic.LocalOptions{Recurse: true, Exclude: []string{"thumbnails", "icon.*"}},
+			"album/beach.png,photo.png,wide.png"},
+		{RecurseOption, &gomosaic.LocalOptions{Include: []string{"album/*.png"}}, "album/beach.png"},
+		{"", &gomosaic.LocalOptions{Recurse: true, Include: []string{"beach.png"}, Exclude: []string{"album/thumbnails"}},
+			"album/beach.png"},
+		{"", &gomosaic.LocalOptions{Recurse: true, IncludeHidden: true, Include: []string{"*o.png"}},
+			".git/logo.png,photo.png"},
+		{"", &gomosaic.LocalOptions{MinWidth: 32, MinHeight: 32}, "photo.png"},
+		{"", &gomosaic.LocalOptions{MinWidth: 32, MaxWidth: 100}, "photo.png"},
+		{"", &gomosaic.LocalOptions{MaxHeight: 16}, "icon.png,wide.png"},
+		{"", &gomosaic.LocalOptions{MinFileSize: 1024}, "photo.png"},
+	}
+	for _, c := range cases {
+		p, err := New(gomosaic.ImageSource{Kind: LocalKind, Path: dir, Options: c.options, Local: c.local},
+			gomosaic.Config{})
+		if err != nil {
+			t.Fatalf("Could not create processor: %v", err)
+		}
+		var names []string
+		for _, tile := range p.Process(nil, gomosaic.MosaicTiles{}) {
+			rel, _ := filepath.Rel(dir, tile.Filename)
+			names = append(names, filepath.ToSlash(rel))
+		}
+		sort.Strings(names)
+		if got := strings.Join(names, ","); got != c.expected {
+			t.Errorf("Indexing with %q and %+v returned %s but %s was expected", c.options, c.local, got, c.expected)
+		}
+	}
+}
+
+//TestNewLocalProcessor verifies the validation of local source options.
+func TestNewLocalProcessor(t *testing.T) {
+	cases := []struct {
+		local       *gomosaic.LocalOptions
+		expectError bool
+	}{
+		{nil, false},
+		{&gomosaic.LocalOptions{Include: []string{"*.jpg"}, Exclude: []string{".cache", "thumbs/*"}}, false},
+		{&gomosaic.LocalOptions{MinWidth: 100, MaxWidth: 100, MinHeight: 10, MaxHeight: 0}, false},
+		{&gomosaic.LocalOptions{Exclude: []string{"[thumbs"}}, true},
+		{&gomosaic.LocalOptions{MinWidth: 200, MaxWidth: 100}, true},
+		{&gomosaic.LocalOptions{MinHeight: 200, MaxHeight: 100}, true},
+		{&gomosaic.LocalOptions{MinFileSize: -1}, true},
+	}
+	for _, c := range cases {
+		if _, err := New(gomosaic.ImageSource{Kind: LocalKind, Local: c.local}, gomosaic.Config{}); (err != nil) != c.expectError {
+			t.Errorf("New(%+v) returned %v", c.local, err)
+		}
+	}
+}
+
+//TestLocalProcessorTraversal verifies that links to directories are only followed when asked to, that link cycles are
+//detected and that the depth of the search can be limited.
+func TestLocalProcessorTraversal(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "traversal")
+	defer os.RemoveAll(dir)
+	root := filepath.Join(dir, "root")
+	writeTestPNG(t, filepath.Join(root, "top.png"), 8, 8, false)
+	writeTestPNG(t, filepath.Join(root, "a", "b", "c", "deep.png"), 8, 8, false)
+	writeTestPNG(t, filepath.Join(dir, "elsewhere", "linked.png"), 8, 8, false)
+	if err := os.Symlink(filepath.Join(dir, "elsewhere"), filepath.Join(root, "a", "elsewhere")); err != nil {
+		t.Skipf("Symbolic links are not supported: %v", err)
+	}
+	os.Symlink(root, filepath.Join(root, "a", "b", "loop"))
+	os.Symlink(filepath.Join(dir, "missing"), filepath.Join(root, "broken"))
+
+	cases := []struct {
+		local          gomosaic.LocalOptions
+		expected       string
+		expectedErrors int
+	}{
+		{gomosaic.LocalOptions{Recurse: true}, "a/b/c/deep.png,top.png", 1},
+		{gomosaic.LocalOptions{Recurse: true, FollowSymlinks: true}, "a/b/c/deep.png,a/elsewhere/linked.png,top.png", 1},
+		{gomosaic.LocalOptions{Recurse: true, FollowSymlinks: true, MaxDepth: 2}, "a/elsewhere/linked.png,top.png", 1},
+		{gomosaic.LocalOptions{Recurse: true, FollowSymlinks: true, MaxDepth: 1}, "top.png", 1},
+		{gomosaic.LocalOptions{Recurse: true, MaxDepth: 2}, "top.png", 1},
+		{gomosaic.LocalOptions{Recurse: true, MaxDepth: 3}, "a/b/c/deep.png,top.png", 1},
+	}
+	for _, c := range cases {
+		w := localWalk{options: c.local, visited: make(map[string]bool)}
+		w.walkDir(root, "", 0)
+		var names []string
+		for _, tile := range w.newIndex {
+			rel, _ := filepath.Rel(root, tile.Filename)
+			names = append(names, filepath.ToSlash(rel))
+		}
+		sort.Strings(names)
+		if got := strings.Join(names, ","); got != c.expected || len(w.errors) != c.expectedErrors {
+			t.Errorf("Indexing with %+v returned %s and %v but %s was expected", c.local, got, w.errors, c.expected)
+		}
+	}
+}
+
+//TestLocalProcessorErrors verifies that directories that can't be read are reported without stopping the traversal
+//and that tiles are only kept for directories that can't be read because of their permissions.
+func TestLocalProcessorErrors(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "errors")
+	defer os.RemoveAll(dir)
+	writeTestPNG(t, filepath.Join(dir, "top.png"), 8, 8, false)
+	locked := filepath.Join(dir, "locked")
+	oldIndex := gomosaic.MosaicTiles{
+		{Loc: "L", Filename: locked + string(os.PathSeparator) + "kept.png", AvgR: 1, AvgG: 2, AvgB: 3},
+		{Loc: "L", Filename: locked + "2" + string(os.PathSeparator) + "other.png", AvgR: 1, AvgG: 2, AvgB: 3}}
+
+	w := localWalk{options: gomosaic.LocalOptions{Recurse: true}, oldIndex: oldIndex, visited: make(map[string]bool)}
+	w.walkDir(dir, "", 0)
+	w.dirError(locked, &os.PathError{Op: "open", Path: locked, Err: os.ErrPermission})
+	w.dirError(locked+"2", &os.PathError{Op: "open", Path: locked + "2", Err: os.ErrNotExist})
+	if len(w.newIndex) != 2 || w.newIndex[1].Filename != oldIndex[0].Filename || len(w.errors) != 2 {
+		t.Errorf("Expected top.png and the tile of the locked directory but got %v and errors %v", w.newIndex, w.errors)
+	}
+
+	p := LocalProcessor{Source: gomosaic.ImageSource{Kind: LocalKind, Path: filepath.Join(dir, "missing")}}
+	if index := p.Process(oldIndex, gomosaic.MosaicTiles{}); len(index) != 0 {
+		t.Errorf("Indexing a missing directory returned %v", index)
+	}
+}
+
+//TestLocalProcessorRecording verifies that the images analyzed are recorded and those copied from the old index are not.
+//Tiles indexed before perceptual hashes were computed are analyzed again.
+func TestLocalProcessorRecording(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "recording")
+	defer os.RemoveAll(dir)
+	writeTestPNG(t, filepath.Join(dir, "a.png"), 8, 8, false)
+	writeTestPNG(t, filepath.Join(dir, "b.png"), 8, 8, true)
+	writeTestPNG(t, filepath.Join(dir, "c.png"), 8, 8, true)
+	oldIndex := gomosaic.MosaicTiles{{Loc: "L", Filename: filepath.Join(dir, "a.png"), AvgR: 1, AvgG: 2, AvgB: 3,
+		HasHash: true},
+		{Loc: "L", Filename: filepath.Join(dir, "c.png"), AvgR: 1, AvgG: 2, AvgB: 3}}
+
+	var recorded []string
+	p := LocalProcessor{Source: gomosaic.ImageSource{Kind: LocalKind, Path: dir}}
+	index := p.ProcessRecording(oldIndex, gomosaic.MosaicTiles{}, func(tile gomosaic.MosaicTile) {
+		recorded = append(recorded, tile.Filename)
+	})
+	if len(index) != 3 || strings.Join(recorded, ",") != filepath.Join(dir, "b.png")+","+filepath.Join(dir, "c.png") {
+		t.Errorf("Expected b.png and c.png to be recorded but recorded %v and indexed %v", recorded, index)
+	}
+	if index[0] != oldIndex[0] || index[2].Hash == 0 {
+		t.Errorf("Expected a.png to be copied and c.png to be hashed but indexed %v", index)
+	}
+}
+
+//TestLocalProcessPaths verifies that only the files at or below the paths passed in are indexed again.
+func TestLocalProcessPaths(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "paths")
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"a.png", "kept.png", "untouched.png", ".hidden.png", "album/b.png", "album/new.png"} {
+		writeTestPNG(t, filepath.Join(dir, name), 8, 8, false)
+	}
+	tile := func(name string) gomosaic.MosaicTile {
+		return gomosaic.MosaicTile{Loc: "L", Filename: filepath.Join(dir, name), AvgR: 1, HasHash: true}
+	}
+	google := gomosaic.MosaicTile{Loc: "G", Filename: "id", AvgR: 1}
+	index := gomosaic.MosaicTiles{tile("a.png"), tile("album/b.png"), tile("deleted.png"), tile("kept.png"), google}
+	sort.Sort(index)
+	p := LocalProcessor{Source: gomosaic.ImageSource{Kind: LocalKind, Path: dir,
+		Local: &gomosaic.LocalOptions{Recurse: true}}}
+
+	paths := []string{filepath.Join(dir, "a.png"), filepath.Join(dir, "album"), filepath.Join(dir, "deleted.png"),
+		filepath.Join(dir, ".hidden.png"), filepath.Join(filepath.Dir(dir), "elsewhere.png")}
+	updated := p.ProcessPaths(index, paths)
+	sort.Sort(updated)
+	var names []string
+	byName := make(map[string]gomosaic.MosaicTile)
+	for _, tile := range updated {
+		if tile.Loc == "L" {
+			rel, _ := filepath.Rel(dir, tile.Filename)
+			names = append(names, filepath.ToSlash(rel))
+			byName[filepath.ToSlash(rel)] = tile
+		}
+	}
+	if strings.Join(names, ",") != "a.png,album/b.png,album/new.png,kept.png" {
+		t.Errorf("ProcessPaths indexed %v", names)
+	}
+	if byName["a.png"].AvgR == 1 || byName["album/new.png"].AvgR == 1 {
+		t.Errorf("Expected a.png and album/new.png to be analyzed but got %v", updated)
+	}
+	if byName["album/b.png"] != tile("album/b.png") || byName["kept.png"] != tile("kept.png") {
+		t.Errorf("Expected the tiles of files that didn't change to be kept but got %v", updated)
+	}
+	if len(updated) != 5 || updated[len(updated)-1] != google {
+		t.Errorf("Expected the tiles of other sources to be kept but got %v", updated)
+	}
+}
@@ -0,0 +1,65 @@
+package processor
+
+import (
+	"fmt"
+	"github.com/cfagiani/gomosaic"
+	"sort"
+	"strings"
+	"sync"
+)
+
+//Factory creates the IndexProcessor for a source of the kind it was registered for. It is responsible for parsing and
+//validating the source (including its options) and should return an error describing the problem if the source can't
+//be indexed.
+type Factory func(source gomosaic.ImageSource, config gomosaic.Config) (IndexProcessor, error)
+
+var (
+	registryMu sync.RWMutex
+	factories  = make(map[string]Factory)
+)
+
+//Register makes a source kind available to the indexer. It is meant to be called from the init function of the package
+//implementing the processor, which then only needs to be imported for its kind to be usable in a configuration file.
+//Register panics if the kind is blank, the factory is nil or the kind is already registered.
+func Register(kind string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if len(kind) == 0 {
+		panic("processor: Register called with a blank kind")
+	}
+	if factory == nil {
+		panic("processor: Register factory for " + kind + " is nil")
+	}
+	if _, dup := factories[kind]; dup {
+		panic("processor: Register called twice for kind " + kind)
+	}
+	factories[kind] = factory
+}
+
+//New returns the IndexProcessor for the source using the factory registered for its kind.
+func New(source gomosaic.ImageSource, config gomosaic.Config) (IndexProcessor, error) {
+	registryMu.RLock()
+	factory, ok := factories[source.Kind]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unrecognized source kind %q (known kinds are %s)", source.Kind,
+			strings.Join(Kinds(), ", "))
+	}
+	p, err := factory(source, config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s source %q: %v", source.Kind, source.Path, err)
+	}
+	return p, nil
+}
+
+//Kinds returns the registered source kinds in sorted order.
+func Kinds() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	kinds := make([]string, 0, len(factories))
+	for kind := range factories {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
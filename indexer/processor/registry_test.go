@@ -0,0 +1,79 @@
+package processor
+
+import (
+	"errors"
+	"github.com/cfagiani/gomosaic"
+	"strings"
+	"testing"
+)
+
+//fakeProcessor adds a single tile named after its source path.
+type fakeProcessor struct {
+	path string
+}
+
+func (p fakeProcessor) Process(oldIndex gomosaic.MosaicTiles, newIndex gomosaic.MosaicTiles) gomosaic.MosaicTiles {
+	return append(newIndex, gomosaic.MosaicTile{Loc: "F", Filename: p.path})
+}
+
+//TestRegister verifies that registered kinds can be created, that their factories can reject sources and that invalid
+//registrations panic.
+func TestRegister(t *testing.T) {
+	Register("test-fake", func(source gomosaic.ImageSource, config gomosaic.Config) (IndexProcessor, error) {
+		if source.Options != "ok" {
+			return nil, errors.New("options must be ok")
+		}
+		return fakeProcessor{source.Path}, nil
+	})
+	//the registry is global so the kind is removed for the test to be repeatable
+	t.Cleanup(func() {
+		registryMu.Lock()
+		delete(factories, "test-fake")
+		registryMu.Unlock()
+	})
+
+	cases := []struct {
+		source      gomosaic.ImageSource
+		errContains string
+	}{
+		{gomosaic.ImageSource{Kind: "test-fake", Path: "somewhere", Options: "ok"}, ""},
+		{gomosaic.ImageSource{Kind: "test-fake", Path: "somewhere", Options: "bad"}, "options must be ok"},
+		{gomosaic.ImageSource{Kind: "test-missing"}, "unrecognized source kind \"test-missing\""},
+		{gomosaic.ImageSource{Kind: LocalKind, Options: "bad"}, "unsupported option"},
+		{gomosaic.ImageSource{Kind: GoogleKind, Path: "album", Filters: &gomosaic.PhotoFilters{}}, "cannot be combined"},
+	}
+	for _, c := range cases {
+		p, err := New(c.source, gomosaic.Config{})
+		if len(c.errContains) > 0 {
+			if err == nil || !strings.Contains(err.Error(), c.errContains) {
+				t.Errorf("New(%+v) returned error %v but one containing %q was expected", c.source, err, c.errContains)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("New(%+v) returned an unexpected error: %v", c.source, err)
+		} else if index := p.Process(nil, nil); len(index) != 1 || index[0].Filename != c.source.Path {
+			t.Errorf("Processor created for %+v returned %v", c.source, index)
+		}
+	}
+
+	kinds := strings.Join(Kinds(), ",")
+	if !strings.Contains(kinds, GoogleKind) || !strings.Contains(kinds, LocalKind) || !strings.Contains(kinds, "test-fake") {
+		t.Errorf("Kinds returned %s", kinds)
+	}
+
+	factory := func(source gomosaic.ImageSource, config gomosaic.Config) (IndexProcessor, error) { return nil, nil }
+	for _, reg := range []struct {
+		kind    string
+		factory Factory
+	}{{"test-fake", factory}, {"", factory}, {"test-nil", nil}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Register(%q) should have panicked", reg.kind)
+				}
+			}()
+			Register(reg.kind, reg.factory)
+		}()
+	}
+}
@@ -0,0 +1,118 @@
+package processor
+
+import (
+	"errors"
+	"fmt"
+	"github.com/cfagiani/gomosaic"
+	"github.com/cfagiani/gomosaic/mosaicimages"
+	"github.com/cfagiani/gomosaic/s3"
+	"log"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const (
+	S3Kind = "s3"
+	//location recorded in tiles indexed from s3
+	s3Loc = "S"
+	//default number of objects downloaded at the same time
+	defaultS3Workers = 4
+)
+
+//extensions of the objects that are downloaded and analyzed
+var s3ImageExtensions = map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".gif": true}
+
+//S3Processor indexes the images stored in a bucket of an S3-compatible object store. The bucket, prefix, endpoint and
+//credentials are taken from the S3 field of the source. Tiles are named using s3.TileName, which includes the ETag of
+//the object so changed objects are analyzed again.
+type S3Processor struct {
+	Source gomosaic.ImageSource
+	Client *s3.Client
+	//maximum number of objects downloaded at the same time
+	Workers int
+}
+
+func init() {
+	Register(S3Kind, newS3Processor)
+}
+
+//newS3Processor validates an s3 source, which must name a bucket. The only option is workers (the number of concurrent
+//downloads), e.g. "workers=8".
+func newS3Processor(source gomosaic.ImageSource, config gomosaic.Config) (IndexProcessor, error) {
+	if source.S3 == nil || len(source.S3.Bucket) == 0 {
+		return nil, errors.New("s3 sources must specify a bucket")
+	}
+	options, err := parseOptions(source.Options, "workers")
+	if err != nil {
+		return nil, err
+	}
+	p := S3Processor{Source: source, Client: s3.NewClient(*source.S3), Workers: defaultS3Workers}
+	if value, ok := options["workers"]; ok {
+		if p.Workers, err = strconv.Atoi(value); err != nil || p.Workers < 1 {
+			return nil, fmt.Errorf("workers must be a positive number but was %q", value)
+		}
+	}
+	return p, nil
+}
+
+//Process lists the objects in the bucket and analyzes the images that aren't in the index with their current ETag.
+//Objects that can't be downloaded or decoded are logged and skipped. If the bucket can't be listed, the tiles
+//previously indexed from it are kept.
+func (p S3Processor) Process(oldIndex gomosaic.MosaicTiles, newIndex gomosaic.MosaicTiles) gomosaic.MosaicTiles {
+	return p.ProcessRecording(oldIndex, newIndex, nil)
+}
+
+//ProcessRecording does the same as Process and passes each tile it analyzes to record.
+func (p S3Processor) ProcessRecording(oldIndex gomosaic.MosaicTiles, newIndex gomosaic.MosaicTiles,
+	record TileRecorder) gomosaic.MosaicTiles {
+	bucket := p.Source.S3.Bucket
+	log.Printf("Indexing s3://%s/%s\n", bucket, p.Source.S3.Prefix)
+	objects, err := p.Client.ListObjects(bucket, p.Source.S3.Prefix)
+	if err != nil {
+		log.Printf("Could not list s3 bucket %s: %v\n", bucket, err)
+		prefix := "s3://" + bucket + "/" + p.Source.S3.Prefix
+		for _, tile := range oldIndex {
+			if tile.Loc == s3Loc && strings.HasPrefix(tile.Filename, prefix) {
+				newIndex = append(newIndex, tile)
+			}
+		}
+		return newIndex
+	}
+
+	var pending []string
+	for _, object := range objects {
+		if !s3ImageExtensions[strings.ToLower(path.Ext(object.Key))] {
+			continue
+		}
+		name := s3.TileName(bucket, object.Key, object.ETag)
+		if existingTile := findAnalyzed(name, oldIndex); existingTile != nil && existingTile.Loc == s3Loc {
+			newIndex = append(newIndex, *existingTile)
+		} else {
+			pending = append(pending, name)
+		}
+	}
+	tiles := analyzeConcurrently(pending, p.Workers, p.analyze, record)
+	newIndex = append(newIndex, tiles...)
+	log.Printf("Added %d new files to index\n", len(tiles))
+	return newIndex
+}
+
+//analyze downloads the object named by the tile name and computes its average color.
+func (p S3Processor) analyze(name string) (*gomosaic.MosaicTile, error) {
+	bucket, key, _, err := s3.ParseTileName(name)
+	if err != nil {
+		return nil, err
+	}
+	body, err := p.Client.GetObject(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	tile, err := mosaicimages.AnalyzeTile(body)
+	if err != nil {
+		return nil, err
+	}
+	tile.Loc, tile.Filename = s3Loc, name
+	return &tile, nil
+}
@@ -0,0 +1,139 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/xml"
+	"github.com/cfagiani/gomosaic"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+//fakeBucket serves the objects of a single bucket using the ListObjectsV2 and GetObject operations. The ETag of each
+//object is its shade, which is changed by setting a new one.
+type fakeBucket struct {
+	sync.Mutex
+	shades map[string]uint8
+	gets   int
+}
+
+func (f *fakeBucket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	key := strings.TrimPrefix(r.URL.Path, "/photos/")
+	if key == r.URL.Path {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("<Error><Code>NoSuchBucket</Code></Error>"))
+		return
+	}
+	if len(key) > 0 {
+		f.gets++
+		shade, ok := f.shades[key]
+		if !ok || strings.HasSuffix(key, "broken.png") {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("<Error><Code>NoSuchKey</Code></Error>"))
+			return
+		}
+		img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+		for i := 0; i < 16; i++ {
+			img.Set(i%4, i/4, color.RGBA{shade, shade, shade, 255})
+		}
+		png.Encode(w, img)
+		return
+	}
+	type content struct {
+		Key  string
+		ETag string
+	}
+	var result struct {
+		XMLName  xml.Name `xml:"ListBucketResult"`
+		Contents []content
+	}
+	var keys []string
+	for key := range f.shades {
+		if strings.HasPrefix(key, r.URL.Query().Get("prefix")) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		result.Contents = append(result.Contents, content{key, `"` + string('a'+f.shades[key]%26) + `"`})
+	}
+	var buf bytes.Buffer
+	xml.NewEncoder(&buf).Encode(result)
+	w.Write(buf.Bytes())
+}
+
+//TestS3Processor verifies that images in the bucket are indexed under the prefix, that unchanged objects aren't
+//downloaded again and that changed objects are analyzed again.
+func TestS3Processor(t *testing.T) {
+	bucket := &fakeBucket{shades: map[string]uint8{"tiles/1.png": 10, "tiles/2.PNG": 20, "tiles/notes.txt": 0,
+		"tiles/broken.png": 30, "other/3.png": 40}}
+	server := httptest.NewServer(bucket)
+	defer server.Close()
+
+	source := gomosaic.ImageSource{Kind: S3Kind, Options: "workers=2",
+		S3: &gomosaic.S3Location{Bucket: "photos", Prefix: "tiles/", Endpoint: server.URL}}
+	p, err := New(source, gomosaic.Config{})
+	if err != nil {
+		t.Fatalf("Could not create processor: %v", err)
+	}
+
+	index := p.Process(gomosaic.MosaicTiles{}, gomosaic.MosaicTiles{})
+	if len(index) != 2 || bucket.gets != 3 {
+		t.Fatalf("Indexing returned %v after %d downloads", index, bucket.gets)
+	}
+	for i, expected := range []struct {
+		name  string
+		shade uint32
+	}{{"s3://photos/tiles/1.png#k", 10}, {"s3://photos/tiles/2.PNG#u", 20}} {
+		if index[i].Loc != s3Loc || index[i].Filename != expected.name || index[i].AvgG != expected.shade*257 {
+			t.Errorf("Expected %s with shade %d but got %+v", expected.name, expected.shade, index[i])
+		}
+	}
+
+	bucket.gets = 0
+	bucket.shades["tiles/2.PNG"] = 21
+	sort.Sort(index)
+	reindexed := p.Process(index, gomosaic.MosaicTiles{})
+	if len(reindexed) != 2 || bucket.gets != 2 || reindexed[1].Filename != "s3://photos/tiles/2.PNG#v" ||
+		reindexed[1].AvgR != 21*257 {
+		t.Errorf("Reindexing returned %v after %d downloads", reindexed, bucket.gets)
+	}
+
+	//if the bucket can't be listed, the tiles already indexed from it are kept
+	source.S3 = &gomosaic.S3Location{Bucket: "missing", Endpoint: server.URL}
+	p, _ = New(source, gomosaic.Config{})
+	kept := p.Process(gomosaic.MosaicTiles{{Loc: s3Loc, Filename: "s3://missing/1.png#a", AvgR: 1, AvgG: 2, AvgB: 3},
+		{Loc: s3Loc, Filename: "s3://photos/1.png#a", AvgR: 1, AvgG: 2, AvgB: 3}}, gomosaic.MosaicTiles{})
+	if len(kept) != 1 || kept[0].Filename != "s3://missing/1.png#a" {
+		t.Errorf("Expected the tiles of the missing bucket to be kept but got %v", kept)
+	}
+}
+
+//TestNewS3Processor verifies the validation of s3 sources.
+func TestNewS3Processor(t *testing.T) {
+	cases := []struct {
+		source      gomosaic.ImageSource
+		expectError bool
+	}{
+		{gomosaic.ImageSource{S3: &gomosaic.S3Location{Bucket: "photos"}}, false},
+		{gomosaic.ImageSource{S3: &gomosaic.S3Location{Bucket: "photos"}, Options: "workers=8"}, false},
+		{gomosaic.ImageSource{}, true},
+		{gomosaic.ImageSource{S3: &gomosaic.S3Location{Prefix: "tiles/"}}, true},
+		{gomosaic.ImageSource{S3: &gomosaic.S3Location{Bucket: "photos"}, Options: "workers=none"}, true},
+		{gomosaic.ImageSource{S3: &gomosaic.S3Location{Bucket: "photos"}, Options: "recurse"}, true},
+	}
+	for _, c := range cases {
+		c.source.Kind = S3Kind
+		if _, err := New(c.source, gomosaic.Config{}); (err != nil) != c.expectError {
+			t.Errorf("New(%+v) returned %v", c.source, err)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package processor
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/cfagiani/gomosaic"
+	"github.com/cfagiani/gomosaic/mosaicimages"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	URLKind = "url"
+	//location recorded in tiles indexed from urls
+	urlLoc = "U"
+	//default number of images downloaded at the same time
+	defaultURLWorkers = 4
+	//default time allowed for each download
+	defaultURLTimeout = 30 * time.Second
+	//largest url list that will be read
+	maxURLListBytes = 32 << 20
+)
+
+//URLProcessor indexes images downloaded from a list of urls. The Path of the source is the location (a local file or an
+//http(s) url) of the list, which may be a text file with one url per line, a sitemap (using image:loc entries when
+//present) or a json document holding either an array of urls or a JSON Feed (using the image of each item and its
+//image attachments). The url of each image is stored as the tile's file name.
+type URLProcessor struct {
+	Source gomosaic.ImageSource
+	//maximum number of images downloaded at the same time
+	Workers int
+	//Client is used for all downloads; its timeout applies to each request.
+	Client *http.Client
+}
+
+func init() {
+	Register(URLKind, newURLProcessor)
+}
+
+//newURLProcessor parses the options of a url source: workers (the number of concurrent downloads) and timeout (the time
+//allowed for each download, e.g. 10s), for instance "workers=8,timeout=10s".
+func newURLProcessor(source gomosaic.ImageSource, config gomosaic.Config) (IndexProcessor, error) {
+	if len(source.Path) == 0 {
+		return nil, errors.New("path must be the location of a list of urls")
+	}
+	options, err := parseOptions(source.Options, "workers", "timeout")
+	if err != nil {
+		return nil, err
+	}
+	p := URLProcessor{Source: source, Workers: defaultURLWorkers}
+	timeout := defaultURLTimeout
+	if value, ok := options["workers"]; ok {
+		if p.Workers, err = strconv.Atoi(value); err != nil || p.Workers < 1 {
+			return nil, fmt.Errorf("workers must be a positive number but was %q", value)
+		}
+	}
+	if value, ok := options["timeout"]; ok {
+		if timeout, err = time.ParseDuration(value); err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("timeout must be a positive duration (e.g. 10s) but was %q", value)
+		}
+	}
+	p.Client = &http.Client{Timeout: timeout}
+	return p, nil
+}
+
+//Process reads the list of urls and analyzes every image that isn't already in the index. Images are downloaded by a
+//pool of Workers; images that can't be downloaded or decoded are logged and skipped.
+func (p URLProcessor) Process(oldIndex gomosaic.MosaicTiles, newIndex gomosaic.MosaicTiles) gomosaic.MosaicTiles {
+	return p.ProcessRecording(oldIndex, newIndex, nil)
+}
+
+//ProcessRecording does the same as Process and passes each tile it analyzes to record.
+func (p URLProcessor) ProcessRecording(oldIndex gomosaic.MosaicTiles, newIndex gomosaic.MosaicTiles,
+	record TileRecorder) gomosaic.MosaicTiles {
+	log.Printf("Indexing urls listed in %s\n", p.Source.Path)
+	urls, err := p.readList()
+	if err != nil {
+		log.Printf("Skipping url source: %v\n", err)
+		//tiles don't record the list they came from, so keep every url tile rather than dropping those of this list
+		return keepUnseenTiles(oldIndex, newIndex, urlLoc, nil)
+	}
+
+	var pending []string
+	seen := make(map[string]bool)
+	for _, u := range urls {
+		if seen[u] {
+			continue
+		}
+		seen[u] = true
+		if existingTile := findAnalyzed(u, oldIndex); existingTile != nil && existingTile.Loc == urlLoc {
+			newIndex = append(newIndex, *existingTile)
+		} else {
+			pending = append(pending, u)
+		}
+	}
+
+	tiles := analyzeConcurrently(pending, p.Workers, p.analyze, record)
+	newIndex = append(newIndex, tiles...)
+	log.Printf("Added %d new files to index\n", len(tiles))
+	return newIndex
+}
+
+//analyze downloads the image at the url and computes its average color.
+func (p URLProcessor) analyze(u string) (*gomosaic.MosaicTile, error) {
+	body, err := mosaicimages.FetchImage(p.Client, u)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	tile, err := mosaicimages.AnalyzeTile(body)
+	if err != nil {
+		return nil, err
+	}
+	tile.Loc, tile.Filename = urlLoc, u
+	return &tile, nil
+}
+
+//readList reads the list of urls from the source path. Relative urls in a remote list are resolved against the url of
+//the list.
+func (p URLProcessor) readList() ([]string, error) {
+	var r io.ReadCloser
+	var base *url.URL
+	var err error
+	if strings.HasPrefix(p.Source.Path, "http://") || strings.HasPrefix(p.Source.Path, "https://") {
+		if base, err = url.Parse(p.Source.Path); err != nil {
+			return nil, err
+		}
+		r, err = mosaicimages.FetchImage(p.Client, p.Source.Path)
+	} else {
+		r, err = os.Open(p.Source.Path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read url list: %v", err)
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(r, maxURLListBytes))
+	if err != nil {
+		return nil, fmt.Errorf("could not read url list: %v", err)
+	}
+	listed, err := parseURLList(data)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse url list %s: %v", p.Source.Path, err)
+	}
+
+	var urls []string
+	for _, entry := range listed {
+		u, err := url.Parse(entry)
+		if err != nil {
+			log.Printf("Ignoring invalid url %q\n", entry)
+			continue
+		}
+		if base != nil {
+			u = base.ResolveReference(u)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			log.Printf("Ignoring url %q since only http and https urls are supported\n", entry)
+			continue
+		}
+		urls = append(urls, u.String())
+	}
+	return urls, nil
+}
+
+//parseURLList extracts the image urls from a list, detecting its format from its first character: '<' for a sitemap,
+//'{' or '[' for json and anything else for a text file with one url per line (blank lines and lines starting with #
+//are ignored).
+func parseURLList(data []byte) ([]string, error) {
+	data = bytes.TrimSpace(data)
+	if bytes.HasPrefix(data, []byte("<")) {
+		return parseSitemap(data)
+	}
+	if bytes.HasPrefix(data, []byte("{")) || bytes.HasPrefix(data, []byte("[")) {
+		return parseJSONList(data)
+	}
+	var urls []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) > 0 && !strings.HasPrefix(line, "#") {
+			urls = append(urls, line)
+		}
+	}
+	return urls, scanner.Err()
+}
+
+//parseSitemap returns the images of each url in a sitemap (image:image/image:loc) or, for urls without images, the url
+//itself.
+func parseSitemap(data []byte) ([]string, error) {
+	var sitemap struct {
+		URLs []struct {
+			Loc    string `xml:"loc"`
+			Images []struct {
+				Loc string `xml:"loc"`
+			} `xml:"image"`
+		} `xml:"url"`
+	}
+	if err := xml.Unmarshal(data, &sitemap); err != nil {
+		return nil, err
+	}
+	var urls []string
+	for _, entry := range sitemap.URLs {
+		if len(entry.Images) == 0 {
+			urls = append(urls, strings.TrimSpace(entry.Loc))
+		}
+		for _, img := range entry.Images {
+			urls = append(urls, strings.TrimSpace(img.Loc))
+		}
+	}
+	return urls, nil
+}
+
+//parseJSONList returns the urls in a json array of strings or the images in a JSON Feed (https://jsonfeed.org): the
+//image of each item along with any attachments with an image mime type.
+func parseJSONList(data []byte) ([]string, error) {
+	if data[0] == '[' {
+		var urls []string
+		err := json.Unmarshal(data, &urls)
+		return urls, err
+	}
+	var feed struct {
+		Items []struct {
+			Image       string `json:"image"`
+			Attachments []struct {
+				URL      string `json:"url"`
+				MimeType string `json:"mime_type"`
+			} `json:"attachments"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(data, &feed); err != nil {
+		return nil, err
+	}
+	var urls []string
+	for _, item := range feed.Items {
+		if len(item.Image) > 0 {
+			urls = append(urls, item.Image)
+		}
+		for _, attachment := range item.Attachments {
+			if strings.HasPrefix(attachment.MimeType, "image/") {
+				urls = append(urls, attachment.URL)
+			}
+		}
+	}
+	return urls, nil
+}
@@ -0,0 +1,162 @@
+package processor
+
+import (
+	"fmt"
+	"github.com/cfagiani/gomosaic"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//newFakeImageServer serves solid gray images under /img/<shade>.png, a url that never responds in time under /slow.png
+//and whatever lists are passed in (keyed by path). The number of image requests is counted in downloads.
+func newFakeImageServer(lists map[string]string, downloads *int64) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/img/", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(downloads, 1)
+		var shade uint8
+		if _, err := fmt.Sscanf(strings.TrimPrefix(r.URL.Path, "/img/"), "%d.png", &shade); err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+		for i := 0; i < 16; i++ {
+			img.Set(i%4, i/4, color.RGBA{shade, shade, shade, 255})
+		}
+		png.Encode(w, img)
+	})
+	mux.HandleFunc("/slow.png", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(2 * time.Second):
+		case <-r.Context().Done():
+		}
+	})
+	mux.HandleFunc("/notanimage.png", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "<html></html>")
+	})
+	for path, content := range lists {
+		content := content
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, content)
+		})
+	}
+	return httptest.NewServer(mux)
+}
+
+//TestParseURLList verifies that urls are extracted from each of the supported list formats.
+func TestParseURLList(t *testing.T) {
+	cases := []struct {
+		list     string
+		expected []string
+	}{
+		{"# images\nhttp://a/1.png\n\n  http://a/2.png  \n", []string{"http://a/1.png", "http://a/2.png"}},
+		{`<?xml version="1.0"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9" xmlns:image="http://www.google.com/schemas/sitemap-image/1.1">
+  <url><loc>http://a/page.html</loc><image:image><image:loc>http://a/1.png</image:loc></image:image>
+    <image:image><image:loc>http://a/2.png</image:loc></image:image></url>
+  <url><loc>http://a/3.png</loc></url>
+</urlset>`, []string{"http://a/1.png", "http://a/2.png", "http://a/3.png"}},
+		{`["http://a/1.png", "http://a/2.png"]`, []string{"http://a/1.png", "http://a/2.png"}},
+		{`{"version": "https://jsonfeed.org/version/1.1", "items": [
+			{"id": "1", "url": "http://a/post", "image": "http://a/1.png"},
+			{"id": "2", "attachments": [{"url": "http://a/2.png", "mime_type": "image/png"},
+				{"url": "http://a/a.mp3", "mime_type": "audio/mpeg"}]}]}`, []string{"http://a/1.png", "http://a/2.png"}},
+	}
+	for _, c := range cases {
+		urls, err := parseURLList([]byte(c.list))
+		if err != nil || strings.Join(urls, ",") != strings.Join(c.expected, ",") {
+			t.Errorf("parseURLList(%q) returned %v, %v but %v was expected", c.list, urls, err, c.expected)
+		}
+	}
+	if _, err := parseURLList([]byte("<urlset><url>")); err == nil {
+		t.Errorf("parseURLList should have returned an error for a malformed sitemap")
+	}
+}
+
+//TestURLProcessor verifies that images listed in local and remote lists are indexed, that failed downloads are
+//skipped and that urls already in the index aren't downloaded again.
+func TestURLProcessor(t *testing.T) {
+	var downloads int64
+	server := newFakeImageServer(map[string]string{
+		"/list/sitemap.xml": `<urlset><url><loc>../img/10.png</loc></url><url><loc>/img/20.png</loc></url></urlset>`,
+	}, &downloads)
+	defer server.Close()
+
+	dir, _ := ioutil.TempDir("", "urls")
+	defer os.RemoveAll(dir)
+	listFile := filepath.Join(dir, "urls.txt")
+	ioutil.WriteFile(listFile, []byte(strings.Join([]string{server.URL + "/img/10.png", server.URL + "/img/30.png",
+		server.URL + "/img/10.png", server.URL + "/img/missing.png", server.URL + "/slow.png",
+		server.URL + "/notanimage.png", "ftp://elsewhere/1.png", server.URL + "/img/40.png"}, "\n")), 0644)
+
+	oldIndex := gomosaic.MosaicTiles{
+		{Loc: urlLoc, Filename: server.URL + "/img/40.png", AvgR: 1, AvgG: 2, AvgB: 3, Hash: 1, HasHash: true}}
+	cases := []struct {
+		path              string
+		expected          map[string]uint32
+		expectedDownloads int64
+	}{
+		{server.URL + "/list/sitemap.xml", map[string]uint32{"/img/10.png": 10, "/img/20.png": 20}, 2},
+		//40.png is already indexed; 10.png is only downloaded once
+		{listFile, map[string]uint32{"/img/10.png": 10, "/img/30.png": 30, "/img/40.png": 1}, 3},
+		//the list can't be read so the tiles indexed previously are kept
+		{filepath.Join(dir, "missing.txt"), map[string]uint32{"/img/40.png": 1}, 0},
+	}
+	for _, c := range cases {
+		atomic.StoreInt64(&downloads, 0)
+		p, err := New(gomosaic.ImageSource{Kind: URLKind, Path: c.path, Options: "workers=3,timeout=200ms"},
+			gomosaic.Config{})
+		if err != nil {
+			t.Fatalf("Could not create processor: %v", err)
+		}
+		index := p.Process(oldIndex, gomosaic.MosaicTiles{})
+		if len(index) != len(c.expected) {
+			t.Errorf("Indexing %s returned %v", c.path, index)
+		}
+		for _, tile := range index {
+			shade, ok := c.expected[strings.TrimPrefix(tile.Filename, server.URL)]
+			//averages are 16 bit values and the existing tile keeps the values it was indexed with
+			if shade != 1 {
+				shade *= 257
+			}
+			if !ok || tile.Loc != urlLoc || tile.AvgR != shade || (shade != 1 && tile.AvgB != shade) {
+				t.Errorf("Indexing %s returned unexpected tile %+v", c.path, tile)
+			}
+		}
+		//only requests for /img/ are counted: the slow and unsupported images aren't
+		if got := atomic.LoadInt64(&downloads); got != c.expectedDownloads {
+			t.Errorf("Indexing %s downloaded %d images but %d was expected", c.path, got, c.expectedDownloads)
+		}
+	}
+}
+
+//TestNewURLProcessor verifies the validation of url source options.
+func TestNewURLProcessor(t *testing.T) {
+	cases := []struct {
+		source      gomosaic.ImageSource
+		expectError bool
+	}{
+		{gomosaic.ImageSource{Path: "urls.txt"}, false},
+		{gomosaic.ImageSource{Path: "urls.txt", Options: "workers=2, timeout=1m"}, false},
+		{gomosaic.ImageSource{Path: ""}, true},
+		{gomosaic.ImageSource{Path: "urls.txt", Options: "workers=0"}, true},
+		{gomosaic.ImageSource{Path: "urls.txt", Options: "timeout=soon"}, true},
+		{gomosaic.ImageSource{Path: "urls.txt", Options: "recurse"}, true},
+		{gomosaic.ImageSource{Path: "urls.txt", Options: "depth=2"}, true},
+	}
+	for _, c := range cases {
+		c.source.Kind = URLKind
+		if _, err := New(c.source, gomosaic.Config{}); (err != nil) != c.expectError {
+			t.Errorf("New(%+v) returned %v", c.source, err)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package indexer
+
+import (
+	"fmt"
+	"github.com/cfagiani/gomosaic"
+	"github.com/cfagiani/gomosaic/mosaicimages"
+	"io"
+)
+
+//DuplicateReport writes the groups of tiles in the index whose perceptual hashes are within maxDistance bits of each
+//other (see mosaicimages.DuplicateGroups), listing each tile with its distance from the first tile of the group, and
+//returns the number of groups.
+func DuplicateReport(w io.Writer, index gomosaic.MosaicTiles, maxDistance int) int {
+	groups := mosaicimages.DuplicateGroups(index, maxDistance)
+	duplicates := 0
+	for n, group := range groups {
+		first := index[group[0]]
+		fmt.Fprintf(w, "Group %d (%d tiles):\n", n+1, len(group))
+		for _, i := range group {
+			fmt.Fprintf(w, "  %s;%s (distance %d)\n", index[i].Loc, index[i].Filename,
+				mosaicimages.HashDistance(first.Hash, index[i].Hash))
+		}
+		duplicates += len(group) - 1
+	}
+	fmt.Fprintf(w, "%d groups of similar tiles; %d tiles are duplicates of another\n", len(groups), duplicates)
+	return len(groups)
+}
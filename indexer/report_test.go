@@ -0,0 +1,29 @@
+package indexer
+
+import (
+	"bytes"
+	"github.com/cfagiani/gomosaic"
+	"strings"
+	"testing"
+)
+
+//TestDuplicateReport verifies that the groups of similar tiles are listed along with a summary.
+func TestDuplicateReport(t *testing.T) {
+	index := gomosaic.MosaicTiles{{Loc: "L", Filename: "a.jpg", Hash: 0xff00, HasHash: true},
+		{Loc: "L", Filename: "b.jpg", Hash: 0x00ff, HasHash: true}, {Loc: "G", Filename: "c", Hash: 0xff01, HasHash: true},
+		{Loc: "L", Filename: "d.jpg"}, {Loc: "L", Filename: "e.jpg", Hash: 0xff03, HasHash: true}}
+	var out bytes.Buffer
+	if groups := DuplicateReport(&out, index, 1); groups != 1 {
+		t.Errorf("DuplicateReport returned %d groups", groups)
+	}
+	expected := "Group 1 (3 tiles):\n  L;a.jpg (distance 0)\n  G;c (distance 1)\n  L;e.jpg (distance 2)\n" +
+		"1 groups of similar tiles; 2 tiles are duplicates of another\n"
+	if out.String() != expected {
+		t.Errorf("DuplicateReport wrote\n%s\nbut expected\n%s", out.String(), expected)
+	}
+
+	out.Reset()
+	if groups := DuplicateReport(&out, index, -1); groups != 0 || !strings.HasPrefix(out.String(), "0 groups") {
+		t.Errorf("DuplicateReport with a negative distance returned %d and wrote %s", groups, out.String())
+	}
+}
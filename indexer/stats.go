@@ -0,0 +1,210 @@
+package indexer
+
+import (
+	"fmt"
+	"github.com/cfagiani/gomosaic"
+	"github.com/cfagiani/gomosaic/mosaicimages"
+	"github.com/cfagiani/gomosaic/s3"
+	"io"
+	"math"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	//width of the longest bar drawn in a histogram
+	histogramWidth = 40
+	//number of values a 16 bit color channel can take
+	channelValues = 65536
+)
+
+//IndexStats summarizes the contents of an index.
+type IndexStats struct {
+	Tiles int
+	//number of tiles by location code (e.g. L for local files)
+	ByLoc map[string]int
+	//number of tiles by source (see TileSource)
+	BySource map[string]int
+	//number of tiles with a perceptual hash
+	Hashed int
+}
+
+//CoverageGap is a segment of a target image that has no tile within the distance threshold.
+type CoverageGap struct {
+	Segment gomosaic.ImageSegment
+	//distance, in 8 bit color units, to the closest tile
+	Distance float64
+	//the closest tile
+	Closest gomosaic.MosaicTile
+}
+
+//Stats counts the tiles in the index by location and source.
+func Stats(index gomosaic.MosaicTiles) IndexStats {
+	stats := IndexStats{Tiles: len(index), ByLoc: make(map[string]int), BySource: make(map[string]int)}
+	for _, tile := range index {
+		stats.ByLoc[tile.Loc]++
+		stats.BySource[TileSource(tile)]++
+		if tile.HasHash {
+			stats.Hashed++
+		}
+	}
+	return stats
+}
+
+//TileSource returns a description of where a tile came from: the directory of a local file, the archive of an archive
+//entry, the scheme and host of a url, the bucket of an s3 object or "Google Photos".
+func TileSource(tile gomosaic.MosaicTile) string {
+	switch tile.Loc {
+	case "L":
+		return filepath.Dir(tile.Filename)
+	case "G":
+		return "Google Photos"
+	case "A":
+		if archive, _, err := mosaicimages.SplitArchiveEntryName(tile.Filename); err == nil {
+			return archive
+		}
+	case "U":
+		if u, err := url.Parse(tile.Filename); err == nil {
+			return u.Scheme + "://" + u.Host
+		}
+	case "S":
+		if bucket, _, _, err := s3.ParseTileName(tile.Filename); err == nil {
+			return "s3://" + bucket
+		}
+	}
+	return tile.Loc
+}
+
+//ColorHistogram divides each color channel into bins ranges and returns the number of tiles whose average color falls
+//in each of the bins^3 cells. The count of the cell with red bin r, green bin g and blue bin b is at r*bins*bins+g*bins+b.
+func ColorHistogram(index gomosaic.MosaicTiles, bins int) []int {
+	counts := make([]int, bins*bins*bins)
+	for _, tile := range index {
+		counts[colorBin(tile.AvgR, tile.AvgG, tile.AvgB, bins)]++
+	}
+	return counts
+}
+
+//colorBin returns the position in a histogram of the cell containing the 16 bit color passed in.
+func colorBin(r uint32, g uint32, b uint32, bins int) int {
+	bin := func(v uint32) int {
+		if v >= channelValues {
+			return bins - 1
+		}
+		return int(v) * bins / channelValues
+	}
+	return bin(r)*bins*bins + bin(g)*bins + bin(b)
+}
+
+//binColor returns the hex color at the center of a histogram cell.
+func binColor(cell int, bins int) string {
+	center := func(bin int) int {
+		return (2*bin + 1) * 256 / (2 * bins)
+	}
+	return fmt.Sprintf("#%02x%02x%02x", center(cell/(bins*bins)), center(cell/bins%bins), center(cell%bins))
+}
+
+//FindCoverageGaps returns the segments whose closest tile is further than threshold, expressed in 8 bit color units
+//(the euclidean distance between two colors with channels from 0 to 255).
+func FindCoverageGaps(segments []gomosaic.ImageSegment, index gomosaic.MosaicTiles, threshold float64) []CoverageGap {
+	var gaps []CoverageGap
+	for _, segment := range segments {
+		best := math.MaxFloat64
+		var closest gomosaic.MosaicTile
+		for _, tile := range index {
+			dr := float64(segment.RVal) - float64(tile.AvgR)
+			dg := float64(segment.GVal) - float64(tile.AvgG)
+			db := float64(segment.BVal) - float64(tile.AvgB)
+			if d := dr*dr + dg*dg + db*db; d < best {
+				best, closest = d, tile
+			}
+		}
+		//averages are 16 bit values so scale the distance down to 8 bits
+		distance := math.Sqrt(best) / 257
+		if distance > threshold {
+			gaps = append(gaps, CoverageGap{Segment: segment, Distance: distance, Closest: closest})
+		}
+	}
+	return gaps
+}
+
+//WriteStats writes the counts by location and source followed by the color histogram of the index.
+func WriteStats(w io.Writer, index gomosaic.MosaicTiles, bins int) {
+	stats := Stats(index)
+	fmt.Fprintf(w, "%d tiles (%d with a perceptual hash)\n", stats.Tiles, stats.Hashed)
+	fmt.Fprintln(w, "\nBy location:")
+	writeCounts(w, stats.ByLoc)
+	fmt.Fprintln(w, "\nBy source:")
+	writeCounts(w, stats.BySource)
+
+	fmt.Fprintf(w, "\nColor histogram (%d bins per channel, labeled with the color at their center):\n", bins)
+	counts := ColorHistogram(index, bins)
+	most := 0
+	for _, count := range counts {
+		if count > most {
+			most = count
+		}
+	}
+	for cell, count := range counts {
+		bar := 0
+		if most > 0 {
+			bar = (count*histogramWidth + most - 1) / most
+		}
+		fmt.Fprintln(w, strings.TrimRight(fmt.Sprintf("  %s %8d %s", binColor(cell, bins), count, strings.Repeat("#", bar)), " "))
+	}
+}
+
+//WriteCoverageGaps writes the number of gaps and, grouping them by the histogram cell of their color, how many
+//segments of each color have no close tile. Cells are listed from the most to the least segments.
+func WriteCoverageGaps(w io.Writer, gaps []CoverageGap, segments int, threshold float64, bins int) {
+	fmt.Fprintf(w, "%d of %d segments have no tile within %g\n", len(gaps), segments, threshold)
+	if len(gaps) == 0 {
+		return
+	}
+	byCell := make(map[int][]CoverageGap)
+	for _, gap := range gaps {
+		cell := colorBin(gap.Segment.RVal, gap.Segment.GVal, gap.Segment.BVal, bins)
+		byCell[cell] = append(byCell[cell], gap)
+	}
+	cells := make([]int, 0, len(byCell))
+	for cell := range byCell {
+		cells = append(cells, cell)
+	}
+	sort.Slice(cells, func(i, j int) bool {
+		if len(byCell[cells[i]]) != len(byCell[cells[j]]) {
+			return len(byCell[cells[i]]) > len(byCell[cells[j]])
+		}
+		return cells[i] < cells[j]
+	})
+	fmt.Fprintln(w, "Colors that need more tiles:")
+	for _, cell := range cells {
+		worst := byCell[cell][0]
+		for _, gap := range byCell[cell] {
+			if gap.Distance > worst.Distance {
+				worst = gap
+			}
+		}
+		fmt.Fprintf(w, "  %s %6d segments (furthest is #%02x%02x%02x, %.1f from %s)\n", binColor(cell, bins),
+			len(byCell[cell]), worst.Segment.RVal>>8, worst.Segment.GVal>>8, worst.Segment.BVal>>8, worst.Distance,
+			worst.Closest.Filename)
+	}
+}
+
+//writeCounts writes the counts sorted from the largest to the smallest, breaking ties by name.
+func writeCounts(w io.Writer, counts map[string]int) {
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if counts[names[i]] != counts[names[j]] {
+			return counts[names[i]] > counts[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	for _, name := range names {
+		fmt.Fprintf(w, "  %8d %s\n", counts[name], name)
+	}
+}
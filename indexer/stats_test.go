@@ -0,0 +1,66 @@
+package indexer
+
+import (
+	"bytes"
+	"github.com/cfagiani/gomosaic"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+//TestStats verifies the counts by location and source.
+func TestStats(t *testing.T) {
+	index := gomosaic.MosaicTiles{{Loc: "L", Filename: "/photos/a.jpg", Hash: 1, HasHash: true},
+		{Loc: "L", Filename: "/photos/b.jpg"}, {Loc: "L", Filename: "/other/c.jpg"},
+		{Loc: "G", Filename: "id1", HasHash: true}, {Loc: "A", Filename: "/x.zip!/d.png"},
+		{Loc: "U", Filename: "https://example.com/e.png"}, {Loc: "S", Filename: "s3://bucket/f.png#etag"},
+		{Loc: "Z", Filename: "g"}}
+	stats := Stats(index)
+	expected := IndexStats{Tiles: 8, Hashed: 2, ByLoc: map[string]int{"L": 3, "G": 1, "A": 1, "U": 1, "S": 1, "Z": 1},
+		BySource: map[string]int{"/photos": 2, "/other": 1, "Google Photos": 1, "/x.zip": 1,
+			"https://example.com": 1, "s3://bucket": 1, "Z": 1}}
+	if !reflect.DeepEqual(stats, expected) {
+		t.Errorf("Stats returned %+v but %+v was expected", stats, expected)
+	}
+}
+
+//TestColorHistogram verifies that tiles are counted in the cell of their average color.
+func TestColorHistogram(t *testing.T) {
+	index := gomosaic.MosaicTiles{{AvgR: 0, AvgG: 0, AvgB: 0}, {AvgR: 65535, AvgG: 65535, AvgB: 65535},
+		{AvgR: 65535, AvgG: 0, AvgB: 0}, {AvgR: 40000, AvgG: 100, AvgB: 200}}
+	counts := ColorHistogram(index, 2)
+	if !reflect.DeepEqual(counts, []int{1, 0, 0, 0, 2, 0, 0, 1}) {
+		t.Errorf("ColorHistogram returned %v", counts)
+	}
+	if color := binColor(4, 2); color != "#c04040" {
+		t.Errorf("binColor returned %s", color)
+	}
+
+	var out bytes.Buffer
+	WriteStats(&out, index, 2)
+	if !strings.Contains(out.String(), "4 tiles (0 with a perceptual hash)") ||
+		!strings.Contains(out.String(), "#c04040        2 "+strings.Repeat("#", histogramWidth)) {
+		t.Errorf("WriteStats wrote\n%s", out.String())
+	}
+}
+
+//TestFindCoverageGaps verifies that only segments without a tile within the threshold are reported.
+func TestFindCoverageGaps(t *testing.T) {
+	index := gomosaic.MosaicTiles{{Filename: "red", AvgR: 200 * 257}, {Filename: "gray", AvgR: 100 * 257,
+		AvgG: 100 * 257, AvgB: 100 * 257}}
+	segments := []gomosaic.ImageSegment{{RVal: 190 * 257}, {RVal: 100 * 257, GVal: 100 * 257, BVal: 130 * 257},
+		{BVal: 250 * 257}, {BVal: 240 * 257}}
+	gaps := FindCoverageGaps(segments, index, 20)
+	if len(gaps) != 3 || gaps[0].Segment != segments[1] || gaps[0].Closest.Filename != "gray" ||
+		gaps[0].Distance < 29.9 || gaps[0].Distance > 30.1 {
+		t.Fatalf("FindCoverageGaps returned %+v", gaps)
+	}
+
+	var out bytes.Buffer
+	WriteCoverageGaps(&out, gaps, len(segments), 20, 4)
+	expected := "3 of 4 segments have no tile within 20\nColors that need more tiles:\n" +
+		"  #2020e0      2 segments (furthest is #0000fa, 206.2 from gray)\n"
+	if !strings.HasPrefix(out.String(), expected) {
+		t.Errorf("WriteCoverageGaps wrote\n%s\nbut expected it to start with\n%s", out.String(), expected)
+	}
+}
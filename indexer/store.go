@@ -0,0 +1,148 @@
+package indexer
+
+import (
+	"github.com/cfagiani/gomosaic"
+	"sort"
+)
+
+//IndexStore holds the tiles of an index. Tiles are identified by their location code and file name, so upserting a
+//tile replaces the one stored with the same location code and file name.
+type IndexStore interface {
+	//Upsert adds the tiles to the store, replacing any stored with the same location code and file name
+	Upsert(tiles ...gomosaic.MosaicTile) error
+	//Delete removes the tiles with the same location code and file name as those passed in
+	Delete(tiles ...gomosaic.MosaicTile) error
+	//Iterate calls fn for each tile in order of file name, stopping at the first error fn returns
+	Iterate(fn func(tile gomosaic.MosaicTile) error) error
+	//Close saves any pending changes and releases the store
+	Close() error
+}
+
+//OpenStore opens the store for the index at dest (see GetIndexFileName), creating it if it doesn't exist. Indexes whose
+//file is a database, or that don't exist yet and whose name ends in BoltExtension, are kept in an embedded database and
+//others in a flat file.
+func OpenStore(dest string) (IndexStore, error) {
+	filename, _ := GetIndexFileName(dest)
+	if IsBoltIndex(filename) {
+		return OpenBoltStore(filename)
+	}
+	return OpenFileStore(filename)
+}
+
+//ReadStore returns all the tiles of the store in order of file name.
+func ReadStore(store IndexStore) (gomosaic.MosaicTiles, error) {
+	index := make(gomosaic.MosaicTiles, 0, 100)
+	err := store.Iterate(func(tile gomosaic.MosaicTile) error {
+		index = append(index, tile)
+		return nil
+	})
+	return index, err
+}
+
+//UpdateStore compares the index the store held with the new one and only upserts the tiles that were added or changed
+//and deletes those that are gone. It returns the number of tiles upserted and deleted.
+func UpdateStore(store IndexStore, oldIndex gomosaic.MosaicTiles, newIndex gomosaic.MosaicTiles) (int, int, error) {
+	old := make(map[string]gomosaic.MosaicTile, len(oldIndex))
+	for _, tile := range oldIndex {
+		old[tileKey(tile)] = tile
+	}
+	var changed gomosaic.MosaicTiles
+	for _, tile := range newIndex {
+		if tile.Filename == "" {
+			continue
+		}
+		key := tileKey(tile)
+		if existing, found := old[key]; !found || existing != tile {
+			changed = append(changed, tile)
+		}
+		delete(old, key)
+	}
+	var deleted gomosaic.MosaicTiles
+	for _, tile := range old {
+		deleted = append(deleted, tile)
+	}
+	if err := store.Upsert(changed...); err != nil {
+		return 0, 0, err
+	}
+	if err := store.Delete(deleted...); err != nil {
+		return len(changed), 0, err
+	}
+	return len(changed), len(deleted), nil
+}
+
+//tileKey identifies a tile in a store. The file name comes first so that keys sort in order of file name.
+func tileKey(tile gomosaic.MosaicTile) string {
+	return tile.Filename + "\x00" + tile.Loc
+}
+
+//FileStore keeps an index in a flat file, in the text or binary format. Since a flat file can't be updated in place, the
+//tiles are held in memory and the whole file is rewritten by Close if any of them changed.
+type FileStore struct {
+	filename string
+	binary   bool
+	tiles    map[string]gomosaic.MosaicTile
+	changed  bool
+}
+
+//OpenFileStore reads the index in filename, if it exists, returning an error if it can't be read rather than replacing
+//it. The file keeps its format when it is rewritten; new files are written in the text format, even if they are empty.
+func OpenFileStore(filename string) (*FileStore, error) {
+	_, exists := GetIndexFileName(filename)
+	index, err := LoadIndex(filename)
+	if err != nil {
+		return nil, err
+	}
+	s := &FileStore{filename: filename, binary: IsBinaryIndex(filename), tiles: make(map[string]gomosaic.MosaicTile),
+		changed: !exists}
+	for _, tile := range index {
+		s.tiles[tileKey(tile)] = tile
+	}
+	return s, nil
+}
+
+func (s *FileStore) Upsert(tiles ...gomosaic.MosaicTile) error {
+	for _, tile := range tiles {
+		s.tiles[tileKey(tile)] = tile
+	}
+	s.changed = s.changed || len(tiles) > 0
+	return nil
+}
+
+func (s *FileStore) Delete(tiles ...gomosaic.MosaicTile) error {
+	for _, tile := range tiles {
+		key := tileKey(tile)
+		if _, found := s.tiles[key]; found {
+			delete(s.tiles, key)
+			s.changed = true
+		}
+	}
+	return nil
+}
+
+func (s *FileStore) Iterate(fn func(tile gomosaic.MosaicTile) error) error {
+	keys := make([]string, 0, len(s.tiles))
+	for key := range s.tiles {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := fn(s.tiles[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//Close rewrites the file if any tiles were upserted or deleted or if it didn't exist.
+func (s *FileStore) Close() error {
+	if !s.changed {
+		return nil
+	}
+	index, _ := ReadStore(s)
+	s.changed = false
+	if s.binary {
+		return WriteBinaryIndex(s.filename, index)
+	}
+	return WriteIndex(s.filename, index)
+}
+
@@ -0,0 +1,187 @@
+package indexer
+
+import (
+	"encoding/binary"
+	"github.com/cfagiani/gomosaic"
+	bolt "go.etcd.io/bbolt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+//TestIndexStore runs the same updates against each kind of store, checking the tiles before and after reopening it.
+func TestIndexStore(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "indexstore")
+	defer os.RemoveAll(dir)
+	a := gomosaic.MosaicTile{Loc: "L", Filename: "/photos/a.jpg", AvgR: 1, AvgG: 2, AvgB: 3}
+	b := gomosaic.MosaicTile{Loc: "G", Filename: "b", AvgR: 65535, Hash: 0xff, HasHash: true}
+	bLocal := gomosaic.MosaicTile{Loc: "L", Filename: "b", AvgG: 7}
+	s3Tile := gomosaic.MosaicTile{Loc: "S", Filename: "s3://bucket/c.jpg#etag", AvgB: 9}
+	changedA := gomosaic.MosaicTile{Loc: "L", Filename: "/photos/a.jpg", AvgR: 4, AvgG: 5, AvgB: 6, Hash: 1,
+		HasHash: true}
+
+	cases := []struct {
+		name string
+	}{
+		{"index.dat"},
+		{"index.db"},
+	}
+	for _, c := range cases {
+		filename := filepath.Join(dir, c.name)
+		store, err := OpenStore(filename)
+		if err != nil {
+			t.Fatalf("OpenStore(%q) returned %v", c.name, err)
+		}
+		store.Upsert(s3Tile, b, a, bLocal)
+		store.Upsert(changedA)
+		store.Delete(s3Tile, gomosaic.MosaicTile{Loc: "L", Filename: "not there"})
+		expected := gomosaic.MosaicTiles{changedA, b, bLocal}
+		if tiles, err := ReadStore(store); err != nil || !reflect.DeepEqual(tiles, expected) {
+			t.Errorf("%s store holds %v, %v but %v was expected", c.name, tiles, err, expected)
+		}
+		if err := store.Close(); err != nil {
+			t.Errorf("Closing the %s store returned %v", c.name, err)
+		}
+		if tiles := ReadIndex(filename); !reflect.DeepEqual(tiles, expected) {
+			t.Errorf("%s was read as %v after closing the store but %v was expected", c.name, tiles, expected)
+		}
+
+		store, _ = OpenStore(filename)
+		oldIndex, _ := ReadStore(store)
+		newIndex := gomosaic.MosaicTiles{a, bLocal, s3Tile}
+		upserted, deleted, err := UpdateStore(store, oldIndex, newIndex)
+		if err != nil || upserted != 2 || deleted != 1 {
+			t.Errorf("UpdateStore of the %s store returned %d, %d, %v but 2, 1 were expected", c.name, upserted,
+				deleted, err)
+		}
+		store.Close()
+		if tiles := ReadIndex(filename); !reflect.DeepEqual(tiles, newIndex) {
+			t.Errorf("%s was read as %v after updating the store but %v was expected", c.name, tiles, newIndex)
+		}
+	}
+}
+
+//TestIndexToDatabase verifies that the indexer can keep its index in a database and that re-indexing leaves it
+//unchanged.
+func TestIndexToDatabase(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "indexstore")
+	defer os.RemoveAll(dir)
+	dest := filepath.Join(dir, "index"+BoltExtension)
+	for i := 0; i < 2; i++ {
+		if err := Index("../testdata/testconfig.json", dest); err != nil {
+			t.Fatalf("Could not index files %v", err)
+		}
+		if index := ReadIndex(dest); len(index) != 4 {
+			t.Errorf("Expected to index 4 files but found %d", len(index))
+		}
+	}
+}
+
+//TestLoadIndexErrors verifies that an index that exists but can't be read is reported by LoadIndex rather than read
+//as empty, and that a file store won't replace it.
+func TestLoadIndexErrors(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "loadindex")
+	defer os.RemoveAll(dir)
+	locked := filepath.Join(dir, "locked.db")
+	store, err := OpenBoltStore(locked)
+	if err != nil {
+		t.Fatalf("Could not open database: %v", err)
+	}
+	defer store.Close()
+	corrupt := filepath.Join(dir, "corrupt.idx")
+	ioutil.WriteFile(corrupt, []byte(binaryMagic+"truncated"), 0644)
+
+	if _, err := LoadIndex(locked); err == nil || !strings.Contains(err.Error(), "in use by another process") {
+		t.Errorf("Expected an error for a database that is open elsewhere but got %v", err)
+	}
+	if _, err := LoadIndex(corrupt); err == nil {
+		t.Error("Expected an error for a corrupt binary index")
+	}
+	if _, err := OpenFileStore(corrupt); err == nil {
+		t.Error("OpenFileStore should not open an index it can't read")
+	}
+	if index, err := LoadIndex(filepath.Join(dir, "missing.idx")); err != nil || len(index) != 0 {
+		t.Errorf("LoadIndex returned %v, %v for a missing index", index, err)
+	}
+}
+
+//TestIsBoltIndex verifies that databases are recognized by their content, so that a flat index whose name ends in
+//BoltExtension is still read as one.
+func TestIsBoltIndex(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "boltindex")
+	defer os.RemoveAll(dir)
+	tiles := gomosaic.MosaicTiles{{Loc: "L", Filename: "a.jpg", AvgR: 1, AvgG: 2, AvgB: 3, Hash: 4, HasHash: true}}
+	database := filepath.Join(dir, "index.db")
+	store, err := OpenStore(database)
+	if err != nil {
+		t.Fatalf("Could not open database: %v", err)
+	}
+	store.Upsert(tiles...)
+	store.Close()
+	text := filepath.Join(dir, "text.db")
+	WriteIndex(text, tiles)
+
+	cases := []struct {
+		filename string
+		expected bool
+	}{
+		{database, true},
+		{text, false},
+		{filepath.Join(dir, "new.db"), true},
+		{filepath.Join(dir, "new.dat"), false},
+	}
+	for _, c := range cases {
+		if IsBoltIndex(c.filename) != c.expected {
+			t.Errorf("IsBoltIndex(%s) should have returned %v", filepath.Base(c.filename), c.expected)
+		}
+	}
+	for _, filename := range []string{database, text} {
+		if index, err := LoadIndex(filename); err != nil || !reflect.DeepEqual(index, tiles) {
+			t.Errorf("LoadIndex(%s) returned %v, %v", filepath.Base(filename), index, err)
+		}
+	}
+	if store, err := OpenStore(text); err != nil {
+		t.Errorf("Could not open %s: %v", text, err)
+	} else {
+		if _, ok := store.(*FileStore); !ok {
+			t.Errorf("A flat index named %s was opened as a database", text)
+		}
+		store.Close()
+	}
+}
+
+//TestBoltStoreV1Values verifies that tiles stored before the hash flag was added are still read, a zero hash meaning
+//that the tile wasn't hashed.
+func TestBoltStoreV1Values(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "boltindex")
+	defer os.RemoveAll(dir)
+	store, err := OpenBoltStore(filepath.Join(dir, "index.db"))
+	if err != nil {
+		t.Fatalf("Could not open database: %v", err)
+	}
+	defer store.Close()
+	tiles := gomosaic.MosaicTiles{{Loc: "L", Filename: "a.jpg", AvgR: 1, Hash: 0xff, HasHash: true},
+		{Loc: "L", Filename: "b.jpg", AvgG: 2}}
+	store.db.Update(func(tx *bolt.Tx) error {
+		bucket, _ := tx.CreateBucketIfNotExists([]byte(tilesBucket))
+		for _, tile := range tiles {
+			value := make([]byte, boltValueSizeV1)
+			binary.LittleEndian.PutUint32(value, tile.AvgR)
+			binary.LittleEndian.PutUint32(value[4:], tile.AvgG)
+			binary.LittleEndian.PutUint64(value[12:], tile.Hash)
+			bucket.Put([]byte(tileKey(tile)), value)
+		}
+		return nil
+	})
+	var read gomosaic.MosaicTiles
+	err = store.Iterate(func(tile gomosaic.MosaicTile) error {
+		read = append(read, tile)
+		return nil
+	})
+	if err != nil || !reflect.DeepEqual(read, tiles) {
+		t.Errorf("Iterate returned %v, %v for values without the hash flag but %v was expected", read, err, tiles)
+	}
+}
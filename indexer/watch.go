@@ -0,0 +1,255 @@
+package indexer
+
+import (
+	"fmt"
+	"github.com/cfagiani/gomosaic/indexer/processor"
+	"github.com/cfagiani/gomosaic/util"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	//how long Watch waits after the last change before updating the index by default
+	DefaultDebounce = 2 * time.Second
+	//how often Watch scans directories for changes by default when it has to
+	DefaultPollInterval = 10 * time.Second
+)
+
+//WatchOptions controls how Watch notices changes and how often it updates the index.
+type WatchOptions struct {
+	//how long to wait after the last change before updating the index, so a burst of changes is written once
+	Debounce time.Duration
+	//how often the directories are scanned for changes when the operating system can't report them
+	PollInterval time.Duration
+	//scan the directories for changes even where the operating system can report them
+	Poll bool
+	//how often the initial indexing saves its progress (see IndexWithCheckpoints)
+	CheckpointInterval time.Duration
+}
+
+//watcher reports changes to the files in a set of directories.
+type watcher interface {
+	//Changes delivers the paths of the files and directories that were created, written, moved or removed
+	Changes() <-chan string
+	Close() error
+}
+
+//watchedSource is a local source whose directories are watched.
+type watchedSource struct {
+	processor     processor.PathProcessor
+	dir           string
+	recurse       bool
+	includeHidden bool
+}
+
+//Watch indexes the sources like Index and then keeps the index up to date as the files in the local sources change
+//until stop is closed: new and changed images are analyzed and deleted ones are dropped. Changes are gathered until
+//none have happened for the Debounce period and then only the tiles that changed are written to the index. Other kinds
+//of sources are only indexed when Watch starts.
+func Watch(configFile string, dest string, options WatchOptions, stop <-chan struct{}) error {
+	config, err := util.ReadConfig(configFile)
+	if err != nil {
+		return fmt.Errorf("could not read configuration file: %v", err)
+	}
+	var sources []watchedSource
+	for _, source := range config.Sources {
+		if source.Kind != processor.LocalKind {
+			log.Printf("Not watching %s source %s for changes\n", source.Kind, source.Path)
+			continue
+		}
+		p, err := processor.New(source, config)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		pp, ok := p.(processor.PathProcessor)
+		if !ok {
+			log.Printf("Not watching %s source %s for changes\n", source.Kind, source.Path)
+			continue
+		}
+		sources = append(sources, watchedSource{processor: pp, dir: source.Path,
+			recurse:       source.Options == processor.RecurseOption || (source.Local != nil && source.Local.Recurse),
+			includeHidden: source.Local == nil || source.Local.IncludeHidden})
+	}
+
+	if options.Debounce <= 0 {
+		options.Debounce = DefaultDebounce
+	}
+	if options.PollInterval <= 0 {
+		options.PollInterval = DefaultPollInterval
+	}
+	//start watching before indexing so that no change made while indexing is missed
+	w := newWatcher(sources, options)
+	defer w.Close()
+	if err := IndexWithCheckpoints(configFile, dest, options.CheckpointInterval); err != nil {
+		return err
+	}
+	log.Printf("Watching %d local sources for changes\n", len(sources))
+
+	changed := make(map[string]bool)
+	timer := time.NewTimer(options.Debounce)
+	timer.Stop()
+	for {
+		select {
+		case path := <-w.Changes():
+			changed[path] = true
+			timer.Reset(options.Debounce)
+		case <-timer.C:
+			if err := updateLocalSources(dest, sources, changed); err != nil {
+				log.Printf("Could not update the index: %v\n", err)
+				//keep the changes so they are applied by the next update
+				continue
+			}
+			changed = make(map[string]bool)
+		case <-stop:
+			if len(changed) == 0 {
+				return nil
+			}
+			return updateLocalSources(dest, sources, changed)
+		}
+	}
+}
+
+//updateLocalSources brings the tiles at or below the paths in changed up to date (see processor.PathProcessor), without
+//indexing the rest of the sources, and writes the tiles that changed to the index. The tiles of other sources are kept.
+func updateLocalSources(dest string, sources []watchedSource, changed map[string]bool) error {
+	store, err := OpenStore(dest)
+	if err != nil {
+		return err
+	}
+	current, err := ReadStore(store)
+	if err != nil {
+		store.Close()
+		return err
+	}
+	paths := make([]string, 0, len(changed))
+	for path := range changed {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	newIndex := current
+	for _, source := range sources {
+		newIndex = source.processor.ProcessPaths(newIndex, paths)
+	}
+	sort.Sort(newIndex)
+	upserted, deleted, err := UpdateStore(store, current, newIndex)
+	if closeErr := store.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		log.Printf("Updated index after %d changes: %d tiles added or changed, %d removed\n", len(changed), upserted,
+			deleted)
+	}
+	return err
+}
+
+//watchedDirs calls visit with each directory of the source that is watched: its path and, if it recurses, the
+//subdirectories that aren't hidden (unless it includes hidden files). Links to directories aren't followed.
+func watchedDirs(dir string, recurse bool, includeHidden bool, visit func(dir string)) {
+	visit(dir)
+	if !recurse {
+		return
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, file := range files {
+		if file.IsDir() && (includeHidden || !strings.HasPrefix(file.Name(), ".")) {
+			watchedDirs(util.GetPath(dir, file.Name()), recurse, includeHidden, visit)
+		}
+	}
+}
+
+//fileState is what the poller compares to find out if a file changed.
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+//poller finds changes by listing the watched directories every interval and comparing the size and modification time
+//of their files with those of the previous scan.
+type poller struct {
+	sources  []watchedSource
+	interval time.Duration
+	changes  chan string
+	done     chan struct{}
+	files    map[string]fileState
+}
+
+func newPoller(sources []watchedSource, interval time.Duration) *poller {
+	p := &poller{sources: sources, interval: interval, changes: make(chan string, 1024), done: make(chan struct{})}
+	p.files = p.scan()
+	go p.run()
+	return p
+}
+
+func (p *poller) run() {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			files := p.scan()
+			for _, path := range changedFiles(p.files, files) {
+				select {
+				case p.changes <- path:
+				case <-p.done:
+					return
+				}
+			}
+			p.files = files
+		case <-p.done:
+			return
+		}
+	}
+}
+
+//scan returns the state of every file in the watched directories.
+func (p *poller) scan() map[string]fileState {
+	files := make(map[string]fileState)
+	for _, source := range p.sources {
+		watchedDirs(source.dir, source.recurse, source.includeHidden, func(dir string) {
+			entries, err := ioutil.ReadDir(dir)
+			if err != nil {
+				return
+			}
+			for _, entry := range entries {
+				if !entry.IsDir() {
+					files[util.GetPath(dir, entry.Name())] = fileState{size: entry.Size(), modTime: entry.ModTime()}
+				}
+			}
+		})
+	}
+	return files
+}
+
+//changedFiles returns the paths of the files that were added, changed or removed between two scans, in sorted order.
+func changedFiles(before map[string]fileState, after map[string]fileState) []string {
+	var paths []string
+	for path, state := range after {
+		if previous, found := before[path]; !found || previous.size != state.size || !previous.modTime.Equal(state.modTime) {
+			paths = append(paths, path)
+		}
+	}
+	for path := range before {
+		if _, found := after[path]; !found {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func (p *poller) Changes() <-chan string {
+	return p.changes
+}
+
+func (p *poller) Close() error {
+	close(p.done)
+	return nil
+}
+
@@ -0,0 +1,158 @@
+//go:build linux
+// +build linux
+
+package indexer
+
+import (
+	"github.com/cfagiani/gomosaic/util"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+//events reported by inotify that can change the tiles of a directory
+const inotifyMask = syscall.IN_CREATE | syscall.IN_CLOSE_WRITE | syscall.IN_DELETE | syscall.IN_MOVED_FROM |
+	syscall.IN_MOVED_TO | syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF
+
+//newWatcher returns a watcher that uses inotify, unless polling was asked for or inotify can't be used (e.g. because
+//the limit on the number of watches was reached), in which case the directories are scanned every PollInterval.
+func newWatcher(sources []watchedSource, options WatchOptions) watcher {
+	if !options.Poll {
+		w, err := newInotifyWatcher(sources)
+		if err == nil {
+			return w
+		}
+		log.Printf("Cannot watch for changes (%v); scanning every %v instead\n", err, options.PollInterval)
+	}
+	return newPoller(sources, options.PollInterval)
+}
+
+//inotifyWatcher reports the changes inotify sees in the watched directories. Directories created or moved into them
+//are watched as well when their source recurses.
+type inotifyWatcher struct {
+	file    *os.File
+	fd      int
+	changes chan string
+	done    chan struct{}
+	mu      sync.Mutex
+	//directory and source of each watch descriptor
+	dirs    map[int32]string
+	sources map[int32]watchedSource
+}
+
+func newInotifyWatcher(sources []watchedSource) (*inotifyWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	//a non-blocking file uses the runtime's poller, so closing it stops a pending read
+	w := &inotifyWatcher{file: os.NewFile(uintptr(fd), "inotify"), fd: fd, changes: make(chan string, 1024),
+		done: make(chan struct{}), dirs: make(map[int32]string), sources: make(map[int32]watchedSource)}
+	for _, source := range sources {
+		if err := w.addTree(source.dir, source); err != nil {
+			w.file.Close()
+			return nil, err
+		}
+	}
+	go w.run()
+	return w, nil
+}
+
+//addTree watches dir and, if the source recurses, its subdirectories.
+func (w *inotifyWatcher) addTree(dir string, source watchedSource) error {
+	var err error
+	watchedDirs(dir, source.recurse, source.includeHidden, func(dir string) {
+		wd, addErr := syscall.InotifyAddWatch(w.fd, dir, inotifyMask)
+		if addErr != nil {
+			//a directory that can't be read is reported by the indexer; running out of watches is fatal
+			if addErr == syscall.ENOSPC && err == nil {
+				err = os.NewSyscallError("inotify_add_watch", addErr)
+			}
+			return
+		}
+		w.mu.Lock()
+		w.dirs[int32(wd)], w.sources[int32(wd)] = dir, source
+		w.mu.Unlock()
+	})
+	return err
+}
+
+func (w *inotifyWatcher) run() {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			return
+		}
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameBytes := buf[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(event.Len)]
+			offset += syscall.SizeofInotifyEvent + int(event.Len)
+			if !w.handle(event, strings.TrimRight(string(nameBytes), "\x00")) {
+				return
+			}
+		}
+	}
+}
+
+//handle reports the path changed by an event and watches new directories. It returns false once the watcher is closed.
+func (w *inotifyWatcher) handle(event *syscall.InotifyEvent, name string) bool {
+	w.mu.Lock()
+	dir, found := w.dirs[event.Wd]
+	source := w.sources[event.Wd]
+	if event.Mask&syscall.IN_IGNORED != 0 {
+		delete(w.dirs, event.Wd)
+		delete(w.sources, event.Wd)
+	}
+	w.mu.Unlock()
+	path := dir
+	switch {
+	case event.Mask&syscall.IN_Q_OVERFLOW != 0:
+		//events were lost so report every directory, which at least picks up new and deleted files
+		log.Println("Too many changes to watch; re-indexing every watched directory")
+		w.mu.Lock()
+		var dirs []string
+		for _, dir := range w.dirs {
+			dirs = append(dirs, dir)
+		}
+		w.mu.Unlock()
+		for _, dir := range dirs {
+			if !w.send(dir) {
+				return false
+			}
+		}
+		return true
+	case !found:
+		return true
+	case len(name) > 0:
+		path = util.GetPath(dir, name)
+	}
+	if event.Mask&syscall.IN_ISDIR != 0 && event.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 && source.recurse &&
+		(source.includeHidden || !strings.HasPrefix(name, ".")) {
+		if err := w.addTree(path, source); err != nil {
+			log.Printf("Cannot watch %s: %v\n", path, err)
+		}
+	}
+	return w.send(path)
+}
+
+func (w *inotifyWatcher) send(path string) bool {
+	select {
+	case w.changes <- path:
+		return true
+	case <-w.done:
+		return false
+	}
+}
+
+func (w *inotifyWatcher) Changes() <-chan string {
+	return w.changes
+}
+
+func (w *inotifyWatcher) Close() error {
+	close(w.done)
+	return w.file.Close()
+}
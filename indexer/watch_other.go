@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package indexer
+
+//newWatcher returns a watcher that scans the directories of the sources for changes every PollInterval.
+func newWatcher(sources []watchedSource, options WatchOptions) watcher {
+	return newPoller(sources, options.PollInterval)
+}
@@ -0,0 +1,153 @@
+package indexer
+
+import (
+	"fmt"
+	"github.com/cfagiani/gomosaic"
+	"github.com/cfagiani/gomosaic/indexer/processor"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+//writeShadedPNG writes an 8x8 png of a single shade of gray to path.
+func writeShadedPNG(t *testing.T, path string, shade uint8) {
+	os.MkdirAll(filepath.Dir(path), 0755)
+	img := image.NewGray(image.Rect(0, 0, 8, 8))
+	for i := range img.Pix {
+		img.Pix[i] = shade
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Could not create %s: %v", path, err)
+	}
+	defer f.Close()
+	png.Encode(f, img)
+}
+
+//waitFor checks condition until it is true, failing the test if that takes too long.
+func waitFor(t *testing.T, description string, condition func() bool) {
+	for deadline := time.Now().Add(10 * time.Second); !condition(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", description)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+//TestChangedFiles verifies that added, changed and removed files are found by comparing two scans.
+func TestChangedFiles(t *testing.T) {
+	now := time.Now()
+	before := map[string]fileState{"a": {1, now}, "b": {2, now}, "c": {3, now}}
+	cases := []struct {
+		after    map[string]fileState
+		expected []string
+	}{
+		{map[string]fileState{"a": {1, now}, "b": {2, now}, "c": {3, now}}, nil},
+		{map[string]fileState{"a": {1, now}, "b": {5, now}, "c": {3, now.Add(time.Second)}, "d": {4, now}},
+			[]string{"b", "c", "d"}},
+		{map[string]fileState{"b": {2, now}}, []string{"a", "c"}},
+	}
+	for _, c := range cases {
+		if changed := changedFiles(before, c.after); !reflect.DeepEqual(changed, c.expected) {
+			t.Errorf("changedFiles returned %v but %v was expected", changed, c.expected)
+		}
+	}
+}
+
+//TestUpdateLocalSources verifies that changed files are analyzed again, deleted ones are dropped and the tiles of
+//other sources are kept, without indexing the files that didn't change.
+func TestUpdateLocalSources(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "watch")
+	defer os.RemoveAll(dir)
+	photos := filepath.Join(dir, "photos")
+	writeShadedPNG(t, filepath.Join(photos, "same.png"), 10)
+	writeShadedPNG(t, filepath.Join(photos, "changed.png"), 20)
+	writeShadedPNG(t, filepath.Join(photos, "unchanged.png"), 30)
+	source := gomosaic.ImageSource{Kind: processor.LocalKind, Path: photos}
+	p, _ := processor.New(source, gomosaic.Config{})
+	sources := []watchedSource{{processor: p.(processor.PathProcessor), dir: photos}}
+
+	dest := filepath.Join(dir, "index.dat")
+	same := gomosaic.MosaicTile{Loc: "L", Filename: filepath.Join(photos, "same.png"), AvgR: 1, AvgG: 1, AvgB: 1, Hash: 1,
+		HasHash: true}
+	changed := gomosaic.MosaicTile{Loc: "L", Filename: filepath.Join(photos, "changed.png"), AvgR: 1, AvgG: 1, AvgB: 1}
+	deleted := gomosaic.MosaicTile{Loc: "L", Filename: filepath.Join(photos, "deleted.png"), AvgR: 1, AvgG: 1, AvgB: 1}
+	other := gomosaic.MosaicTile{Loc: "L", Filename: filepath.Join(dir, "other", "x.png"), AvgR: 1, AvgG: 1, AvgB: 1}
+	google := gomosaic.MosaicTile{Loc: "G", Filename: "id", AvgR: 1, AvgG: 1, AvgB: 1}
+	WriteIndex(dest, gomosaic.MosaicTiles{same, changed, deleted, other, google})
+
+	err := updateLocalSources(dest, sources, map[string]bool{changed.Filename: true, deleted.Filename: true})
+	if err != nil {
+		t.Fatalf("updateLocalSources returned %v", err)
+	}
+	index := ReadIndex(dest)
+	byName := make(map[string]gomosaic.MosaicTile)
+	for _, tile := range index {
+		byName[tile.Filename] = tile
+	}
+	if len(index) != 4 || byName[same.Filename] != same || byName[other.Filename] != other || byName["id"] != google {
+		t.Errorf("Expected unchanged tiles to be kept, deleted.png to be dropped and unchanged.png to be left out but got %v",
+			index)
+	}
+	if tile := byName[changed.Filename]; tile.AvgR != 20*257 {
+		t.Errorf("Expected changed.png to be analyzed again but got %+v", tile)
+	}
+}
+
+//TestWatch verifies that the index follows the files added, changed and removed while watching, with inotify (where
+//available) and by polling.
+func TestWatch(t *testing.T) {
+	cases := []struct {
+		poll bool
+	}{
+		{false},
+		{true},
+	}
+	for _, c := range cases {
+		dir, _ := ioutil.TempDir("", "watch")
+		defer os.RemoveAll(dir)
+		photos := filepath.Join(dir, "photos")
+		writeShadedPNG(t, filepath.Join(photos, "a.png"), 10)
+		writeShadedPNG(t, filepath.Join(photos, "b.png"), 20)
+		config := filepath.Join(dir, "config.json")
+		ioutil.WriteFile(config, []byte(fmt.Sprintf(`{"sources": [{"kind": "local", "path": %q, "options": "recurse"}]}`,
+			photos)), 0644)
+		dest := filepath.Join(dir, "index.dat")
+
+		stop := make(chan struct{})
+		done := make(chan error)
+		go func() {
+			done <- Watch(config, dest, WatchOptions{Debounce: 50 * time.Millisecond, PollInterval: 50 * time.Millisecond,
+				Poll: c.poll}, stop)
+		}()
+		shades := func() map[string]uint32 {
+			shades := make(map[string]uint32)
+			for _, tile := range ReadIndex(dest) {
+				rel, _ := filepath.Rel(photos, tile.Filename)
+				shades[rel] = tile.AvgR / 257
+			}
+			return shades
+		}
+		waitFor(t, "the initial index", func() bool { return len(shades()) == 2 })
+
+		writeShadedPNG(t, filepath.Join(photos, "new", "c.png"), 30)
+		os.Remove(filepath.Join(photos, "a.png"))
+		//make sure the change is seen even if the size and modification time don't change much
+		time.Sleep(20 * time.Millisecond)
+		writeShadedPNG(t, filepath.Join(photos, "b.png"), 40)
+		expected := map[string]uint32{"b.png": 40, filepath.Join("new", "c.png"): 30}
+		waitFor(t, fmt.Sprintf("the changes to be indexed (poll %v)", c.poll), func() bool {
+			return reflect.DeepEqual(shades(), expected)
+		})
+
+		close(stop)
+		if err := <-done; err != nil {
+			t.Errorf("Watch returned %v", err)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package mosaicimages
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+)
+
+//ArchiveEntrySeparator separates the path of an archive from the name of an entry in the file names of tiles read from
+//archives, e.g. photos/2010.zip!/summer/beach.jpg.
+const ArchiveEntrySeparator = "!/"
+
+//ArchiveEntryName returns the name used to reference an entry of an archive.
+func ArchiveEntryName(archivePath string, entry string) string {
+	return archivePath + ArchiveEntrySeparator + entry
+}
+
+//SplitArchiveEntryName splits a name returned by ArchiveEntryName into the path of the archive and the name of the
+//entry.
+func SplitArchiveEntryName(name string) (string, string, error) {
+	i := strings.Index(name, ArchiveEntrySeparator)
+	if i < 0 {
+		return "", "", fmt.Errorf("%s does not reference an archive entry", name)
+	}
+	return name[:i], name[i+len(ArchiveEntrySeparator):], nil
+}
+
+//IsArchive checks if the file is an archive that can be read, based on its extension: .zip, .tar, .tar.gz or .tgz.
+func IsArchive(path string) bool {
+	lower := strings.ToLower(path)
+	for _, ext := range []string{".zip", ".tar", ".tar.gz", ".tgz"} {
+		if strings.HasSuffix(lower, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+//WalkArchive calls fn with the name and content of each supported image in the archive, in the order they are stored.
+//The reader is only valid until fn returns. If fn returns an error, the walk stops and the error is returned.
+func WalkArchive(path string, fn func(entry string, r io.Reader) error) error {
+	return walkArchive(path, func(entry string, r io.Reader) (bool, error) {
+		if img, ok := supportedImage(r); ok {
+			return false, fn(entry, img)
+		}
+		return false, nil
+	})
+}
+
+//OpenArchiveEntry returns the content of the entry referenced by a name returned by ArchiveEntryName. Entries of tar
+//archives are found by reading the archive from its start, so reading many entries from a large tar archive is slow;
+//ArchiveCache reads them in a single pass instead.
+func OpenArchiveEntry(name string) (io.ReadCloser, error) {
+	archivePath, entry, err := SplitArchiveEntryName(name)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasSuffix(strings.ToLower(archivePath), ".zip") {
+		zr, err := zip.OpenReader(archivePath)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range zr.File {
+			if f.Name == entry {
+				rc, err := f.Open()
+				if err != nil {
+					zr.Close()
+					return nil, err
+				}
+				return multiCloser{rc, []io.Closer{rc, zr}}, nil
+			}
+		}
+		zr.Close()
+		return nil, fmt.Errorf("%s not found in %s", entry, archivePath)
+	}
+
+	var found io.ReadCloser
+	err = walkArchive(archivePath, func(name string, r io.Reader) (bool, error) {
+		if name != entry {
+			return false, nil
+		}
+		//entries are read sequentially so the content has to be copied out before the archive is closed
+		var data bytes.Buffer
+		if _, err := io.Copy(&data, r); err != nil {
+			return true, err
+		}
+		found = ioutil.NopCloser(&data)
+		return true, nil
+	})
+	if err == nil && found == nil {
+		err = fmt.Errorf("%s not found in %s", entry, archivePath)
+	}
+	return found, err
+}
+
+//isTarArchive checks if the file is an archive whose entries can only be read sequentially.
+func isTarArchive(path string) bool {
+	return IsArchive(path) && !strings.HasSuffix(strings.ToLower(path), ".zip")
+}
+
+//ArchiveCache holds tiles read ahead from tar archives (see TileLoader.Prefetch). Since the entries of a tar archive
+//can only be found by reading it from its start, all the tiles needed from an archive are read, and resized, in a
+//single pass rather than one pass per tile. Each tile is kept until it is loaded. An ArchiveCache is safe for
+//concurrent use.
+type ArchiveCache struct {
+	mu     sync.Mutex
+	images map[string]image.Image
+}
+
+//NewArchiveCache returns an empty cache.
+func NewArchiveCache() *ArchiveCache {
+	return &ArchiveCache{images: make(map[string]image.Image)}
+}
+
+//Read reads the entries of the tar archive at path whose names are passed in and keeps them resized to size x size.
+//The archive is read until all the entries have been found. Entries that can't be decoded are left out and are read
+//again, one by one, when they are loaded.
+func (c *ArchiveCache) Read(path string, entries []string, size uint) error {
+	wanted := make(map[string]bool)
+	for _, entry := range entries {
+		wanted[entry] = true
+	}
+	if len(wanted) == 0 {
+		return nil
+	}
+	return walkArchive(path, func(entry string, r io.Reader) (bool, error) {
+		if !wanted[entry] {
+			return false, nil
+		}
+		delete(wanted, entry)
+		if img, err := ResizeReader(r, size, size); err == nil {
+			c.mu.Lock()
+			c.images[archiveCacheKey(ArchiveEntryName(path, entry), size)] = img
+			c.mu.Unlock()
+		}
+		return len(wanted) == 0, nil
+	})
+}
+
+//take returns the image of the entry that was read ahead at the size passed in, removing it from the cache.
+func (c *ArchiveCache) take(name string, size uint) (image.Image, bool) {
+	key := archiveCacheKey(name, size)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	img, ok := c.images[key]
+	delete(c.images, key)
+	return img, ok
+}
+
+func archiveCacheKey(name string, size uint) string {
+	return fmt.Sprintf("%s\x00%d", name, size)
+}
+
+//walkArchive calls fn for every regular file in the archive until fn returns true or an error.
+func walkArchive(path string, fn func(entry string, r io.Reader) (bool, error)) error {
+	lower := strings.ToLower(path)
+	if strings.HasSuffix(lower, ".zip") {
+		zr, err := zip.OpenReader(path)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		for _, f := range zr.File {
+			if f.FileInfo().IsDir() {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				return fmt.Errorf("could not read %s in %s: %v", f.Name, path, err)
+			}
+			stop, err := fn(f.Name, rc)
+			rc.Close()
+			if stop || err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	var r io.Reader = file
+	if strings.HasSuffix(lower, ".gz") || strings.HasSuffix(lower, ".tgz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return fmt.Errorf("could not read %s: %v", path, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("could not read %s: %v", path, err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if stop, err := fn(header.Name, tr); stop || err != nil {
+			return err
+		}
+	}
+}
+
+//supportedImage checks the first bytes of the content for one of the supported image formats, returning a reader that
+//still includes those bytes.
+func supportedImage(r io.Reader) (io.Reader, bool) {
+	br := bufio.NewReader(r)
+	header, _ := br.Peek(36)
+	return br, isSupportedHeader(header)
+}
+
+//multiCloser closes all of its closers when it is closed.
+type multiCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (m multiCloser) Close() error {
+	var err error
+	for _, c := range m.closers {
+		if e := c.Close(); err == nil {
+			err = e
+		}
+	}
+	return err
+}
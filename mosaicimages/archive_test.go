@@ -0,0 +1,154 @@
+package mosaicimages
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"github.com/cfagiani/gomosaic"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//writeTestArchive creates an archive (zip, tar or tar.gz depending on its name) holding the entries passed in, whose
+//values are the paths of the files to copy into the archive. Entries ending in / are written as directories.
+func writeTestArchive(t *testing.T, path string, entries [][2]string) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Could not create archive: %v", err)
+	}
+	defer f.Close()
+	if strings.HasSuffix(path, ".zip") {
+		zw := zip.NewWriter(f)
+		for _, e := range entries {
+			w, _ := zw.Create(e[0])
+			if !strings.HasSuffix(e[0], "/") {
+				data, _ := ioutil.ReadFile(e[1])
+				w.Write(data)
+			}
+		}
+		zw.Close()
+		return
+	}
+	var w io.Writer = f
+	if strings.HasSuffix(path, ".gz") || strings.HasSuffix(path, ".tgz") {
+		gz := gzip.NewWriter(f)
+		defer gz.Close()
+		w = gz
+	}
+	tw := tar.NewWriter(w)
+	defer tw.Close()
+	for _, e := range entries {
+		if strings.HasSuffix(e[0], "/") {
+			tw.WriteHeader(&tar.Header{Name: e[0], Typeflag: tar.TypeDir, Mode: 0755})
+			continue
+		}
+		data, _ := ioutil.ReadFile(e[1])
+		tw.WriteHeader(&tar.Header{Name: e[0], Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(data))})
+		tw.Write(data)
+	}
+}
+
+//TestArchives verifies that the images in each kind of archive are found and can be read back individually.
+func TestArchives(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "archives")
+	defer os.RemoveAll(dir)
+	entries := [][2]string{{"photos/", ""}, {"photos/a.png", "../testdata/img1.png"},
+		{"notes.txt", "archive_test.go"}, {"photos/b.jpg", "../testdata/img3.jpg"}}
+	for _, name := range []string{"set.zip", "set.tar", "set.tar.gz", "set.tgz"} {
+		path := filepath.Join(dir, name)
+		writeTestArchive(t, path, entries)
+		if !IsArchive(path) {
+			t.Errorf("%s was not recognized as an archive", name)
+		}
+		var found []string
+		err := WalkArchive(path, func(entry string, r io.Reader) error {
+			found = append(found, entry)
+			_, err := AnalyzeReader(r)
+			return err
+		})
+		if err != nil || strings.Join(found, ",") != "photos/a.png,photos/b.jpg" {
+			t.Errorf("WalkArchive(%s) found %v, %v", name, found, err)
+		}
+		for _, entry := range found {
+			r, err := OpenArchiveEntry(ArchiveEntryName(path, entry))
+			if err != nil {
+				t.Errorf("Could not open %s in %s: %v", entry, name, err)
+				continue
+			}
+			img, err := ResizeReader(r, 8, 8)
+			r.Close()
+			if err != nil || img.Bounds().Dx() != 8 {
+				t.Errorf("Could not decode %s in %s: %v", entry, name, err)
+			}
+		}
+		if _, err := OpenArchiveEntry(ArchiveEntryName(path, "photos/missing.png")); err == nil {
+			t.Errorf("Opening a missing entry of %s should have failed", name)
+		}
+	}
+	if IsArchive("photo.png") {
+		t.Errorf("photo.png should not be an archive")
+	}
+	if _, err := OpenArchiveEntry("../testdata/img1.png"); err == nil {
+		t.Errorf("Opening a name without an entry should have failed")
+	}
+	if err := WalkArchive(filepath.Join(dir, "missing.zip"), nil); err == nil {
+		t.Errorf("Walking a missing archive should have failed")
+	}
+}
+
+//TestLoadArchiveTile verifies that tiles indexed from archives are loaded and cached using the archive's fingerprint.
+func TestLoadArchiveTile(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "archives")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "set.zip")
+	writeTestArchive(t, path, [][2]string{{"a.png", "../testdata/img1.png"}})
+	tile := gomosaic.MosaicTile{Loc: "A", Filename: ArchiveEntryName(path, "a.png")}
+	img, err := TileLoader{}.Load(tile, 10)
+	if err != nil || img.Bounds().Dx() != 10 {
+		t.Errorf("Could not load archive tile: %v", err)
+	}
+	if fingerprint, err := tileFingerprint(tile); err != nil || len(fingerprint) == 0 {
+		t.Errorf("Archive tile fingerprint was %q, %v", fingerprint, err)
+	}
+}
+
+//TestPrefetchArchiveTiles verifies that the tiles of tar archives are read ahead, so they can be loaded once the
+//archive is gone, while those of zip archives are still read when loaded.
+func TestPrefetchArchiveTiles(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "archives")
+	defer os.RemoveAll(dir)
+	entries := [][2]string{{"a.png", "../testdata/img1.png"}, {"notes.txt", "archive_test.go"},
+		{"b.jpg", "../testdata/img3.jpg"}}
+	var tiles []gomosaic.MosaicTile
+	for _, name := range []string{"set.zip", "set.tar", "set.tgz"} {
+		path := filepath.Join(dir, name)
+		writeTestArchive(t, path, entries)
+		for _, entry := range []string{"a.png", "b.jpg"} {
+			tiles = append(tiles, gomosaic.MosaicTile{Loc: "A", Filename: ArchiveEntryName(path, entry)})
+		}
+	}
+	loader := TileLoader{Archives: NewArchiveCache()}
+	if err := loader.Prefetch(tiles, 10); err != nil {
+		t.Fatalf("Prefetch returned %v", err)
+	}
+	for _, name := range []string{"set.zip", "set.tar", "set.tgz"} {
+		os.Remove(filepath.Join(dir, name))
+	}
+	for _, tile := range tiles {
+		img, err := loader.Load(tile, 10)
+		if strings.Contains(tile.Filename, ".zip") {
+			if err == nil {
+				t.Errorf("%s should have been read when loaded", tile.Filename)
+			}
+		} else if err != nil || img.Bounds().Dx() != 10 {
+			t.Errorf("%s wasn't read ahead: %v", tile.Filename, err)
+		}
+	}
+	if len(loader.Archives.images) != 0 {
+		t.Errorf("%d tiles read ahead were kept after being loaded", len(loader.Archives.images))
+	}
+}
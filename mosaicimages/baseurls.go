@@ -0,0 +1,117 @@
+package mosaicimages
+
+import (
+	"fmt"
+	"github.com/cfagiani/gomosaic/util"
+	"google.golang.org/api/photoslibrary/v1"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	//maximum number of media items that can be requested in one batchGet call
+	maxBatchSize = 50
+	//base urls expire after 60 minutes; they are refreshed a bit earlier so downloads don't race the expiry
+	baseURLLifetime = 55 * time.Minute
+)
+
+//BaseURLCache resolves Google Photos media item IDs to base urls. Urls are looked up in batches and cached until they
+//expire. A BaseURLCache is safe for concurrent use.
+type BaseURLCache struct {
+	service *photoslibrary.Service
+	retry   util.RetryPolicy
+	mu      sync.Mutex
+	urls    map[string]baseURL
+	//now returns the current time; tests may replace it
+	now func() time.Time
+}
+
+type baseURL struct {
+	url     string
+	fetched time.Time
+}
+
+//NewBaseURLCache returns an empty cache that looks up media items using the service passed in.
+func NewBaseURLCache(service *photoslibrary.Service, retry util.RetryPolicy) *BaseURLCache {
+	return &BaseURLCache{service: service, retry: retry, urls: make(map[string]baseURL), now: time.Now}
+}
+
+//Resolve looks up the base urls of all the media items that aren't cached (or whose url has expired) using as few
+//batchGet calls as possible. Items that can't be found are logged and skipped; looking them up through URL will
+//report the error.
+func (c *BaseURLCache) Resolve(ids []string) error {
+	var missing []string
+	c.mu.Lock()
+	requested := make(map[string]bool)
+	for _, id := range ids {
+		if _, ok := c.lookup(id); !ok && !requested[id] {
+			requested[id] = true
+			missing = append(missing, id)
+		}
+	}
+	c.mu.Unlock()
+
+	for start := 0; start < len(missing); start += maxBatchSize {
+		end := start + maxBatchSize
+		if end > len(missing) {
+			end = len(missing)
+		}
+		var resp *photoslibrary.BatchGetMediaItemsResponse
+		err := c.retry.Do("getting media items", func() (err error) {
+			resp, err = c.service.MediaItems.BatchGet().MediaItemIds(missing[start:end]...).Do()
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		fetched := c.now()
+		c.mu.Lock()
+		for i, result := range resp.MediaItemResults {
+			if result.MediaItem == nil {
+				status := "no media item returned"
+				if result.Status != nil {
+					status = result.Status.Message
+				}
+				if i < end-start {
+					log.Printf("Could not get media item %s: %s", missing[start+i], status)
+				}
+				continue
+			}
+			c.urls[result.MediaItem.Id] = baseURL{result.MediaItem.BaseUrl, fetched}
+		}
+		c.mu.Unlock()
+	}
+	return nil
+}
+
+//URL returns the base url of the media item, fetching it on its own if it wasn't resolved beforehand.
+func (c *BaseURLCache) URL(id string) (string, error) {
+	c.mu.Lock()
+	url, ok := c.lookup(id)
+	c.mu.Unlock()
+	if ok {
+		return url, nil
+	}
+	var item *photoslibrary.MediaItem
+	err := c.retry.Do("getting media item", func() (err error) {
+		item, err = c.service.MediaItems.Get(id).Do()
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("could not get mediaItem from service: %v", err)
+	}
+	c.mu.Lock()
+	c.urls[id] = baseURL{item.BaseUrl, c.now()}
+	c.mu.Unlock()
+	return item.BaseUrl, nil
+}
+
+//lookup returns the cached url of the media item if it hasn't expired. The caller must hold the lock.
+func (c *BaseURLCache) lookup(id string) (string, bool) {
+	entry, ok := c.urls[id]
+	if !ok || c.now().Sub(entry.fetched) >= baseURLLifetime {
+		return "", false
+	}
+	return entry.url, true
+}
@@ -0,0 +1,146 @@
+package mosaicimages
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/cfagiani/gomosaic"
+	"github.com/cfagiani/gomosaic/util"
+	"google.golang.org/api/photoslibrary/v1"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+//fakeMediaServer serves the media item lookups of the Google Photos api and the images behind the base urls it
+//returns. Only IDs starting with "i" exist.
+type fakeMediaServer struct {
+	*httptest.Server
+	mu sync.Mutex
+	//number of IDs requested by each batchGet call
+	batches []int
+	gets    int
+}
+
+func newFakeMediaServer() *fakeMediaServer {
+	f := &fakeMediaServer{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/mediaItems:batchGet", func(w http.ResponseWriter, r *http.Request) {
+		ids := r.URL.Query()["mediaItemIds"]
+		f.mu.Lock()
+		f.batches = append(f.batches, len(ids))
+		f.mu.Unlock()
+		var resp photoslibrary.BatchGetMediaItemsResponse
+		for _, id := range ids {
+			if strings.HasPrefix(id, "i") {
+				resp.MediaItemResults = append(resp.MediaItemResults,
+					&photoslibrary.MediaItemResult{MediaItem: &photoslibrary.MediaItem{Id: id, BaseUrl: f.URL + "/img/" + id}})
+			} else {
+				resp.MediaItemResults = append(resp.MediaItemResults,
+					&photoslibrary.MediaItemResult{Status: &photoslibrary.Status{Code: 5, Message: "not found"}})
+			}
+		}
+		json.NewEncoder(w).Encode(resp)
+	})
+	mux.HandleFunc("/v1/mediaItems/", func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/v1/mediaItems/")
+		f.mu.Lock()
+		f.gets++
+		f.mu.Unlock()
+		if !strings.HasPrefix(id, "i") {
+			http.Error(w, `{"error": {"message": "not found"}}`, http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(photoslibrary.MediaItem{Id: id, BaseUrl: f.URL + "/img/" + id})
+	})
+	mux.HandleFunc("/img/", func(w http.ResponseWriter, r *http.Request) {
+		png.Encode(w, image.NewRGBA(image.Rect(0, 0, 8, 8)))
+	})
+	f.Server = httptest.NewServer(mux)
+	return f
+}
+
+//service returns a client that talks to the fake server.
+func (f *fakeMediaServer) service(t *testing.T) *photoslibrary.Service {
+	service, err := photoslibrary.New(f.Client())
+	if err != nil {
+		t.Fatalf("Could not create service: %v", err)
+	}
+	service.BasePath = f.URL + "/"
+	return service
+}
+
+//TestBaseURLCache verifies that base urls are looked up in batches of at most 50 and refreshed once they expire.
+func TestBaseURLCache(t *testing.T) {
+	server := newFakeMediaServer()
+	defer server.Close()
+	cache := NewBaseURLCache(server.service(t), util.RetryPolicy{MaxAttempts: 1})
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	var ids []string
+	for i := 0; i < 120; i++ {
+		ids = append(ids, fmt.Sprintf("i%d", i))
+	}
+	//duplicates and missing items are tolerated
+	ids = append(ids, "i1", "missing")
+	if err := cache.Resolve(ids); err != nil {
+		t.Fatalf("Resolve returned an error: %v", err)
+	}
+	if fmt.Sprint(server.batches) != "[50 50 21]" {
+		t.Errorf("Expected batches of [50 50 21] but got %v", server.batches)
+	}
+	if url, err := cache.URL("i7"); err != nil || url != server.URL+"/img/i7" || server.gets != 0 {
+		t.Errorf("URL returned %s, %v after %d gets", url, err, server.gets)
+	}
+
+	//resolving again doesn't call the api until the urls expire
+	cache.Resolve(ids[:10])
+	if len(server.batches) != 3 {
+		t.Errorf("Cached urls were looked up again")
+	}
+	now = now.Add(time.Hour)
+	cache.Resolve(ids[:10])
+	if len(server.batches) != 4 || server.batches[3] != 10 {
+		t.Errorf("Expired urls were not looked up again: %v", server.batches)
+	}
+
+	//items that weren't resolved are looked up on their own
+	if url, err := cache.URL("i500"); err != nil || url != server.URL+"/img/i500" || server.gets != 1 {
+		t.Errorf("URL returned %s, %v after %d gets", url, err, server.gets)
+	}
+	if _, err := cache.URL("missing"); err == nil {
+		t.Errorf("URL should have returned an error for a missing item")
+	}
+}
+
+//TestPrefetch verifies that Google tiles are downloaded without a lookup per tile once they are prefetched.
+func TestPrefetch(t *testing.T) {
+	server := newFakeMediaServer()
+	defer server.Close()
+	service := server.service(t)
+	loader := TileLoader{PhotoService: service, BaseURLs: NewBaseURLCache(service, util.RetryPolicy{MaxAttempts: 1})}
+	tiles := []gomosaic.MosaicTile{{Loc: "G", Filename: "i1"}, {Loc: "L", Filename: "../testdata/img1.png"},
+		{Loc: "G", Filename: "i2"}}
+	if err := loader.Prefetch(tiles, 8); err != nil {
+		t.Fatalf("Prefetch returned an error: %v", err)
+	}
+	var wg sync.WaitGroup
+	for _, tile := range tiles {
+		wg.Add(1)
+		go func(tile gomosaic.MosaicTile) {
+			defer wg.Done()
+			if img, err := loader.Load(tile, 8); err != nil || img.Bounds().Dx() != 8 {
+				t.Errorf("Could not load %v: %v", tile, err)
+			}
+		}(tile)
+	}
+	wg.Wait()
+	if len(server.batches) != 1 || server.batches[0] != 2 || server.gets != 0 {
+		t.Errorf("Expected a single batch of 2 and no gets but got %v and %d", server.batches, server.gets)
+	}
+}
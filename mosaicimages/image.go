@@ -2,8 +2,8 @@ package mosaicimages
 
 import (
 	"errors"
-	"fmt"
 	"github.com/cfagiani/gomosaic"
+	"github.com/cfagiani/gomosaic/tilecache"
 	"github.com/cfagiani/gomosaic/util"
 	"github.com/nfnt/resize"
 	"github.com/utahta/go-openuri"
@@ -13,6 +13,7 @@ import (
 	_ "image/gif"
 	"image/jpeg"
 	_ "image/png"
+	"io"
 	"log"
 	"os"
 	"strings"
@@ -33,11 +34,17 @@ func IsSupportedImage(dirName string, file os.FileInfo) bool {
 	if !util.CheckError(err, "error opening file", false) {
 		var header = make([]byte, 36)
 		f.Read(header) //we don't care about the error here since we'll just skip it
-		headerStr := string(header)
-		for magic := range magicNumbers {
-			if strings.HasPrefix(headerStr, magic) {
-				return true
-			}
+		return isSupportedHeader(header)
+	}
+	return false
+}
+
+//isSupportedHeader checks if the first bytes of a file match one of the formats in our magicNumber table.
+func isSupportedHeader(header []byte) bool {
+	headerStr := string(header)
+	for magic := range magicNumbers {
+		if strings.HasPrefix(headerStr, magic) {
+			return true
 		}
 	}
 	return false
@@ -52,8 +59,14 @@ func ResizeImage(inputFile string, height uint, width uint) (image.Image, error)
 		return nil, err
 	}
 
+	return ResizeReader(file, height, width)
+}
+
+//ResizeReader decodes the image read from r and resizes it to the specified dimensions. Use height or width of 0 to
+//preserve aspect ratio.
+func ResizeReader(r io.Reader, height uint, width uint) (image.Image, error) {
 	// decode jpeg into image.Image
-	img, _, err := image.Decode(file)
+	img, _, err := image.Decode(r)
 	if util.CheckError(err, "Could not decode image", false) {
 		return nil, err
 	}
@@ -70,34 +83,42 @@ func CreateDrawableImage(tileSize int, gridSize int, sourceWidth int, sourceHeig
 }
 
 //WriteTileToImage will resize the image referenced by the tile passed in into the dimensions specified and write it into the
-//Image (img) being constructed.
+//Image (img) being constructed. If cache is not nil, resized tiles are read from and stored in it.
 func WriteTileToImage(img draw.Image, tile gomosaic.MosaicTile, tileSize uint,
-	startX int, startY int, photoService *photoslibrary.Service) {
-	var tileImage image.Image
-	var imgErr error
-	switch tile.Loc {
-	case "L":
-		tileImage, imgErr = ResizeImage(tile.Filename, tileSize, tileSize)
-		if imgErr != nil {
-			log.Fatalf("Could not resize image: %v", imgErr)
-			os.Exit(1)
-		}
-	case "G":
-		item, err := photoService.MediaItems.Get(tile.Filename).Do()
-		if err != nil {
-			log.Fatalf("Could not get mediaItem from service: %v\n", err)
-			os.Exit(1)
-		}
-		file, _ := openuri.Open(item.BaseUrl + fmt.Sprintf("=w%d-h%d-c", tileSize, tileSize))
-		tileImage, _, err = image.Decode(file)
-		util.CheckError(err, "Could not process image", true)
-	default:
-		log.Fatalf("Unrecongnized tile location %v", tile.Loc)
+	startX int, startY int, photoService *photoslibrary.Service, cache *tilecache.Cache) {
+	WriteMaskedTileToImage(img, tile, tileSize, startX, startY, nil, photoService, cache)
+}
+
+//WriteMaskedTileToImage behaves like WriteTileToImage but only writes the pixels of the tile that are opaque in the
+//mask (as returned by LayoutMask). If the mask is nil, the entire tile is written.
+func WriteMaskedTileToImage(img draw.Image, tile gomosaic.MosaicTile, tileSize uint,
+	startX int, startY int, mask image.Image, photoService *photoslibrary.Service, cache *tilecache.Cache) {
+	tileImage, err := LoadTileImage(tile, tileSize, photoService, cache)
+	if err != nil {
+		log.Fatalf("Could not load tile image: %v", err)
+		os.Exit(1)
+	}
+	DrawTile(img, tileImage, startX, startY, mask)
+}
+
+//DrawTile writes an already resized tile image into the Image (img) being constructed with its top-left corner at
+//startX,startY. Only the pixels that are opaque in the mask are written; if the mask is nil, the entire tile is written.
+func DrawTile(img draw.Image, tileImage image.Image, startX int, startY int, mask image.Image) {
+	size := tileImage.Bounds().Size()
+	destRec := image.Rect(startX, startY, startX+size.X, startY+size.Y)
+	srcPoint := image.Point{tileImage.Bounds().Min.X, tileImage.Bounds().Min.Y}
+	if mask == nil {
+		draw.FloydSteinberg.Draw(img, destRec.Bounds(), tileImage, srcPoint)
+	} else {
+		draw.DrawMask(img, destRec, tileImage, srcPoint, mask, mask.Bounds().Min, draw.Over)
 	}
+}
 
-	destRec := image.Rect(startX, startY, startX+int(tileSize), startY+int(tileSize))
-	draw.FloydSteinberg.Draw(img, destRec.Bounds(), tileImage,
-		image.Point{tileImage.Bounds().Min.X, tileImage.Bounds().Min.Y})
+//LoadTileImage returns the image referenced by the tile resized to tileSize x tileSize. If cache is not nil, it is
+//checked before the original image is read and the resized image is added to it.
+func LoadTileImage(tile gomosaic.MosaicTile, tileSize uint, photoService *photoslibrary.Service,
+	cache *tilecache.Cache) (image.Image, error) {
+	return TileLoader{PhotoService: photoService, Cache: cache}.Load(tile, tileSize)
 }
 
 //WriteImageToFile saves the in-memory representation of an image to the filesystem at the path specified.
@@ -111,23 +132,46 @@ func WriteImageToFile(img image.Image, outputFile string) error {
 	return jpeg.Encode(out, img, nil)
 }
 
-//SegmentImage divides a source image up into square segments of the specified size and returns an array of ImageSegments. If the
-//image cannot be processed, an error is returned.
-func SegmentImage(sourceImage string, segmentSize int) ([]gomosaic.ImageSegment, int, int, error) {
+//GetImageDimensions returns the width and height of the image stored at the path passed in without decoding the whole
+//image.
+func GetImageDimensions(sourceImage string) (int, int, error) {
+	file, err := os.Open(sourceImage)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+	config, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return 0, 0, err
+	}
+	return config.Width, config.Height, nil
+}
+
+//SegmentImage divides a source image up into cells of the specified size arranged according to the layout (one of
+//SquareLayout, BrickLayout or HexLayout) and returns an array of ImageSegments. The bounds of each segment are the
+//(unclipped) bounding box of the cell and the color values are the average of the pixels inside the cell's shape. If
+//the image cannot be processed, an error is returned.
+func SegmentImage(sourceImage string, segmentSize int, layout string) ([]gomosaic.ImageSegment, int, int, error) {
+	if err := ValidateLayout(layout); err != nil {
+		return make([]gomosaic.ImageSegment, 0, 0), 0, 0, err
+	}
 	file, err := os.Open(sourceImage)
 	if !util.CheckError(err, "Could not process image", false) {
 		defer file.Close()
 		img, _, err := image.Decode(file)
 		util.CheckError(err, "Could not process image", true)
 		bounds := img.Bounds()
-		//TODO: need to handle non-square images better
-		var segments = make([]gomosaic.ImageSegment, 0, 100)
-		for y := bounds.Min.Y; y < bounds.Max.Y; y += segmentSize {
-			for x := bounds.Min.X; x < bounds.Max.X; x += segmentSize {
-				segments = append(segments, analyzeImageSegment(img, x, y, x+segmentSize, y+segmentSize))
-			}
+		width, height := bounds.Dx(), bounds.Dy()
+		mask := LayoutMask(layout, segmentSize)
+		cells := LayoutCells(layout, width, height, segmentSize)
+		var segments = make([]gomosaic.ImageSegment, 0, len(cells))
+		for _, cell := range cells {
+			segment := analyzeMaskedSegment(img, cell.Add(bounds.Min), mask)
+			//report coordinates relative to the image origin so they can be projected onto the destination
+			segment.XMin, segment.YMin, segment.XMax, segment.YMax = cell.Min.X, cell.Min.Y, cell.Max.X, cell.Max.Y
+			segments = append(segments, segment)
 		}
-		return segments, bounds.Max.X - bounds.Min.X, bounds.Max.Y - bounds.Min.Y, nil
+		return segments, width, height, nil
 	} else {
 		return make([]gomosaic.ImageSegment, 0, 0), 0, 0, errors.New("Could not analyze image")
 	}
@@ -153,21 +197,69 @@ func AnalyzeImage(filename string) (gomosaic.ImageSegment, error) {
 	}
 }
 
+//AnalyzeReader decodes the image read from r and calculates its average color.
+func AnalyzeReader(r io.Reader) (gomosaic.ImageSegment, error) {
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return gomosaic.ImageSegment{}, err
+	}
+	bounds := img.Bounds()
+	return analyzeImageSegment(img, bounds.Min.X, bounds.Min.Y, bounds.Max.X, bounds.Max.Y), nil
+}
+
+//AnalyzeTile decodes the image read from r and returns a tile with its average color and perceptual hash. The caller
+//sets the location and name of the tile.
+func AnalyzeTile(r io.Reader) (gomosaic.MosaicTile, error) {
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return gomosaic.MosaicTile{}, err
+	}
+	bounds := img.Bounds()
+	segment := analyzeImageSegment(img, bounds.Min.X, bounds.Min.Y, bounds.Max.X, bounds.Max.Y)
+	return gomosaic.MosaicTile{AvgR: segment.RVal, AvgG: segment.GVal, AvgB: segment.BVal, Hash: PerceptualHash(img),
+		HasHash: true}, nil
+}
+
+//AnalyzeTileImage is like AnalyzeTile for the image at filename, which may be a path or a url.
+func AnalyzeTileImage(filename string) (gomosaic.MosaicTile, error) {
+	file, err := openuri.Open(filename)
+	if err != nil {
+		return gomosaic.MosaicTile{}, err
+	}
+	defer file.Close()
+	return AnalyzeTile(file)
+}
+
 //analyzeImageSegment calculates the average pixel values for a segment of an image, returning an ImageSegment struct
 //with the result.
 func analyzeImageSegment(img image.Image, xMin int, yMin int, xMax int, yMax int) gomosaic.ImageSegment {
-	var rTotal, gTotal, bTotal, pixelCount uint32 = 0, 0, 0, 0
+	return analyzeMaskedSegment(img, image.Rect(xMin, yMin, xMax, yMax), nil)
+}
+
+//analyzeMaskedSegment calculates the average pixel values of the part of the cell that lies inside the image and is
+//opaque in the mask (which is positioned at the cell's top-left corner). A nil mask includes every pixel in the cell.
+func analyzeMaskedSegment(img image.Image, cell image.Rectangle, mask image.Image) gomosaic.ImageSegment {
+	var rTotal, gTotal, bTotal, pixelCount uint64 = 0, 0, 0, 0
 
-	for y := yMin; y < yMax; y++ {
-		for x := xMin; x < xMax; x++ {
+	area := cell.Intersect(img.Bounds())
+	for y := area.Min.Y; y < area.Max.Y; y++ {
+		for x := area.Min.X; x < area.Max.X; x++ {
+			if mask != nil {
+				if _, _, _, a := mask.At(x-cell.Min.X, y-cell.Min.Y).RGBA(); a == 0 {
+					continue
+				}
+			}
 			r, g, b, _ := img.At(x, y).RGBA()
 			// A color's RGBA method returns values in the range [0, 65535].
-			rTotal += r
-			gTotal += g
-			bTotal += b
+			rTotal += uint64(r)
+			gTotal += uint64(g)
+			bTotal += uint64(b)
 			pixelCount++
 		}
 	}
-	return gomosaic.ImageSegment{xMin, yMin, xMax, yMax,
-		rTotal / pixelCount, gTotal / pixelCount, bTotal / pixelCount}
+	if pixelCount == 0 {
+		return gomosaic.ImageSegment{cell.Min.X, cell.Min.Y, cell.Max.X, cell.Max.Y, 0, 0, 0}
+	}
+	return gomosaic.ImageSegment{cell.Min.X, cell.Min.Y, cell.Max.X, cell.Max.Y,
+		uint32(rTotal / pixelCount), uint32(gTotal / pixelCount), uint32(bTotal / pixelCount)}
 }
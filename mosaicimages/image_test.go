@@ -1,8 +1,15 @@
 package mosaicimages
 
 import (
+	"github.com/cfagiani/gomosaic"
+	"github.com/cfagiani/gomosaic/tilecache"
+	"image"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
+	"time"
 )
 
 //TestIsSupportedImage will ensure that the IsSupportedImage method returns true for supported files and
@@ -93,3 +100,139 @@ func TestCreateDrawableImage(t *testing.T) {
 		}
 	}
 }
+
+//TestLayoutCells verifies that LayoutCells returns enough cells to cover the image and that CellIndex can recover
+//the row and column of each cell.
+func TestLayoutCells(t *testing.T) {
+	cases := []struct {
+		layout   string
+		w        int
+		h        int
+		size     int
+		expected int
+	}{
+		{SquareLayout, 100, 100, 50, 4},
+		{SquareLayout, 101, 100, 50, 6},
+		{BrickLayout, 100, 100, 50, 5},
+		{HexLayout, 100, 100, 40, 12},
+		{HexLayout, 10, 10, 1, 100},
+	}
+	for _, c := range cases {
+		cells := LayoutCells(c.layout, c.w, c.h, c.size)
+		if len(cells) != c.expected {
+			t.Errorf("LayoutCells returned %d cells for %v but %d were expected", len(cells), c, c.expected)
+		}
+		for _, cell := range cells {
+			row, col := CellIndex(c.layout, cell.Min.X, cell.Min.Y, c.size)
+			if CellOrigin(c.layout, row, col, c.size) != cell.Min {
+				t.Errorf("CellIndex returned %d,%d for cell at %v in layout %s", row, col, cell.Min, c.layout)
+			}
+		}
+	}
+}
+
+//TestLayoutMask verifies that the hex mask tessellates: every pixel of an area covered by hex cells belongs to a cell,
+//and to exactly one cell when the cell size is even.
+func TestLayoutMask(t *testing.T) {
+	if LayoutMask(SquareLayout, 10) != nil || LayoutMask(BrickLayout, 10) != nil {
+		t.Error("LayoutMask should return nil for layouts whose cells are squares")
+	}
+	for _, size := range []int{1, 8, 21, 40} {
+		mask := LayoutMask(HexLayout, size)
+		cover := make(map[image.Point]int)
+		for _, cell := range LayoutCells(HexLayout, 100, 100, size) {
+			for y := 0; y < size; y++ {
+				for x := 0; x < size; x++ {
+					if _, _, _, a := mask.At(x, y).RGBA(); a != 0 {
+						cover[image.Point{cell.Min.X + x, cell.Min.Y + y}]++
+					}
+				}
+			}
+		}
+		for y := 0; y < 100; y++ {
+			for x := 0; x < 100; x++ {
+				if n := cover[image.Point{x, y}]; n == 0 || (size%2 == 0 && n != 1) {
+					t.Fatalf("Pixel %d,%d was covered by %d hex cells of size %d", x, y, n, size)
+				}
+			}
+		}
+	}
+}
+
+//TestSegmentImage verifies that SegmentImage covers the image for each layout and rejects unknown layouts.
+func TestSegmentImage(t *testing.T) {
+	cases := []struct {
+		layout      string
+		expectError bool
+	}{
+		{SquareLayout, false},
+		{BrickLayout, false},
+		{HexLayout, false},
+		{"", false},
+		{"triangle", true},
+	}
+	for _, c := range cases {
+		segments, w, h, err := SegmentImage("../testdata/img1.png", 10, c.layout)
+		if err != nil && !c.expectError {
+			t.Errorf("SegmentImage returned an unexpected error for layout %q: %v", c.layout, err)
+		} else if err == nil {
+			if c.expectError {
+				t.Errorf("SegmentImage should have returned an error for layout %q", c.layout)
+			} else if len(segments) != len(LayoutCells(c.layout, w, h, 10)) {
+				t.Errorf("SegmentImage returned %d segments for layout %q", len(segments), c.layout)
+			}
+		}
+	}
+}
+
+//TestLoadTileImageCache verifies that resized tiles are stored in the cache and that a changed file is not served
+//from the cache.
+func TestLoadTileImageCache(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "tiles")
+	defer os.RemoveAll(dir)
+	cache, err := tilecache.Open(dir, 0)
+	if err != nil {
+		t.Fatalf("Could not open cache: %v", err)
+	}
+	tile := gomosaic.MosaicTile{Loc: "L", Filename: "../testdata/img1.png"}
+	img, err := LoadTileImage(tile, 12, nil, cache)
+	if err != nil || img.Bounds().Dx() != 12 {
+		t.Fatalf("LoadTileImage did not return a resized image: %v", err)
+	}
+	if cache.Size() == 0 {
+		t.Fatalf("LoadTileImage did not store the tile in the cache")
+	}
+	fingerprint, _ := tileFingerprint(tile)
+	if _, ok := cache.Get(tilecache.Key(tile.Loc, tile.Filename, fingerprint, 12)); !ok {
+		t.Errorf("Cached tile was not stored under the expected key")
+	}
+	if _, err := LoadTileImage(gomosaic.MosaicTile{Loc: "L", Filename: "../testdata/notThere"}, 12, nil, cache); err == nil {
+		t.Errorf("LoadTileImage should have returned an error for a missing file")
+	}
+}
+
+//TestLoadURLTile verifies that tiles indexed from urls are downloaded and resized, and that their cached copies expire.
+func TestLoadURLTile(t *testing.T) {
+	server := httptest.NewServer(http.FileServer(http.Dir("../testdata")))
+	defer server.Close()
+	loader := TileLoader{}
+	img, err := loader.Load(gomosaic.MosaicTile{Loc: "U", Filename: server.URL + "/img1.png"}, 10)
+	if err != nil || img.Bounds().Dx() != 10 || img.Bounds().Dy() != 10 {
+		t.Errorf("Could not load url tile: %v", err)
+	}
+	for _, name := range []string{server.URL + "/notThere.png", "file:///etc/passwd"} {
+		if _, err := loader.Load(gomosaic.MosaicTile{Loc: "U", Filename: name}, 10); err == nil {
+			t.Errorf("Loading %s should have failed", name)
+		}
+	}
+
+	start := time.Unix(0, 0).Add(100 * urlCachePeriod)
+	if urlFingerprint(start) != urlFingerprint(start.Add(urlCachePeriod-time.Second)) ||
+		urlFingerprint(start) == urlFingerprint(start.Add(urlCachePeriod)) {
+		t.Errorf("Url tiles should be cached for one period")
+	}
+	fingerprint, _ := tileFingerprint(gomosaic.MosaicTile{Loc: "U", Filename: server.URL + "/img1.png"})
+	if len(fingerprint) == 0 {
+		t.Errorf("Url tiles were fingerprinted with %q", fingerprint)
+	}
+}
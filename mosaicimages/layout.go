@@ -0,0 +1,140 @@
+package mosaicimages
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+const (
+	//SquareLayout arranges cells in a regular grid of squares.
+	SquareLayout = "square"
+	//BrickLayout arranges square cells in rows where every other row is offset by half a cell, like a brick wall.
+	BrickLayout = "brick"
+	//HexLayout arranges hexagonal cells in offset rows so that they tessellate the image.
+	HexLayout = "hex"
+)
+
+//ValidateLayout returns an error if the layout passed in is not one of the supported layouts. An empty layout is
+//treated as SquareLayout.
+func ValidateLayout(layout string) error {
+	switch layout {
+	case "", SquareLayout, BrickLayout, HexLayout:
+		return nil
+	}
+	return fmt.Errorf("unrecognized layout %q", layout)
+}
+
+//CellOrigin returns the top-left corner of the bounding box of the cell at the row and column passed in for a layout
+//whose cells are size pixels wide and tall. Cells may start before the image origin (for instance, the first cell of
+//an offset row), in which case they are clipped when the image is segmented or drawn.
+func CellOrigin(layout string, row int, col int, size int) image.Point {
+	switch layout {
+	case BrickLayout:
+		return image.Point{X: col*size - (row%2)*(size/2), Y: row * size}
+	case HexLayout:
+		pitch := hexRowPitch(size)
+		return image.Point{X: col*size - (row%2)*(size/2), Y: row*pitch - (size - pitch)}
+	default:
+		return image.Point{X: col * size, Y: row * size}
+	}
+}
+
+//CellIndex is the inverse of CellOrigin: given the top-left corner of a cell's bounding box, it returns the row and
+//column of that cell.
+func CellIndex(layout string, x int, y int, size int) (int, int) {
+	switch layout {
+	case BrickLayout:
+		row := y / size
+		return row, (x + (row%2)*(size/2)) / size
+	case HexLayout:
+		pitch := hexRowPitch(size)
+		row := (y + size - pitch) / pitch
+		return row, (x + (row%2)*(size/2)) / size
+	default:
+		return y / size, x / size
+	}
+}
+
+//LayoutCells returns the bounding boxes of all the cells needed to cover an image of the given width and height. The
+//rectangles are not clipped to the image bounds.
+func LayoutCells(layout string, width int, height int, size int) []image.Rectangle {
+	var cells = make([]image.Rectangle, 0, 100)
+	for row := 0; CellOrigin(layout, row, 0, size).Y < height; row++ {
+		for col := 0; CellOrigin(layout, row, col, size).X < width; col++ {
+			origin := CellOrigin(layout, row, col, size)
+			cells = append(cells, image.Rect(origin.X, origin.Y, origin.X+size, origin.Y+size))
+		}
+	}
+	return cells
+}
+
+//LayoutMask returns an alpha mask, positioned at the origin, describing which pixels of a cell's bounding box belong to
+//the cell. A nil mask means the whole bounding box is used.
+func LayoutMask(layout string, size int) image.Image {
+	if layout == HexLayout {
+		return hexMask{size: size, tip: size - hexRowPitch(size)}
+	}
+	return nil
+}
+
+//LayoutCellsOverlap checks if the bounding boxes of cells in adjacent rows overlap, in which case those cells can't be
+//drawn at the same time: drawing through a mask still reads and writes back the pixels of the whole bounding box. This
+//is true of all hexagons, and for hexagons with an odd size the masked cells share pixels as well since they are
+//widened to avoid gaps (see hexMask), so the order in which rows are drawn matters too.
+func LayoutCellsOverlap(layout string, size int) bool {
+	return layout == HexLayout
+}
+
+//hexRowPitch returns the vertical distance between rows of hexagons with the given bounding box size. Hexagons in
+//adjacent rows interlock so rows are closer together than the height of a cell. Rows are always at least a pixel apart
+//so that tiny cells still make progress down the image.
+func hexRowPitch(size int) int {
+	if size < 2 {
+		return 1
+	}
+	return size * 3 / 4
+}
+
+//hexMask is an image.Image that is opaque inside a pointy-topped hexagon inscribed in a size x size square and
+//transparent outside it. The top and bottom points of the hexagon are tip pixels tall so that rows spaced by
+//hexRowPitch tessellate exactly.
+type hexMask struct {
+	size int
+	tip  int
+}
+
+func (m hexMask) ColorModel() color.Model {
+	return color.AlphaModel
+}
+
+func (m hexMask) Bounds() image.Rectangle {
+	return image.Rect(0, 0, m.size, m.size)
+}
+
+func (m hexMask) At(x, y int) color.Color {
+	if m.contains(x, y) {
+		return color.Opaque
+	}
+	return color.Transparent
+}
+
+//contains checks whether the center of the pixel at x,y falls inside the hexagon. Odd-sized cells cannot be offset by
+//exactly half a cell, so their hexagons are widened by half a pixel to avoid leaving gaps between rows.
+func (m hexMask) contains(x, y int) bool {
+	half := float64(m.size) / 2
+	cx, cy := float64(x)+0.5, float64(y)+0.5
+	d := cx - half
+	if d < 0 {
+		d = -d
+	}
+	if m.size%2 == 1 {
+		d = math.Max(d-0.5, 0)
+	}
+	if d > half || cy < 0 || cy > float64(m.size) {
+		return false
+	}
+	edge := float64(m.tip) * d / half
+	return cy >= edge && cy <= float64(m.size)-edge
+}
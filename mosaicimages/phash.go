@@ -0,0 +1,125 @@
+package mosaicimages
+
+import (
+	"github.com/cfagiani/gomosaic"
+	"image"
+	"math/bits"
+	"sort"
+)
+
+const (
+	//width and height of the grid of brightness values compared to compute a hash
+	hashSize = 8
+	hashBits = hashSize * hashSize
+)
+
+//PerceptualHash returns a 64 bit difference hash (dHash) of the image: the image is reduced to a 9x8 grid of average
+//brightness values and each bit records whether a cell is brighter than its right neighbour. Resizing, recompressing
+//or slightly editing an image changes few bits, so similar images have hashes that differ in few bits. Images without
+//any detail (e.g. a single color) hash to 0.
+func PerceptualHash(img image.Image) uint64 {
+	var sums [hashSize][hashSize + 1]uint64
+	var counts [hashSize][hashSize + 1]uint64
+	bounds := img.Bounds()
+	if bounds.Empty() {
+		return 0
+	}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row := (y - bounds.Min.Y) * hashSize / bounds.Dy()
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			col := (x - bounds.Min.X) * (hashSize + 1) / bounds.Dx()
+			r, g, b, _ := img.At(x, y).RGBA()
+			sums[row][col] += (299*uint64(r) + 587*uint64(g) + 114*uint64(b)) / 1000
+			counts[row][col]++
+		}
+	}
+	var hash uint64
+	for row := 0; row < hashSize; row++ {
+		for col := 0; col < hashSize; col++ {
+			hash <<= 1
+			//cells without pixels (in images narrower or shorter than the grid) count as black
+			var left, right uint64
+			if counts[row][col] > 0 {
+				left = sums[row][col] / counts[row][col]
+			}
+			if counts[row][col+1] > 0 {
+				right = sums[row][col+1] / counts[row][col+1]
+			}
+			if left > right {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+//HashDistance returns the number of bits that differ between two hashes (their Hamming distance).
+func HashDistance(a uint64, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+//DuplicateGroups returns the groups of tiles whose hashes are within maxDistance bits of each other, as positions in
+//tiles. Grouping is transitive: if a is close to b and b to c, all three are in the same group even if a and c aren't
+//close. Only groups of two or more tiles are returned, each sorted, and the groups are ordered by their first tile.
+//Tiles without a hash are never grouped. If maxDistance is negative, no groups are returned.
+func DuplicateGroups(tiles gomosaic.MosaicTiles, maxDistance int) [][]int {
+	if maxDistance < 0 {
+		return nil
+	}
+	if maxDistance >= hashBits {
+		maxDistance = hashBits - 1
+	}
+	parent := make([]int, len(tiles))
+	for i := range parent {
+		parent[i] = i
+	}
+	var root func(i int) int
+	root = func(i int) int {
+		if parent[i] != i {
+			parent[i] = root(parent[i])
+		}
+		return parent[i]
+	}
+
+	//if two hashes differ in at most maxDistance bits, at least one of maxDistance+1 disjoint blocks of bits is
+	//identical in both so only tiles sharing the value of a block need to be compared
+	blocks := maxDistance + 1
+	for block := 0; block < blocks; block++ {
+		start := block * hashBits / blocks
+		end := (block + 1) * hashBits / blocks
+		mask := uint64(1)<<uint(end-start) - 1
+		if end-start == hashBits {
+			mask = ^uint64(0)
+		}
+		buckets := make(map[uint64][]int)
+		for i, tile := range tiles {
+			if tile.HasHash {
+				key := (tile.Hash >> uint(start)) & mask
+				buckets[key] = append(buckets[key], i)
+			}
+		}
+		for _, bucket := range buckets {
+			for x := 0; x < len(bucket); x++ {
+				for y := x + 1; y < len(bucket); y++ {
+					i, j := bucket[x], bucket[y]
+					if root(i) != root(j) && HashDistance(tiles[i].Hash, tiles[j].Hash) <= maxDistance {
+						parent[root(i)] = root(j)
+					}
+				}
+			}
+		}
+	}
+
+	members := make(map[int][]int)
+	for i := range tiles {
+		members[root(i)] = append(members[root(i)], i)
+	}
+	var groups [][]int
+	for _, group := range members {
+		if len(group) > 1 {
+			groups = append(groups, group)
+		}
+	}
+	sort.Slice(groups, func(a, b int) bool { return groups[a][0] < groups[b][0] })
+	return groups
+}
@@ -0,0 +1,119 @@
+package mosaicimages
+
+import (
+	"github.com/cfagiani/gomosaic"
+	"image"
+	"image/color"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+//TestPerceptualHash verifies that resized copies of an image have close hashes, that different images don't and that
+//images without detail hash to 0.
+func TestPerceptualHash(t *testing.T) {
+	hashes := make(map[string]uint64)
+	for _, name := range []string{"../testdata/img1.png", "../testdata/img2.png", "../testdata/img3.jpg"} {
+		tile, err := AnalyzeTileImage(name)
+		if err != nil || tile.Hash == 0 {
+			t.Fatalf("AnalyzeTileImage(%s) returned %+v, %v", name, tile, err)
+		}
+		hashes[name] = tile.Hash
+		resized, err := ResizeImage(name, 37, 53)
+		if err != nil {
+			t.Fatalf("Could not resize %s: %v", name, err)
+		}
+		if d := HashDistance(tile.Hash, PerceptualHash(resized)); d > 6 {
+			t.Errorf("The hash of a resized copy of %s differs in %d bits", name, d)
+		}
+	}
+	if d := HashDistance(hashes["../testdata/img1.png"], hashes["../testdata/img3.jpg"]); d < 10 {
+		t.Errorf("The hashes of different images only differ in %d bits", d)
+	}
+
+	solid := image.NewRGBA(image.Rect(0, 0, 20, 20))
+	for i := 0; i < 400; i++ {
+		solid.Set(i%20, i/20, color.RGBA{200, 10, 10, 255})
+	}
+	if hash := PerceptualHash(solid); hash != 0 {
+		t.Errorf("PerceptualHash of a solid image returned %x", hash)
+	}
+	if hash := PerceptualHash(image.NewRGBA(image.Rect(0, 0, 0, 0))); hash != 0 {
+		t.Errorf("PerceptualHash of an empty image returned %x", hash)
+	}
+}
+
+//TestDuplicateGroups verifies grouping on hand picked hashes and compares the result with comparing every pair of
+//random hashes.
+func TestDuplicateGroups(t *testing.T) {
+	//the second tile wasn't hashed while the sixth has a hash of 0
+	tiles := gomosaic.MosaicTiles{{Hash: 0xff00, HasHash: true}, {}, {Hash: 0xff01, HasHash: true},
+		{Hash: 0x1234, HasHash: true}, {Hash: 0xff03, HasHash: true}, {HasHash: true}, {Hash: 0x1234, HasHash: true},
+		{Hash: 0xf0f0f0f0, HasHash: true}}
+	cases := []struct {
+		distance int
+		expected [][]int
+	}{
+		{-1, nil},
+		{0, [][]int{{3, 6}}},
+		//0xff00 and 0xff03 are 2 bits apart but both are 1 bit from 0xff01
+		{1, [][]int{{0, 2, 4}, {3, 6}}},
+		{64, [][]int{{0, 2, 3, 4, 5, 6, 7}}},
+	}
+	for _, c := range cases {
+		if groups := DuplicateGroups(tiles, c.distance); !reflect.DeepEqual(groups, c.expected) {
+			t.Errorf("DuplicateGroups(%d) returned %v but %v was expected", c.distance, groups, c.expected)
+		}
+	}
+
+	r := rand.New(rand.NewSource(7))
+	random := make(gomosaic.MosaicTiles, 300)
+	for i := range random {
+		//flip a few bits of one of a handful of base hashes so there are clusters at various distances
+		random[i].Hash = uint64(r.Intn(8)+1)*0x9e3779b97f4a7c15 ^ (1 << uint(r.Intn(64))) ^ (1 << uint(r.Intn(64)))
+		random[i].HasHash = true
+	}
+	for _, distance := range []int{0, 2, 5, 20} {
+		parent := make([]int, len(random))
+		for i := range parent {
+			parent[i] = i
+		}
+		var root func(i int) int
+		root = func(i int) int {
+			if parent[i] != i {
+				return root(parent[i])
+			}
+			return i
+		}
+		for i := range random {
+			for j := i + 1; j < len(random); j++ {
+				if HashDistance(random[i].Hash, random[j].Hash) <= distance {
+					parent[root(i)] = root(j)
+				}
+			}
+		}
+		groups := DuplicateGroups(random, distance)
+		grouped := 0
+		for _, group := range groups {
+			for _, i := range group {
+				if root(i) != root(group[0]) {
+					t.Errorf("With distance %d, %d and %d were grouped but aren't close", distance, group[0], i)
+				}
+			}
+			grouped += len(group)
+		}
+		expectedGrouped := 0
+		sizes := make(map[int]int)
+		for i := range random {
+			sizes[root(i)]++
+		}
+		for _, size := range sizes {
+			if size > 1 {
+				expectedGrouped += size
+			}
+		}
+		if grouped != expectedGrouped {
+			t.Errorf("With distance %d, %d tiles were grouped but %d were expected", distance, grouped, expectedGrouped)
+		}
+	}
+}
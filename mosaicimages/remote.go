@@ -0,0 +1,49 @@
+package mosaicimages
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+const (
+	//time allowed for downloading a remote image when no client is provided
+	defaultFetchTimeout = 30 * time.Second
+	//largest remote image that will be read
+	maxRemoteImageBytes = 64 << 20
+)
+
+var defaultHTTPClient = &http.Client{Timeout: defaultFetchTimeout}
+
+//FetchImage downloads the image at the url passed in. Only http and https urls are supported. If client is nil, a
+//client with a 30 second timeout is used. The caller must close the returned reader, which stops after 64 MiB.
+func FetchImage(client *http.Client, url string) (io.ReadCloser, error) {
+	if client == nil {
+		client = defaultHTTPClient
+	}
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported url scheme %q in %s", req.URL.Scheme, url)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+		return nil, fmt.Errorf("could not fetch %s: %s", url, resp.Status)
+	}
+	return limitedBody{io.LimitReader(resp.Body, maxRemoteImageBytes), resp.Body}, nil
+}
+
+//limitedBody caps the number of bytes read from a response body while still allowing it to be closed.
+type limitedBody struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,205 @@
+package mosaicimages
+
+import (
+	"fmt"
+	"github.com/cfagiani/gomosaic"
+	"github.com/cfagiani/gomosaic/s3"
+	"github.com/cfagiani/gomosaic/tilecache"
+	"github.com/utahta/go-openuri"
+	"google.golang.org/api/photoslibrary/v1"
+	"image"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+//TileLoader reads the images referenced by tiles and resizes them for use in a mosaic. The caches are optional: Memory
+//avoids reading the same tile twice during a render, Cache avoids re-reading originals across runs, BaseURLs avoids
+//looking up Google Photos media items one at a time and Archives avoids reading a tar archive once per tile.
+type TileLoader struct {
+	PhotoService *photoslibrary.Service
+	Cache        *tilecache.Cache
+	Memory       *tilecache.MemoryCache
+	BaseURLs     *BaseURLCache
+	Archives     *ArchiveCache
+	//HTTPClient is used to download tiles indexed from urls. If nil, a client with a 30 second timeout is used.
+	HTTPClient *http.Client
+	//S3 holds the client used to read the tiles indexed from each bucket.
+	S3 map[string]*s3.Client
+}
+
+//Prefetch prepares the tiles passed in that aren't in the on-disk cache at tileSize so they don't have to be fetched
+//one at a time: the base urls of Google Photos tiles are resolved so they can then be downloaded in parallel without a
+//lookup per tile (if the loader has a BaseURLs cache) and the tiles of each tar archive are read in a single pass (if
+//it has an Archives cache). Archives that can't be read are logged and their tiles are read one by one when loaded.
+func (l TileLoader) Prefetch(tiles []gomosaic.MosaicTile, tileSize uint) error {
+	var ids []string
+	archives := make(map[string][]string)
+	for _, tile := range tiles {
+		if (tile.Loc != "G" || l.BaseURLs == nil) && (tile.Loc != "A" || l.Archives == nil) {
+			continue
+		}
+		if l.Cache != nil {
+			if fingerprint, err := tileFingerprint(tile); err == nil &&
+				l.Cache.Has(tilecache.Key(tile.Loc, tile.Filename, fingerprint, tileSize)) {
+				continue
+			}
+		}
+		if tile.Loc == "G" {
+			ids = append(ids, tile.Filename)
+		} else if path, entry, err := SplitArchiveEntryName(tile.Filename); err == nil && isTarArchive(path) {
+			archives[path] = append(archives[path], entry)
+		}
+	}
+	for path, entries := range archives {
+		if err := l.Archives.Read(path, entries, tileSize); err != nil {
+			log.Printf("Could not read the tiles of %s ahead: %v", path, err)
+		}
+	}
+	if l.BaseURLs == nil {
+		return nil
+	}
+	return l.BaseURLs.Resolve(ids)
+}
+
+//Load returns the image referenced by the tile resized to tileSize x tileSize, checking the in-memory cache and then the
+//on-disk cache before reading the original image.
+func (l TileLoader) Load(tile gomosaic.MosaicTile, tileSize uint) (image.Image, error) {
+	memoryKey := fmt.Sprintf("%s\x00%s\x00%d", tile.Loc, tile.Filename, tileSize)
+	if l.Memory != nil {
+		if tileImage, ok := l.Memory.Get(memoryKey); ok {
+			return tileImage, nil
+		}
+	}
+
+	var key string
+	var tileImage image.Image
+	if l.Cache != nil {
+		fingerprint, err := tileFingerprint(tile)
+		if err != nil {
+			return nil, err
+		}
+		key = tilecache.Key(tile.Loc, tile.Filename, fingerprint, tileSize)
+		tileImage, _ = l.Cache.Get(key)
+	}
+
+	if tileImage == nil {
+		var err error
+		if tileImage, err = l.read(tile, tileSize); err != nil {
+			return nil, err
+		}
+		if l.Cache != nil {
+			if cacheErr := l.Cache.Put(key, tileImage); cacheErr != nil {
+				log.Printf("Could not cache tile %s: %v", tile.Filename, cacheErr)
+			}
+		}
+	}
+
+	if l.Memory != nil {
+		l.Memory.Put(memoryKey, tileImage)
+	}
+	return tileImage, nil
+}
+
+//read fetches the original image referenced by the tile and resizes it.
+func (l TileLoader) read(tile gomosaic.MosaicTile, tileSize uint) (image.Image, error) {
+	switch tile.Loc {
+	case "L":
+		return ResizeImage(tile.Filename, tileSize, tileSize)
+	case "G":
+		baseURL, err := l.baseURL(tile.Filename)
+		if err != nil {
+			return nil, err
+		}
+		file, err := openuri.Open(baseURL + fmt.Sprintf("=w%d-h%d-c", tileSize, tileSize))
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		tileImage, _, err := image.Decode(file)
+		return tileImage, err
+	case "U":
+		body, err := FetchImage(l.HTTPClient, tile.Filename)
+		if err != nil {
+			return nil, err
+		}
+		defer body.Close()
+		return ResizeReader(body, tileSize, tileSize)
+	case "A":
+		if l.Archives != nil {
+			if tileImage, ok := l.Archives.take(tile.Filename, tileSize); ok {
+				return tileImage, nil
+			}
+		}
+		entry, err := OpenArchiveEntry(tile.Filename)
+		if err != nil {
+			return nil, err
+		}
+		defer entry.Close()
+		return ResizeReader(entry, tileSize, tileSize)
+	case "S":
+		bucket, key, _, err := s3.ParseTileName(tile.Filename)
+		if err != nil {
+			return nil, err
+		}
+		client, ok := l.S3[bucket]
+		if !ok {
+			return nil, fmt.Errorf("no s3 source is configured for bucket %s", bucket)
+		}
+		body, err := client.GetObject(bucket, key)
+		if err != nil {
+			return nil, err
+		}
+		defer body.Close()
+		return ResizeReader(body, tileSize, tileSize)
+	}
+	return nil, fmt.Errorf("unrecognized tile location %v", tile.Loc)
+}
+
+//baseURL returns the base url of a Google Photos media item, using the BaseURLs cache if there is one.
+func (l TileLoader) baseURL(id string) (string, error) {
+	if l.BaseURLs != nil {
+		return l.BaseURLs.URL(id)
+	}
+	item, err := l.PhotoService.MediaItems.Get(id).Do()
+	if err != nil {
+		return "", fmt.Errorf("could not get mediaItem from service: %v", err)
+	}
+	return item.BaseUrl, nil
+}
+
+//how long a tile downloaded from a url is cached at most; the index doesn't record the version of the image at a url so
+//it is downloaded again once the period it was cached in has passed
+const urlCachePeriod = 24 * time.Hour
+
+//tileFingerprint returns a string that changes whenever the content of the image referenced by the tile changes. Local
+//files use their size and modification time, as do archive entries (using those of the archive). Google Photos media
+//items cannot be modified so their ID is sufficient, as are s3 tile names since they include the ETag of the object.
+//Url tiles use the current urlCachePeriod (see urlFingerprint).
+func tileFingerprint(tile gomosaic.MosaicTile) (string, error) {
+	if tile.Loc == "U" {
+		return urlFingerprint(time.Now()), nil
+	}
+	if tile.Loc == "L" || tile.Loc == "A" {
+		path := tile.Filename
+		if tile.Loc == "A" {
+			var err error
+			if path, _, err = SplitArchiveEntryName(tile.Filename); err != nil {
+				return "", err
+			}
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%d-%d", info.Size(), info.ModTime().UnixNano()), nil
+	}
+	return "", nil
+}
+
+//urlFingerprint numbers the urlCachePeriod that the time passed in falls in, so that url tiles cached in an earlier
+//period are no longer used (and are eventually evicted).
+func urlFingerprint(now time.Time) string {
+	return fmt.Sprintf("p%d", now.Unix()/int64(urlCachePeriod/time.Second))
+}
@@ -1,15 +1,17 @@
 package mosaicmaker
 
 import (
+	"fmt"
 	"github.com/cfagiani/gomosaic"
 	"github.com/cfagiani/gomosaic/indexer"
+	"github.com/cfagiani/gomosaic/indexer/processor"
 	"github.com/cfagiani/gomosaic/mosaicimages"
+	"github.com/cfagiani/gomosaic/s3"
+	"github.com/cfagiani/gomosaic/tilecache"
 	"github.com/cfagiani/gomosaic/util"
 	"google.golang.org/api/photoslibrary/v1"
 	"log"
 	"math"
-	"os"
-	"errors"
 )
 
 const (
@@ -19,26 +21,52 @@ const (
 )
 
 //MakeMosaic makes a new photomosaic of the sourceImage using the files referenced in the indexDir as a source. This method
-//will divide up the source image into a grid and find the best match tile from the index to use in the output image.
-func MakeMosaic(sourceImage string, indexPath string, gridSize int, tileSize int, outputFile string, configFile string) error {
+//will divide up the source image into a grid of cells arranged according to options.Layout (see
+//mosaicimages.SquareLayout, BrickLayout and HexLayout) and find the best match tile from the index to use in the output
+//image. The grid and tile sizes are derived from the options (see Options).
+func MakeMosaic(sourceImage string, indexPath string, options Options, outputFile string, configFile string) error {
 	var photoService *photoslibrary.Service
 	var err error
+	s3Clients := make(map[string]*s3.Client)
 
-	if gridSize <= 0 || tileSize <= 0 {
-		return errors.New("gridSize and tileSize must be positive")
+	layout := options.Layout
+	if err = mosaicimages.ValidateLayout(layout); err != nil {
+		return err
 	}
+	sourceWidth, sourceHeight, err := mosaicimages.GetImageDimensions(sourceImage)
+	if err != nil {
+		return err
+	}
+	gridSize, tileSize, err := options.resolveSizes(sourceWidth, sourceHeight)
+	if err != nil {
+		return err
+	}
+	log.Printf("Using a grid size of %d and a tile size of %d", gridSize, tileSize)
 
 	if len(configFile) > 0 {
-		config, e := util.ReadConfig(configFile)
-		if e != nil {
-			log.Fatalf("Could not read configuration file: %v\n", e)
-			os.Exit(1)
-		}
-		//TODO: get token from correct source
-		photoService, err = util.GetPhotosService(config.GoogleClientId, config.GoogleClientSecret, "token.json")
+		config, err := util.ReadConfig(configFile)
 		if err != nil {
-			log.Fatalf("Could not initialize service client: %v\n", e)
-			os.Exit(1)
+			return fmt.Errorf("could not read configuration file: %v", err)
+		}
+		for _, source := range config.Sources {
+			switch source.Kind {
+			case processor.GoogleKind:
+				if photoService != nil {
+					continue
+				}
+				tokenFile, err := util.GoogleTokenFile(config)
+				if err != nil {
+					return err
+				}
+				photoService, err = util.GetPhotosService(config.GoogleClientId, config.GoogleClientSecret, tokenFile)
+				if err != nil {
+					return fmt.Errorf("could not initialize service client: %v", err)
+				}
+			case processor.S3Kind:
+				if source.S3 != nil {
+					s3Clients[source.S3.Bucket] = s3.NewClient(*source.S3)
+				}
+			}
 		}
 	}
 
@@ -47,67 +75,57 @@ func MakeMosaic(sourceImage string, indexPath string, gridSize int, tileSize int
 	if !exists {
 		log.Fatalf("Cannot produce a mosaic since index does not exist at %s", indexPath)
 	}
-	index := indexer.ReadIndex(filename)
+	index, err := indexer.LoadIndex(filename)
+	if err != nil {
+		return fmt.Errorf("could not read index: %v", err)
+	}
 	if len(index) < minIndexSize {
 		log.Fatal("Index contains too few entries to generate a mosaic. Index  more tile images.")
 	}
 	log.Printf("Using index with %d entries", len(index))
-	segments, w, h, _ := mosaicimages.SegmentImage(sourceImage, gridSize)
-	mosaic := make(map[gomosaic.ImageSegment]gomosaic.MosaicTile)
-	usedTiles := make(map[gomosaic.MosaicTile]bool)
-	log.Println("Computing matches")
-	for idx, node := range segments {
-		//TODO do this in parallel with goroutine/channels
-		//TODO come up with better findBestTile implementation
-		//shouldn't be hard to improve on O(GI) where G is grid size and I is index size)
-		mosaic[node] = findBestTile(node, index, usedTiles)
-		if idx%logInterval == 0 {
-			log.Printf("Tiles selected for %d segments", idx)
+	segments, w, h, _ := mosaicimages.SegmentImage(sourceImage, gridSize, layout)
+	var cache *tilecache.Cache
+	if len(options.CacheDir) > 0 {
+		if cache, err = tilecache.Open(options.CacheDir, options.CacheSize); err != nil {
+			return err
 		}
 	}
+	workers := options.workers()
+	log.Println("Computing matches")
+	var duplicates [][]int
+	if options.DuplicateDistance >= 0 {
+		duplicates = mosaicimages.DuplicateGroups(index, options.DuplicateDistance)
+		log.Printf("Found %d groups of duplicate tiles", len(duplicates))
+	}
+	matches := matchTiles(segments, index, workers, duplicates)
 
 	log.Println("Assembling image")
 	//write final image
 	outputImage, _ := mosaicimages.CreateDrawableImage(tileSize, gridSize, w, h)
-	for idx, node := range segments {
-		x, y := projectToDestCoordinates(node, w, h, tileSize, gridSize)
-		mosaicimages.WriteTileToImage(outputImage, mosaic[node], uint(tileSize), x, y, photoService)
-		if idx%logInterval == 0 {
-			log.Printf("Wrote %d tiles into destination image", idx)
-		}
+	loader := mosaicimages.TileLoader{PhotoService: photoService, Cache: cache,
+		Memory: tilecache.NewMemoryCache(options.MemoryCacheSize), Archives: mosaicimages.NewArchiveCache(),
+		S3: s3Clients}
+	if photoService != nil {
+		loader.BaseURLs = mosaicimages.NewBaseURLCache(photoService, util.RetryPolicy{})
+	}
+	if err = renderTiles(outputImage, segments, matches, w, h, tileSize, gridSize, layout, workers, loader); err != nil {
+		return err
 	}
 	//now write image to file
 	return mosaicimages.WriteImageToFile(outputImage, outputFile)
 
 }
 
-func projectToDestCoordinates(seg gomosaic.ImageSegment, w int, h int, tileSize int, gridSize int) (int, int) {
-	tileX := seg.XMin / gridSize
-	tileY := seg.YMin / gridSize
-	return tileX * tileSize, tileY * tileSize
-}
-
-//Finds the tile with the closest match to the segment average color.
-//TODO better duplicate handling
-//TODO take a threshold as a param and exit once a match within that threshold is found (to avoid having to search the entire index each time)
-func findBestTile(segment gomosaic.ImageSegment, index gomosaic.MosaicTiles, usedTiles map[gomosaic.MosaicTile]bool) gomosaic.MosaicTile {
-	bestNode := index[0]
-	bestDist := math.MaxFloat64
-	for _, node := range index {
-		if usedTiles[node] {
-			continue
-		}
-		curDist := getDistance(segment, node)
-		if curDist < bestDist {
-			curDist = bestDist
-			bestNode = node
-		}
-	}
-	usedTiles[bestNode] = true
-	return bestNode
+//projectToDestCoordinates returns the position in the output image of the top-left corner of the tile that replaces
+//the segment passed in.
+func projectToDestCoordinates(seg gomosaic.ImageSegment, w int, h int, tileSize int, gridSize int, layout string) (int, int) {
+	row, col := mosaicimages.CellIndex(layout, seg.XMin, seg.YMin, gridSize)
+	origin := mosaicimages.CellOrigin(layout, row, col, tileSize)
+	return origin.X, origin.Y
 }
 
 //returns the "distance" between the tile and segment using the sum of squared distances calculation
 func getDistance(segment gomosaic.ImageSegment, tile gomosaic.MosaicTile) float64 {
-	return math.Pow(float64(segment.RVal-tile.AvgR), 2) + math.Pow(float64(segment.GVal-tile.AvgG), 2) + math.Pow(float64(segment.BVal-tile.AvgB), 2)
+	return math.Pow(float64(segment.RVal)-float64(tile.AvgR), 2) + math.Pow(float64(segment.GVal)-float64(tile.AvgG), 2) +
+		math.Pow(float64(segment.BVal)-float64(tile.AvgB), 2)
 }
@@ -1,8 +1,15 @@
 package mosaicmaker
 
 import (
+	"bytes"
+	"fmt"
+	"image"
+	"math/rand"
+	"strings"
 	"testing"
 	"github.com/cfagiani/gomosaic"
+	"github.com/cfagiani/gomosaic/mosaicimages"
+	"github.com/cfagiani/gomosaic/tilecache"
 )
 
 func TestProjectToDestCoordinates(t *testing.T) {
@@ -12,19 +19,230 @@ func TestProjectToDestCoordinates(t *testing.T) {
 		h         int
 		tileSize  int
 		gridSize  int
+		layout    string
 		expectedX int
 		expectedY int
 	}{
-		{gomosaic.ImageSegment{0, 0, 100, 100, 1, 1, 1}, 200, 200, 50, 50, 0, 0},
-		{gomosaic.ImageSegment{100, 0, 100, 100, 1, 1, 1}, 200, 200, 50, 50, 100, 0},
-		{gomosaic.ImageSegment{100, 0, 100, 100, 1, 1, 1}, 200, 200, 10, 50, 20, 0},
-		{gomosaic.ImageSegment{100, 0, 100, 100, 1, 1, 1}, 200, 200, 10, 5, 200, 0},
-		{gomosaic.ImageSegment{100, 100, 200, 200, 1, 1, 1}, 200, 200, 10, 5, 200, 200},
+		{gomosaic.ImageSegment{0, 0, 100, 100, 1, 1, 1}, 200, 200, 50, 50, mosaicimages.SquareLayout, 0, 0},
+		{gomosaic.ImageSegment{100, 0, 100, 100, 1, 1, 1}, 200, 200, 50, 50, mosaicimages.SquareLayout, 100, 0},
+		{gomosaic.ImageSegment{100, 0, 100, 100, 1, 1, 1}, 200, 200, 10, 50, mosaicimages.SquareLayout, 20, 0},
+		{gomosaic.ImageSegment{100, 0, 100, 100, 1, 1, 1}, 200, 200, 10, 5, mosaicimages.SquareLayout, 200, 0},
+		{gomosaic.ImageSegment{100, 100, 200, 200, 1, 1, 1}, 200, 200, 10, 5, mosaicimages.SquareLayout, 200, 200},
+		{gomosaic.ImageSegment{-25, 50, 25, 100, 1, 1, 1}, 200, 200, 10, 50, mosaicimages.BrickLayout, -5, 10},
+		{gomosaic.ImageSegment{25, 50, 75, 100, 1, 1, 1}, 200, 200, 10, 50, mosaicimages.BrickLayout, 5, 10},
+		{gomosaic.ImageSegment{0, -10, 40, 30, 1, 1, 1}, 200, 200, 20, 40, mosaicimages.HexLayout, 0, -5},
+		{gomosaic.ImageSegment{20, 20, 60, 60, 1, 1, 1}, 200, 200, 20, 40, mosaicimages.HexLayout, 10, 10},
 	}
 	for _, c := range cases {
-		x, y := projectToDestCoordinates(c.seg, c.w, c.h, c.tileSize, c.gridSize)
+		x, y := projectToDestCoordinates(c.seg, c.w, c.h, c.tileSize, c.gridSize, c.layout)
 		if x != c.expectedX || y != c.expectedY {
 			t.Errorf("projectToDestCoordinates returned %v,%v when %v,%v was expected", x, y, c.expectedX, c.expectedY)
 		}
 	}
 }
+
+//TestResolveSizes verifies that grid and tile sizes are derived correctly from the various ways of specifying them.
+func TestResolveSizes(t *testing.T) {
+	cases := []struct {
+		options     Options
+		w           int
+		h           int
+		expectGrid  int
+		expectTile  int
+		expectError bool
+	}{
+		{Options{GridSize: 10, TileSize: 50}, 200, 100, 10, 50, false},
+		{Options{Columns: 80, TileSize: 50}, 4000, 3000, 50, 50, false},
+		{Options{GridSize: 10, Columns: 80, Rows: 30, TileSize: 50}, 4000, 3000, 10, 50, false},
+		{Options{Columns: 80, TileSize: 50}, 800, 600, 10, 50, false},
+		{Options{Rows: 30, TileSize: 50}, 4000, 3000, 100, 50, false},
+		{Options{Columns: 40, Rows: 40, TileSize: 50}, 4000, 3000, 75, 50, false},
+		{Options{Columns: 40, OutputWidth: 2000}, 4000, 3000, 100, 50, false},
+		{Options{Columns: 40, OutputHeight: 1500}, 4000, 3000, 100, 50, false},
+		{Options{Columns: 40, PrintWidth: 10, DPI: 200}, 4000, 3000, 100, 50, false},
+		{Options{Columns: 40, PrintWidth: 25.4, PrintUnit: Centimeters, DPI: 200}, 4000, 3000, 100, 50, false},
+		{Options{Columns: 40, PrintWidth: 10}, 4000, 3000, 100, 75, false},
+		{Options{Columns: 40, PrintWidth: 10, PrintUnit: "ft"}, 4000, 3000, 0, 0, true},
+		{Options{Columns: 5000, TileSize: 50}, 4000, 3000, 0, 0, true},
+		{Options{TileSize: 50}, 4000, 3000, 0, 0, true},
+		{Options{GridSize: 10}, 4000, 3000, 0, 0, true},
+		{Options{GridSize: 10, TileSize: 10}, 0, 3000, 0, 0, true},
+	}
+	for _, c := range cases {
+		grid, tile, err := c.options.resolveSizes(c.w, c.h)
+		if err != nil && !c.expectError {
+			t.Errorf("resolveSizes returned an unexpected error for %+v: %v", c.options, err)
+		} else if err == nil {
+			if c.expectError {
+				t.Errorf("resolveSizes should have returned an error for %+v", c.options)
+			} else if grid != c.expectGrid || tile != c.expectTile {
+				t.Errorf("resolveSizes returned %d,%d for %+v when %d,%d was expected", grid, tile, c.options,
+					c.expectGrid, c.expectTile)
+			}
+		}
+	}
+}
+
+//TestMatchTiles verifies that the best unused tile is selected for each segment and that the matches do not depend on
+//the number of workers used to search the index.
+func TestMatchTiles(t *testing.T) {
+	r := rand.New(rand.NewSource(42))
+	index := make(gomosaic.MosaicTiles, 2*minParallelScan)
+	for i := range index {
+		index[i] = gomosaic.MosaicTile{Loc: "L", Filename: fmt.Sprintf("tile%d", i),
+			AvgR: uint32(r.Intn(65536)), AvgG: uint32(r.Intn(65536)), AvgB: uint32(r.Intn(65536))}
+	}
+	segments := make([]gomosaic.ImageSegment, 50)
+	for i := range segments {
+		segments[i] = gomosaic.ImageSegment{XMin: i, RVal: uint32(r.Intn(65536)), GVal: uint32(r.Intn(65536)),
+			BVal: uint32(r.Intn(65536))}
+	}
+	serial := matchTiles(segments, index, 1, nil)
+	for _, workers := range []int{2, 3, 8} {
+		parallel := matchTiles(segments, index, workers, nil)
+		for i := range serial {
+			if serial[i] != parallel[i] {
+				t.Fatalf("Match %d differs with %d workers: %v vs %v", i, workers, parallel[i], serial[i])
+			}
+		}
+	}
+	best := index[0]
+	for _, tile := range index {
+		if getDistance(segments[0], tile) < getDistance(segments[0], best) {
+			best = tile
+		}
+	}
+	if serial[0] != best {
+		t.Errorf("matchTiles selected %v for the first segment but %v is closer", serial[0], best)
+	}
+	used := make(map[gomosaic.MosaicTile]bool)
+	for _, tile := range serial {
+		if used[tile] {
+			t.Errorf("matchTiles reused tile %v", tile)
+		}
+		used[tile] = true
+	}
+}
+
+//TestMatchTilesDuplicates verifies that once a tile is selected, the tiles grouped with it aren't selected either.
+func TestMatchTilesDuplicates(t *testing.T) {
+	index := gomosaic.MosaicTiles{{Loc: "L", Filename: "burst1", AvgR: 100}, {Loc: "L", Filename: "burst2", AvgR: 101},
+		{Loc: "L", Filename: "other", AvgR: 200}, {Loc: "L", Filename: "burst3", AvgR: 102}}
+	segments := []gomosaic.ImageSegment{{RVal: 100}, {RVal: 100}, {RVal: 100}}
+	cases := []struct {
+		duplicates [][]int
+		expected   []string
+	}{
+		{nil, []string{"burst1", "burst2", "burst3"}},
+		{[][]int{{0, 1}}, []string{"burst1", "burst3", "other"}},
+		{[][]int{{0, 1, 3}}, []string{"burst1", "other", "burst1"}},
+	}
+	for _, c := range cases {
+		matches := matchTiles(segments, index, 1, c.duplicates)
+		for i, match := range matches {
+			if match.Filename != c.expected[i] {
+				t.Errorf("With duplicates %v, segment %d was matched to %s but %s was expected", c.duplicates, i,
+					match.Filename, c.expected[i])
+			}
+		}
+	}
+}
+
+//TestRenderTiles verifies that the rendered mosaic is identical regardless of the number of workers, even when the
+//cells overlap. Run with -race, the cases with many distinct tiles check that no two workers draw the same pixels.
+func TestRenderTiles(t *testing.T) {
+	files := []string{"../testdata/img1.png", "../testdata/img2.png", "../testdata/img3.jpg"}
+	cases := []struct {
+		layout   string
+		gridSize int
+		tileSize int
+		tiles    int
+	}{
+		{mosaicimages.SquareLayout, 9, 7, 3},
+		{mosaicimages.HexLayout, 9, 7, 3},
+		{mosaicimages.HexLayout, 8, 12, 3},
+		{mosaicimages.HexLayout, 4, 16, 40},
+		{mosaicimages.HexLayout, 5, 15, 40},
+	}
+	for _, c := range cases {
+		segments, w, h, err := mosaicimages.SegmentImage("../testdata/img1.png", c.gridSize, c.layout)
+		if err != nil {
+			t.Fatalf("Could not segment image: %v", err)
+		}
+		matches := make([]gomosaic.MosaicTile, len(segments))
+		for i := range matches {
+			matches[i] = gomosaic.MosaicTile{Loc: "L", Filename: files[i%len(files)], AvgR: uint32(i % c.tiles)}
+		}
+		var rendered [][]byte
+		for _, workers := range []int{1, 16} {
+			img, _ := mosaicimages.CreateDrawableImage(c.tileSize, c.gridSize, w, h)
+			err := renderTiles(img, segments, matches, w, h, c.tileSize, c.gridSize, c.layout, workers,
+				mosaicimages.TileLoader{Memory: tilecache.NewMemoryCache(0)})
+			if err != nil {
+				t.Fatalf("Could not render tiles: %v", err)
+			}
+			rendered = append(rendered, img.(*image.RGBA).Pix)
+		}
+		if !bytes.Equal(rendered[0], rendered[1]) {
+			t.Errorf("Rendering %v with a different number of workers produced a different image", c)
+		}
+	}
+}
+
+//TestRenderTilesError verifies that a tile that can't be loaded stops the render with an error.
+func TestRenderTilesError(t *testing.T) {
+	segments, w, h, err := mosaicimages.SegmentImage("../testdata/img1.png", 20, mosaicimages.SquareLayout)
+	if err != nil {
+		t.Fatalf("Could not segment image: %v", err)
+	}
+	matches := make([]gomosaic.MosaicTile, len(segments))
+	for i := range matches {
+		matches[i] = gomosaic.MosaicTile{Loc: "L", Filename: "../testdata/img1.png"}
+	}
+	matches[len(matches)/2] = gomosaic.MosaicTile{Loc: "L", Filename: "../testdata/notThere.png"}
+	img, _ := mosaicimages.CreateDrawableImage(10, 20, w, h)
+	err = renderTiles(img, segments, matches, w, h, 10, 20, mosaicimages.SquareLayout, 4, mosaicimages.TileLoader{})
+	if err == nil || !strings.Contains(err.Error(), "notThere.png") {
+		t.Errorf("Expected an error naming the missing tile but got %v", err)
+	}
+}
+
+//TestGroupPlacements verifies that every segment is placed exactly once and that each tile is loaded once per pass.
+func TestGroupPlacements(t *testing.T) {
+	segments := mosaicimages.LayoutCells(mosaicimages.HexLayout, 100, 100, 10)
+	nodes := make([]gomosaic.ImageSegment, len(segments))
+	matches := make([]gomosaic.MosaicTile, len(segments))
+	for i, cell := range segments {
+		nodes[i] = gomosaic.ImageSegment{XMin: cell.Min.X, YMin: cell.Min.Y, XMax: cell.Max.X, YMax: cell.Max.Y}
+		matches[i] = gomosaic.MosaicTile{Loc: "L", Filename: fmt.Sprintf("tile%d", i%7)}
+	}
+	for _, splitRows := range []bool{false, true} {
+		passes := groupPlacements(nodes, matches, 10, mosaicimages.HexLayout, splitRows)
+		if (splitRows && len(passes) != 2) || (!splitRows && len(passes) != 1) {
+			t.Fatalf("groupPlacements returned %d passes when splitRows was %v", len(passes), splitRows)
+		}
+		placed := make(map[int]bool)
+		for p, pass := range passes {
+			seen := make(map[gomosaic.MosaicTile]bool)
+			for _, group := range pass {
+				if seen[group.tile] {
+					t.Errorf("Tile %v appears more than once in pass %d", group.tile, p)
+				}
+				seen[group.tile] = true
+				for _, idx := range group.segments {
+					if placed[idx] || matches[idx] != group.tile {
+						t.Errorf("Segment %d was placed incorrectly", idx)
+					}
+					placed[idx] = true
+					row, _ := mosaicimages.CellIndex(mosaicimages.HexLayout, nodes[idx].XMin, nodes[idx].YMin, 10)
+					if splitRows && row%2 != p {
+						t.Errorf("Segment %d in row %d was placed in pass %d", idx, row, p)
+					}
+				}
+			}
+		}
+		if len(placed) != len(nodes) {
+			t.Errorf("groupPlacements placed %d of %d segments", len(placed), len(nodes))
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package mosaicmaker
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"runtime"
+)
+
+const (
+	//Inches is the PrintUnit used for print sizes expressed in inches.
+	Inches = "in"
+	//Centimeters is the PrintUnit used for print sizes expressed in centimeters.
+	Centimeters = "cm"
+	//default resolution used to convert print sizes to pixels when no DPI is specified
+	defaultDPI = 300
+	cmPerInch  = 2.54
+)
+
+//Options controls the geometry of a mosaic. The size of the grid cells in the source image can be given directly
+//(GridSize) or, when it is 0, derived from the number of Columns and/or Rows. The size of the tiles in the output image can be given
+//directly (TileSize) or derived from the desired output size, either in pixels (OutputWidth/OutputHeight) or as a
+//print size (PrintWidth/PrintHeight in PrintUnit at DPI).
+type Options struct {
+	//size, in source image pixels, of each grid cell
+	GridSize int
+	//size, in output image pixels, of each tile
+	TileSize int
+	//number of tiles across the mosaic
+	Columns int
+	//number of tiles down the mosaic
+	Rows int
+	//width and height, in pixels, of the output image
+	OutputWidth  int
+	OutputHeight int
+	//width and height of the printed mosaic in PrintUnit
+	PrintWidth  float64
+	PrintHeight float64
+	//either Inches (the default) or Centimeters
+	PrintUnit string
+	//resolution of the print, in dots per inch. Defaults to 300.
+	DPI int
+	//arrangement of the tiles (one of the mosaicimages layouts)
+	Layout string
+	//number of goroutines used to match and render tiles. Defaults to the number of CPUs.
+	Workers int
+	//directory of the on-disk cache of resized tiles. If empty, tiles are not cached.
+	CacheDir string
+	//maximum size of the tile cache in bytes. Defaults to tilecache.DefaultMaxBytes.
+	CacheSize int64
+	//maximum size, in bytes, of the decoded tiles kept in memory while rendering. Defaults to
+	//tilecache.DefaultMemoryBytes.
+	MemoryCacheSize int64
+	//tiles whose perceptual hashes differ in at most this many bits are treated as copies of the same photo, so once
+	//one of them is used none of the others are. 0 only groups tiles with identical hashes; negative values disable
+	//grouping. Tiles without a hash are never grouped.
+	DuplicateDistance int
+}
+
+//workers returns the number of goroutines to use when matching and rendering tiles.
+func (o Options) workers() int {
+	if o.Workers > 0 {
+		return o.Workers
+	}
+	return runtime.NumCPU()
+}
+
+//resolveSizes derives the grid and tile sizes to use for a source image of the dimensions passed in. Columns and Rows
+//are only used when GridSize isn't set; when both are specified, the grid is sized so that the mosaic has at least that
+//many tiles in each direction.
+func (o Options) resolveSizes(width int, height int) (int, int, error) {
+	if width <= 0 || height <= 0 {
+		return 0, 0, errors.New("source image dimensions must be positive")
+	}
+	gridSize := o.GridSize
+	if gridSize <= 0 && (o.Columns > 0 || o.Rows > 0) {
+		gridSize = math.MaxInt32
+		if o.Columns > 0 {
+			gridSize = width / o.Columns
+		}
+		if o.Rows > 0 && height/o.Rows < gridSize {
+			gridSize = height / o.Rows
+		}
+		if gridSize <= 0 {
+			return 0, 0, fmt.Errorf("a %dx%d image is too small for %d columns and %d rows", width, height,
+				o.Columns, o.Rows)
+		}
+	}
+	if gridSize <= 0 {
+		return 0, 0, errors.New("either gridSize or the number of columns or rows must be positive")
+	}
+
+	outWidth, outHeight, err := o.outputDimensions()
+	if err != nil {
+		return 0, 0, err
+	}
+	tileSize := o.TileSize
+	if tileSize <= 0 {
+		cols, rows := width/gridSize, height/gridSize
+		if cols == 0 || rows == 0 {
+			return 0, 0, errors.New("gridSize must be smaller than both the width and height of the source image")
+		}
+		if outWidth > 0 {
+			tileSize = outWidth / cols
+		}
+		if outHeight > 0 && (tileSize <= 0 || outHeight/rows < tileSize) {
+			tileSize = outHeight / rows
+		}
+	}
+	if tileSize <= 0 {
+		return 0, 0, errors.New("either tileSize or an output size large enough for one pixel per tile is required")
+	}
+	return gridSize, tileSize, nil
+}
+
+//outputDimensions returns the desired output size in pixels, converting the print size if one was specified. A
+//dimension of 0 means it was not specified.
+func (o Options) outputDimensions() (int, int, error) {
+	if o.PrintWidth <= 0 && o.PrintHeight <= 0 {
+		return o.OutputWidth, o.OutputHeight, nil
+	}
+	dpi := o.DPI
+	if dpi <= 0 {
+		dpi = defaultDPI
+	}
+	var unitsPerInch float64
+	switch o.PrintUnit {
+	case "", Inches:
+		unitsPerInch = 1
+	case Centimeters:
+		unitsPerInch = cmPerInch
+	default:
+		return 0, 0, fmt.Errorf("unrecognized print unit %q", o.PrintUnit)
+	}
+	return int(math.Round(o.PrintWidth / unitsPerInch * float64(dpi))),
+		int(math.Round(o.PrintHeight / unitsPerInch * float64(dpi))), nil
+}
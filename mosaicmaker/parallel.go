@@ -0,0 +1,200 @@
+package mosaicmaker
+
+import (
+	"fmt"
+	"github.com/cfagiani/gomosaic"
+	"github.com/cfagiani/gomosaic/mosaicimages"
+	"image/draw"
+	"log"
+	"math"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	//smallest index that is worth splitting across workers when searching for the best tile
+	minParallelScan = 1000
+)
+
+//forEachParallel calls fn once for each value in [0, n) using at most workers goroutines and returns once all calls
+//have completed. Callers are responsible for making sure concurrent calls don't write to the same memory.
+func forEachParallel(n int, workers int, fn func(i int)) {
+	if workers > n {
+		workers = n
+	}
+	if workers <= 1 {
+		for i := 0; i < n; i++ {
+			fn(i)
+		}
+		return
+	}
+	var next int64 = -1
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := int(atomic.AddInt64(&next, 1)); i < n; i = int(atomic.AddInt64(&next, 1)) {
+				fn(i)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+//matchTiles selects the best tile for each segment, returning a slice where the tile at position i is the match for
+//segments[i]. Since a tile is not reused once it is selected, segments are matched in order and the search of the
+//index for each segment is split across the workers. The result is the same regardless of the number of workers.
+//duplicates holds groups of positions in the index (see mosaicimages.DuplicateGroups) that are treated as a single
+//tile: once one of them is selected, none of the others are.
+func matchTiles(segments []gomosaic.ImageSegment, index gomosaic.MosaicTiles, workers int, duplicates [][]int) []gomosaic.MosaicTile {
+	matches := make([]gomosaic.MosaicTile, len(segments))
+	usedTiles := make(map[gomosaic.MosaicTile]bool)
+	groupOf := make(map[gomosaic.MosaicTile][]int)
+	for _, group := range duplicates {
+		for _, i := range group {
+			groupOf[index[i]] = group
+		}
+	}
+	for idx, node := range segments {
+		//TODO come up with better findBestTile implementation
+		//shouldn't be hard to improve on O(GI) where G is grid size and I is index size)
+		matches[idx] = findBestTile(node, index, usedTiles, workers)
+		for _, i := range groupOf[matches[idx]] {
+			usedTiles[index[i]] = true
+		}
+		if idx%logInterval == 0 {
+			log.Printf("Tiles selected for %d segments", idx)
+		}
+	}
+	return matches
+}
+
+//Finds the tile with the closest match to the segment average color, splitting the search of large indexes across
+//workers. Ties are resolved in favor of the tile that appears first in the index so the result doesn't depend on the
+//number of workers.
+//TODO better duplicate handling
+//TODO take a threshold as a param and exit once a match within that threshold is found (to avoid having to search the entire index each time)
+func findBestTile(segment gomosaic.ImageSegment, index gomosaic.MosaicTiles, usedTiles map[gomosaic.MosaicTile]bool,
+	workers int) gomosaic.MosaicTile {
+	if len(index) < minParallelScan || workers < 1 {
+		workers = 1
+	}
+	chunkSize := (len(index) + workers - 1) / workers
+	bestIdx := make([]int, workers)
+	bestDist := make([]float64, workers)
+	forEachParallel(workers, workers, func(w int) {
+		bestIdx[w], bestDist[w] = -1, math.MaxFloat64
+		end := (w + 1) * chunkSize
+		if end > len(index) {
+			end = len(index)
+		}
+		for i := w * chunkSize; i < end; i++ {
+			if usedTiles[index[i]] {
+				continue
+			}
+			curDist := getDistance(segment, index[i])
+			if curDist < bestDist[w] {
+				bestDist[w] = curDist
+				bestIdx[w] = i
+			}
+		}
+	})
+	bestNode := index[0]
+	overallDist := math.MaxFloat64
+	for w := range bestIdx {
+		if bestIdx[w] >= 0 && bestDist[w] < overallDist {
+			overallDist = bestDist[w]
+			bestNode = index[bestIdx[w]]
+		}
+	}
+	usedTiles[bestNode] = true
+	return bestNode
+}
+
+//renderTiles writes the tile matched to each segment into the output image using a pool of workers. Placements are
+//grouped by tile so each tile is loaded (and resized) once and then drawn at every position it occupies. Tiles drawn
+//at the same time never share pixels which keeps the output identical regardless of the number of workers: when the
+//cells of adjacent rows overlap, even rows are drawn before odd rows; tiles used in both passes are then served from
+//the loader's in-memory cache. The base urls of Google Photos tiles are looked up in batches, and the tiles of tar
+//archives read in one pass per archive, before any tile is loaded. If a tile can't be loaded, the remaining tiles are skipped and the first error is returned.
+func renderTiles(img draw.Image, segments []gomosaic.ImageSegment, matches []gomosaic.MosaicTile, w int, h int,
+	tileSize int, gridSize int, layout string, workers int, loader mosaicimages.TileLoader) error {
+	mask := mosaicimages.LayoutMask(layout, tileSize)
+	passes := groupPlacements(segments, matches, gridSize, layout, mosaicimages.LayoutCellsOverlap(layout, tileSize))
+	var tiles []gomosaic.MosaicTile
+	for _, pass := range passes {
+		for _, p := range pass {
+			tiles = append(tiles, p.tile)
+		}
+	}
+	if err := loader.Prefetch(tiles, uint(tileSize)); err != nil {
+		log.Printf("Could not look up Google Photos tiles in bulk, looking them up one at a time: %v", err)
+	}
+	var written int64
+	var failed sync.Once
+	var loadErr error
+	var stop int32
+	for _, pass := range passes {
+		forEachParallel(len(pass), workers, func(i int) {
+			if atomic.LoadInt32(&stop) != 0 {
+				return
+			}
+			tileImage, err := loader.Load(pass[i].tile, uint(tileSize))
+			if err != nil {
+				failed.Do(func() {
+					loadErr = fmt.Errorf("could not load tile image %s: %v", pass[i].tile.Filename, err)
+					atomic.StoreInt32(&stop, 1)
+				})
+				return
+			}
+			for _, idx := range pass[i].segments {
+				x, y := projectToDestCoordinates(segments[idx], w, h, tileSize, gridSize, layout)
+				mosaicimages.DrawTile(img, tileImage, x, y, mask)
+				if count := atomic.AddInt64(&written, 1); count%logInterval == 0 {
+					log.Printf("Wrote %d tiles into destination image", count)
+				}
+			}
+		})
+		if loadErr != nil {
+			return loadErr
+		}
+	}
+	return nil
+}
+
+//placements records the indexes of all the segments that were matched to a tile.
+type placements struct {
+	tile     gomosaic.MosaicTile
+	segments []int
+}
+
+//groupPlacements groups the segments by the tile they were matched to, preserving the order in which tiles first
+//appear. If splitRows is true, placements in even and odd rows are returned as separate passes.
+func groupPlacements(segments []gomosaic.ImageSegment, matches []gomosaic.MosaicTile, gridSize int, layout string,
+	splitRows bool) [][]placements {
+	passCount := 1
+	if splitRows {
+		passCount = 2
+	}
+	passes := make([][]placements, passCount)
+	positions := make([]map[gomosaic.MosaicTile]int, passCount)
+	for p := range positions {
+		positions[p] = make(map[gomosaic.MosaicTile]int)
+	}
+	for idx, node := range segments {
+		p := 0
+		if splitRows {
+			row, _ := mosaicimages.CellIndex(layout, node.XMin, node.YMin, gridSize)
+			p = row % 2
+		}
+		pos, ok := positions[p][matches[idx]]
+		if !ok {
+			pos = len(passes[p])
+			positions[p][matches[idx]] = pos
+			passes[p] = append(passes[p], placements{tile: matches[idx]})
+		}
+		passes[p][pos].segments = append(passes[p][pos].segments, idx)
+	}
+	return passes
+}
@@ -2,6 +2,7 @@ package gomosaic
 
 import (
 	"fmt"
+	"strings"
 )
 
 type Config struct {
@@ -14,6 +15,75 @@ type ImageSource struct {
 	Kind    string
 	Path    string
 	Options string
+	//names of the Google Photos albums to index. Path may be used instead when there is only one.
+	Albums []string
+	//restricts the Google Photos media items that are indexed. Cannot be combined with albums.
+	Filters *PhotoFilters
+	//bucket and credentials of an s3 source
+	S3 *S3Location
+	//selects the files indexed from a local source
+	Local *LocalOptions
+}
+
+//LocalOptions selects the files indexed from a local source. Limits that are zero aren't checked.
+type LocalOptions struct {
+	//index the subdirectories of the path as well
+	Recurse bool
+	//glob patterns of the files to index (all images are indexed if empty). Patterns containing a '/' are matched against
+	//the path relative to the source path and others against the file name.
+	Include []string
+	//glob patterns, matched like those in Include, of the files and directories to skip
+	Exclude []string
+	//files and directories whose names start with '.' are skipped unless this is set. Sources without LocalOptions
+	//include them.
+	IncludeHidden bool
+	//follow symbolic links to directories. Each directory is only searched once, so links that form a cycle are safe.
+	FollowSymlinks bool
+	//maximum number of directory levels below the path that are searched when recursing; 0 means no limit
+	MaxDepth int
+	//limits on the dimensions of the images, in pixels
+	MinWidth  int
+	MinHeight int
+	MaxWidth  int
+	MaxHeight int
+	//minimum size of the files, in bytes
+	MinFileSize int64
+}
+
+//S3Location identifies the objects indexed from an S3-compatible object store.
+type S3Location struct {
+	Bucket string
+	//only objects whose keys start with the prefix are indexed
+	Prefix string
+	//base url of the service; defaults to the AWS endpoint of the region
+	Endpoint string
+	//defaults to us-east-1
+	Region string
+	//if blank, the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN environment variables are used
+	AccessKeyId     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+//PhotoFilters restricts the media items indexed from a Google Photos source. All the conditions must be met for an item
+//to be indexed.
+type PhotoFilters struct {
+	//only index items created in one of these date ranges
+	DateRanges []DateRange
+	//only index items in at least one of these content categories (e.g. LANDSCAPES, PEOPLE)
+	IncludeCategories []string
+	//do not index items in any of these content categories (e.g. SCREENSHOTS, RECEIPTS)
+	ExcludeCategories []string
+	//only index items marked as favorites
+	FavoritesOnly bool
+	//one of PHOTO, VIDEO or ALL_MEDIA. Defaults to PHOTO since videos are never indexed.
+	MediaType string
+}
+
+//DateRange is an inclusive range of dates formatted as YYYY-MM-DD.
+type DateRange struct {
+	Start string
+	End   string
 }
 
 //Type representing a tile that can be used in a mosaic
@@ -23,10 +93,28 @@ type MosaicTile struct {
 	AvgR     uint32
 	AvgG     uint32
 	AvgB     uint32
+	//perceptual hash of the image (see mosaicimages.PerceptualHash), only meaningful if HasHash is set
+	Hash uint64
+	//true if the hash was computed; 0 is a valid hash so it cannot tell tiles that weren't hashed apart
+	HasHash bool
 }
 
+//ToString returns the tile as a line of the index. The location code and file name are quoted if they contain the
+//delimiter, a line break or start with a quote (see quoteField).
 func (t MosaicTile) ToString() string {
-	return fmt.Sprintf("%s;%s;%d;%d;%d", t.Loc, t.Filename, t.AvgR, t.AvgG, t.AvgB)
+	if !t.HasHash {
+		return fmt.Sprintf("%s;%s;%d;%d;%d", quoteField(t.Loc), quoteField(t.Filename), t.AvgR, t.AvgG, t.AvgB)
+	}
+	return fmt.Sprintf("%s;%s;%d;%d;%d;%016x", quoteField(t.Loc), quoteField(t.Filename), t.AvgR, t.AvgG, t.AvgB, t.Hash)
+}
+
+//quoteField encloses a field of an index line in double quotes, doubling any quotes it contains, if it couldn't be
+//read back otherwise. Other fields are left as they are so that indexes stay readable by older versions.
+func quoteField(s string) string {
+	if !strings.ContainsAny(s, ";\r\n") && !strings.HasPrefix(s, `"`) {
+		return s
+	}
+	return `"` + strings.Replace(s, `"`, `""`, -1) + `"`
 }
 
 //define a type so we can implement Sort interface
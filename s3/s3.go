@@ -0,0 +1,270 @@
+//Package s3 is a minimal client for the subset of the S3 api needed to index and render images stored in S3-compatible
+//object storage: listing the objects in a bucket and reading an object. Requests are signed with AWS Signature
+//Version 4 and use path-style urls so they work with other implementations (e.g. MinIO) as well as AWS.
+package s3
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"github.com/cfagiani/gomosaic"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	//hash of an empty payload, which is what every request made by the client sends
+	emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	amzDateFormat    = "20060102T150405Z"
+	//DefaultRegion is used when no region is configured.
+	DefaultRegion = "us-east-1"
+)
+
+//Client talks to an S3-compatible endpoint. If AccessKeyID is blank, requests are sent unsigned, which only works for
+//public buckets.
+type Client struct {
+	//Endpoint is the base url of the service, e.g. https://s3.us-west-2.amazonaws.com or http://localhost:9000.
+	Endpoint        string
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+	//SessionToken is only needed for temporary credentials.
+	SessionToken string
+	//HTTPClient is used to send requests. If nil, http.DefaultClient is used.
+	HTTPClient *http.Client
+}
+
+//Object describes an object in a bucket.
+type Object struct {
+	Key  string
+	ETag string
+	Size int64
+}
+
+//Error is returned when the service responds with an error.
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("s3 request failed with status %d: %s %s", e.StatusCode, e.Code, e.Message)
+}
+
+//ListObjects returns every object in the bucket whose key starts with prefix, following continuation tokens until the
+//listing is complete. ETags are returned without their surrounding quotes.
+func (c *Client) ListObjects(bucket string, prefix string) ([]Object, error) {
+	var objects []Object
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}}
+		if len(prefix) > 0 {
+			query.Set("prefix", prefix)
+		}
+		if len(token) > 0 {
+			query.Set("continuation-token", token)
+		}
+		resp, err := c.do(bucket, "", query)
+		if err != nil {
+			return nil, err
+		}
+		var result struct {
+			IsTruncated           bool
+			NextContinuationToken string
+			Contents              []struct {
+				Key  string
+				ETag string
+				Size int64
+			}
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("could not parse object listing: %v", err)
+		}
+		for _, content := range result.Contents {
+			objects = append(objects, Object{Key: content.Key, ETag: strings.Trim(content.ETag, `"`), Size: content.Size})
+		}
+		if !result.IsTruncated || len(result.NextContinuationToken) == 0 {
+			return objects, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+//GetObject returns the content of an object. The caller must close it.
+func (c *Client) GetObject(bucket string, key string) (io.ReadCloser, error) {
+	resp, err := c.do(bucket, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+//do sends a signed GET request for the key (or the bucket itself if key is blank) and returns the response if it was
+//successful.
+func (c *Client) do(bucket string, key string, query url.Values) (*http.Response, error) {
+	path := "/" + uriEncode(bucket, true) + "/"
+	if len(key) > 0 {
+		path += uriEncode(key, false)
+	}
+	rawURL := strings.TrimSuffix(c.Endpoint, "/") + path
+	if len(query) > 0 {
+		rawURL += "?" + canonicalQuery(query)
+	}
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(c.AccessKeyID) > 0 {
+		region := c.Region
+		if len(region) == 0 {
+			region = DefaultRegion
+		}
+		if len(c.SessionToken) > 0 {
+			req.Header.Set("X-Amz-Security-Token", c.SessionToken)
+		}
+		sign(req, c.AccessKeyID, c.SecretAccessKey, region, time.Now())
+	}
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		s3Err := &Error{StatusCode: resp.StatusCode}
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		xml.Unmarshal(body, s3Err)
+		return nil, s3Err
+	}
+	return resp, nil
+}
+
+//sign adds the headers of an AWS Signature Version 4 to a request with an empty payload. The host, the range (if any)
+//and the x-amz-* headers are signed.
+func sign(req *http.Request, accessKeyID string, secretAccessKey string, region string, now time.Time) {
+	amzDate := now.UTC().Format(amzDateFormat)
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", emptyPayloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") || lower == "range" {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{req.Method, req.URL.EscapedPath(), canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(), signedHeaders, emptyPayloadHash}, "\n")
+	scope := date + "/" + region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+secretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+//canonicalQuery encodes the query parameters sorted by name, as required for signing.
+func canonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var parts []string
+	for _, name := range names {
+		values := append([]string(nil), query[name]...)
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, uriEncode(name, true)+"="+uriEncode(value, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+//uriEncode percent-encodes every byte except the unreserved characters and, unless encodeSlash is true, '/'.
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '-' || c == '_' ||
+			c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+//NewClient returns a client for the location passed in. Credentials that aren't part of the location are read from the
+//AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN environment variables.
+func NewClient(location gomosaic.S3Location) *Client {
+	c := &Client{Endpoint: location.Endpoint, Region: location.Region, AccessKeyID: location.AccessKeyId,
+		SecretAccessKey: location.SecretAccessKey, SessionToken: location.SessionToken}
+	if len(c.Region) == 0 {
+		c.Region = DefaultRegion
+	}
+	if len(c.Endpoint) == 0 {
+		c.Endpoint = "https://s3." + c.Region + ".amazonaws.com"
+	}
+	if len(c.AccessKeyID) == 0 {
+		c.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+		c.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		c.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	}
+	return c
+}
+
+//TileName returns the name used for the tile of an object. It includes the ETag so that a changed object gets a new
+//name, which causes it to be analyzed again and keeps stale images from being served by the tile cache.
+func TileName(bucket string, key string, etag string) string {
+	return "s3://" + bucket + "/" + key + "#" + etag
+}
+
+//ParseTileName returns the bucket, key and ETag of a name returned by TileName.
+func ParseTileName(name string) (string, string, string, error) {
+	rest := strings.TrimPrefix(name, "s3://")
+	slash := strings.Index(rest, "/")
+	hash := strings.LastIndex(rest, "#")
+	if rest == name || slash <= 0 || hash < slash {
+		return "", "", "", fmt.Errorf("%s is not an s3 tile name", name)
+	}
+	return rest[:slash], rest[slash+1 : hash], rest[hash+1:], nil
+}
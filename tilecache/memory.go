@@ -0,0 +1,76 @@
+package tilecache
+
+import (
+	"container/list"
+	"image"
+	"sync"
+)
+
+//DefaultMemoryBytes is the default size limit of a MemoryCache (256 MiB).
+const DefaultMemoryBytes int64 = 256 << 20
+
+//MemoryCache is a size-limited, in-memory cache of decoded tile images that evicts the least recently used images once
+//the estimated size of the images it holds exceeds its limit. A MemoryCache is safe for concurrent use.
+type MemoryCache struct {
+	maxBytes int64
+	mu       sync.Mutex
+	size     int64
+	//entries ordered from most to least recently used
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+type memoryEntry struct {
+	key  string
+	img  image.Image
+	size int64
+}
+
+//NewMemoryCache returns an empty MemoryCache holding at most maxBytes of images. A maxBytes <= 0 means
+//DefaultMemoryBytes.
+func NewMemoryCache(maxBytes int64) *MemoryCache {
+	if maxBytes <= 0 {
+		maxBytes = DefaultMemoryBytes
+	}
+	return &MemoryCache{maxBytes: maxBytes, lru: list.New(), entries: make(map[string]*list.Element)}
+}
+
+//Get returns the image stored under key, if present, and marks it as recently used.
+func (c *MemoryCache) Get(key string) (image.Image, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(elem)
+		return elem.Value.(*memoryEntry).img, true
+	}
+	return nil, false
+}
+
+//Put stores img under key, evicting least recently used images if the cache grows beyond its limit.
+func (c *MemoryCache) Put(key string, img image.Image) {
+	//estimate 4 bytes per pixel which is what the RGBA images produced by resizing use
+	size := int64(img.Bounds().Dx()) * int64(img.Bounds().Dy()) * 4
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		e := elem.Value.(*memoryEntry)
+		c.size += size - e.size
+		e.img, e.size = img, size
+		c.lru.MoveToFront(elem)
+	} else {
+		c.entries[key] = c.lru.PushFront(&memoryEntry{key: key, img: img, size: size})
+		c.size += size
+	}
+	for c.size > c.maxBytes && c.lru.Len() > 1 {
+		e := c.lru.Remove(c.lru.Back()).(*memoryEntry)
+		delete(c.entries, e.key)
+		c.size -= e.size
+	}
+}
+
+//Len returns the number of images in the cache.
+func (c *MemoryCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
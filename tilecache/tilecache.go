@@ -0,0 +1,247 @@
+package tilecache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	//extension of the files holding cached thumbnails
+	thumbExt = ".png"
+	//DefaultMaxBytes is the default size limit of the cache (1 GiB).
+	DefaultMaxBytes int64 = 1 << 30
+)
+
+//Cache is a content-addressed, size-limited directory of resized tile images. Entries are evicted in least-recently-used
+//order once the total size of the cache exceeds its limit. Recency is persisted using the modification time of the
+//files so it survives across runs. A Cache is safe for concurrent use.
+type Cache struct {
+	dir      string
+	maxBytes int64
+	mu       sync.Mutex
+	size     int64
+	//entries ordered from most to least recently used
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+type entry struct {
+	key  string
+	size int64
+}
+
+//DefaultDir returns the directory used for the cache when none is specified: a gomosaic/tiles directory in the user's
+//cache directory.
+func DefaultDir() (string, error) {
+	base, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(base, "gomosaic", "tiles"), nil
+}
+
+//Key computes the cache key for a tile. The location and name identify the tile, the fingerprint captures the version of
+//its content (for instance size and modification time of a local file) and size is the dimension it was resized to.
+func Key(loc string, name string, fingerprint string, size uint) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%s\x00%s\x00%d", loc, name, fingerprint, size)))
+	return hex.EncodeToString(sum[:])
+}
+
+//Open returns a Cache stored in dir, creating the directory if needed. Existing entries are loaded and, if they exceed
+//maxBytes, the least recently used ones are evicted. A maxBytes <= 0 means DefaultMaxBytes.
+func Open(dir string, maxBytes int64) (*Cache, error) {
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxBytes
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	c := &Cache{dir: dir, maxBytes: maxBytes, lru: list.New(), entries: make(map[string]*list.Element)}
+
+	type found struct {
+		key     string
+		size    int64
+		modTime time.Time
+	}
+	var existing []found
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && isCacheFile(info.Name()) && strings.HasSuffix(info.Name(), thumbExt) {
+			existing = append(existing, found{strings.TrimSuffix(info.Name(), thumbExt), info.Size(), info.ModTime()})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	//most recently used first
+	sort.Slice(existing, func(i, j int) bool { return existing[i].modTime.After(existing[j].modTime) })
+	for _, f := range existing {
+		c.entries[f.key] = c.lru.PushBack(&entry{key: f.key, size: f.size})
+		c.size += f.size
+	}
+	c.mu.Lock()
+	c.evict()
+	c.mu.Unlock()
+	return c, nil
+}
+
+//Get returns the image stored under key, if present, and marks it as recently used.
+func (c *Cache) Get(key string) (image.Image, bool) {
+	c.mu.Lock()
+	elem, ok := c.entries[key]
+	if ok {
+		c.lru.MoveToFront(elem)
+	}
+	c.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	path := c.path(key)
+	f, err := os.Open(path)
+	if err != nil {
+		c.remove(key)
+		return nil, false
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		log.Printf("Discarding unreadable cache entry %s: %v", path, err)
+		c.remove(key)
+		os.Remove(path)
+		return nil, false
+	}
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return img, true
+}
+
+//Has checks if an entry is stored under key without reading it or changing its recency.
+func (c *Cache) Has(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.entries[key]
+	return ok
+}
+
+//Put stores img under key, evicting least recently used entries if the cache grows beyond its limit.
+func (c *Cache) Put(key string, img image.Image) error {
+	path := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	//write to a temporary file and rename it so readers never see a partial entry
+	tmp, err := ioutil.TempFile(filepath.Dir(path), key+".tmp")
+	if err != nil {
+		return err
+	}
+	err = png.Encode(tmp, img)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.size -= elem.Value.(*entry).size
+		elem.Value.(*entry).size = info.Size()
+		c.lru.MoveToFront(elem)
+	} else {
+		c.entries[key] = c.lru.PushFront(&entry{key: key, size: info.Size()})
+	}
+	c.size += info.Size()
+	c.evict()
+	return nil
+}
+
+//Size returns the total size, in bytes, of the entries in the cache.
+func (c *Cache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+//Clear removes every entry (including partially written ones) from the cache directory passed in, returning the number
+//of bytes freed. Files that were not created by the cache are left alone.
+func Clear(dir string) (int64, error) {
+	var freed int64
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !info.IsDir() && isCacheFile(info.Name()) {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+			freed += info.Size()
+		}
+		return nil
+	})
+	return freed, err
+}
+
+//evict removes least recently used entries until the cache fits within its limit. The caller must hold the lock.
+func (c *Cache) evict() {
+	for c.size > c.maxBytes && c.lru.Len() > 0 {
+		e := c.lru.Remove(c.lru.Back()).(*entry)
+		delete(c.entries, e.key)
+		c.size -= e.size
+		if err := os.Remove(c.path(e.key)); err != nil && !os.IsNotExist(err) {
+			log.Printf("Could not evict cache entry %s: %v", e.key, err)
+		}
+	}
+}
+
+//remove forgets about an entry whose file is missing or unusable.
+func (c *Cache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.size -= elem.Value.(*entry).size
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+	}
+}
+
+//isCacheFile checks if the file name starts with a key, which is the case for both entries and their temporary files.
+func isCacheFile(name string) bool {
+	if len(name) < sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(name[:sha256.Size*2])
+	return err == nil
+}
+
+//path returns the location of the file for an entry. Entries are spread across subdirectories named after the first
+//two characters of the key to keep directories small.
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.dir, key[:2], key+thumbExt)
+}
@@ -0,0 +1,151 @@
+package tilecache
+
+import (
+	"image"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+//newTestImage returns a size x size image filled with a single color.
+func newTestImage(size int, c color.Color) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+//TestKey verifies that each component of the tile identity produces a different key.
+func TestKey(t *testing.T) {
+	base := Key("L", "a.jpg", "1-2", 50)
+	cases := []string{
+		Key("G", "a.jpg", "1-2", 50),
+		Key("L", "b.jpg", "1-2", 50),
+		Key("L", "a.jpg", "1-3", 50),
+		Key("L", "a.jpg", "1-2", 51),
+	}
+	for _, c := range cases {
+		if c == base {
+			t.Errorf("Key returned the same key for different tiles")
+		}
+	}
+	if base != Key("L", "a.jpg", "1-2", 50) {
+		t.Errorf("Key is not deterministic")
+	}
+}
+
+//TestPutGet verifies that images stored in the cache can be read back, including after the cache is reopened.
+func TestPutGet(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "tilecache")
+	defer os.RemoveAll(dir)
+	cache, err := Open(dir, 0)
+	if err != nil {
+		t.Fatalf("Could not open cache: %v", err)
+	}
+	key := Key("L", "a.jpg", "", 10)
+	if _, ok := cache.Get(key); ok {
+		t.Errorf("Get returned an entry from an empty cache")
+	}
+	if err := cache.Put(key, newTestImage(10, color.RGBA{200, 100, 50, 255})); err != nil {
+		t.Fatalf("Could not put image in cache: %v", err)
+	}
+	cache, err = Open(dir, 0)
+	if err != nil {
+		t.Fatalf("Could not reopen cache: %v", err)
+	}
+	img, ok := cache.Get(key)
+	if !ok {
+		t.Fatalf("Get did not return the entry after reopening the cache")
+	}
+	if r, g, b, _ := img.At(5, 5).RGBA(); r>>8 != 200 || g>>8 != 100 || b>>8 != 50 || img.Bounds().Dx() != 10 {
+		t.Errorf("Cached image does not match the image that was stored")
+	}
+}
+
+//TestEviction verifies that the least recently used entries are removed once the cache exceeds its limit.
+func TestEviction(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "tilecache")
+	defer os.RemoveAll(dir)
+	probe, _ := Open(filepath.Join(dir, "probe"), 0)
+	probe.Put(Key("probe", "", "", 0), newTestImage(16, color.White))
+	entrySize := probe.Size()
+
+	cache, err := Open(filepath.Join(dir, "cache"), entrySize*3)
+	if err != nil {
+		t.Fatalf("Could not open cache: %v", err)
+	}
+	keys := []string{Key("L", "1", "", 16), Key("L", "2", "", 16), Key("L", "3", "", 16), Key("L", "4", "", 16)}
+	for _, key := range keys[:3] {
+		cache.Put(key, newTestImage(16, color.White))
+	}
+	//use the first entry so the second one becomes the least recently used
+	if _, ok := cache.Get(keys[0]); !ok {
+		t.Fatalf("Entry missing before the cache reached its limit")
+	}
+	cache.Put(keys[3], newTestImage(16, color.White))
+	expected := []bool{true, false, true, true}
+	for i, key := range keys {
+		if _, ok := cache.Get(key); ok != expected[i] {
+			t.Errorf("Presence of entry %d after eviction was %v but %v was expected", i, ok, expected[i])
+		}
+	}
+	if cache.Size() > entrySize*3 {
+		t.Errorf("Cache size %d exceeds its limit of %d", cache.Size(), entrySize*3)
+	}
+}
+
+//TestClear verifies that Clear removes cached entries but leaves unrelated files alone.
+func TestClear(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "tilecache")
+	defer os.RemoveAll(dir)
+	cache, _ := Open(dir, 0)
+	cache.Put(Key("L", "a", "", 8), newTestImage(8, color.Black))
+	other := filepath.Join(dir, "keep.png")
+	ioutil.WriteFile(other, []byte("not a tile"), 0644)
+
+	freed, err := Clear(dir)
+	if err != nil || freed == 0 {
+		t.Errorf("Clear returned %d,%v", freed, err)
+	}
+	if cache, _ = Open(dir, 0); cache.Size() != 0 {
+		t.Errorf("Cache still contains %d bytes after Clear", cache.Size())
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("Clear removed a file that did not belong to the cache")
+	}
+	if _, err := Clear(filepath.Join(dir, "missing")); err != nil {
+		t.Errorf("Clear returned an error for a missing directory: %v", err)
+	}
+}
+
+//TestMemoryCache verifies that the in-memory cache returns stored images and evicts the least recently used ones once
+//it exceeds its limit.
+func TestMemoryCache(t *testing.T) {
+	//each 10x10 image is estimated at 400 bytes
+	cache := NewMemoryCache(1000)
+	cache.Put("a", newTestImage(10, color.White))
+	cache.Put("b", newTestImage(10, color.White))
+	if _, ok := cache.Get("a"); !ok {
+		t.Fatalf("MemoryCache did not return a stored image")
+	}
+	cache.Put("c", newTestImage(10, color.White))
+	if _, ok := cache.Get("b"); ok {
+		t.Errorf("MemoryCache did not evict the least recently used image")
+	}
+	if _, ok := cache.Get("a"); !ok {
+		t.Errorf("MemoryCache evicted a recently used image")
+	}
+	if cache.Len() != 2 {
+		t.Errorf("MemoryCache holds %d images but 2 were expected", cache.Len())
+	}
+	//an image larger than the limit is still kept until something else is stored
+	cache.Put("big", newTestImage(40, color.White))
+	if _, ok := cache.Get("big"); !ok || cache.Len() != 1 {
+		t.Errorf("MemoryCache did not keep the most recent image")
+	}
+}
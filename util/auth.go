@@ -0,0 +1,113 @@
+package util
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/photoslibrary/v1"
+	"io"
+	"net/url"
+	"strings"
+)
+
+//pasteRedirectURL is where the browser is sent once access is granted in the manual paste flow. Nothing needs to be
+//listening there: the user copies the address (or just its code parameter) from the browser's address bar.
+const pasteRedirectURL = "http://localhost"
+
+//PasteAuthorize obtains a token without starting a local server or opening a browser. It writes an authorization url
+//to out for the user to open on any machine and then reads, from in, either the address the browser was redirected to
+//or the code it contains. If conf has no RedirectURL, http://localhost is used.
+func PasteAuthorize(ctx context.Context, conf *oauth2.Config, in io.Reader, out io.Writer) (*oauth2.Token, error) {
+	c := *conf
+	if len(c.RedirectURL) == 0 {
+		c.RedirectURL = pasteRedirectURL
+	}
+	state, err := randomState()
+	if err != nil {
+		return nil, err
+	}
+	verifier := oauth2.GenerateVerifier()
+	fmt.Fprintf(out, "Open the following url in a browser and grant access:\n\n%s\n\n", c.AuthCodeURL(state,
+		oauth2.AccessTypeOffline, oauth2.ApprovalForce, oauth2.S256ChallengeOption(verifier)))
+	fmt.Fprintf(out, "The browser will then be sent to %s, which may fail to load. Paste the address it was sent to "+
+		"(or the value of its code parameter) here: ", c.RedirectURL)
+
+	line, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && (err != io.EOF || len(line) == 0) {
+		return nil, fmt.Errorf("could not read authorization code: %v", err)
+	}
+	code, err := parsePastedCode(strings.TrimSpace(line), state)
+	if err != nil {
+		return nil, err
+	}
+	return c.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+}
+
+//DeviceAuthorize obtains a token using the OAuth device flow: it writes a url and a code to out for the user to enter
+//on any device and then waits until access is granted or the code expires. Google only supports this flow for clients
+//of type "TVs and Limited Input devices".
+func DeviceAuthorize(ctx context.Context, conf *oauth2.Config, out io.Writer) (*oauth2.Token, error) {
+	resp, err := conf.DeviceAuth(ctx, oauth2.AccessTypeOffline)
+	if err != nil {
+		return nil, fmt.Errorf("could not start device authorization: %v", err)
+	}
+	uri := resp.VerificationURIComplete
+	if len(uri) == 0 {
+		uri = resp.VerificationURI
+	}
+	fmt.Fprintf(out, "Visit %s and enter the code %s to grant access.\n", uri, resp.UserCode)
+	return conf.DeviceAccessToken(ctx, resp)
+}
+
+//VerifyPhotosAccess makes a minimal call to the Google Photos api to check that the service's token grants access.
+func VerifyPhotosAccess(service *photoslibrary.Service) error {
+	if _, err := service.Albums.List().PageSize(1).Do(); err != nil {
+		return fmt.Errorf("token could not be used to read Google Photos: %v", err)
+	}
+	return nil
+}
+
+//parsePastedCode extracts the authorization code from what the user pasted, which is either the code itself or the
+//address the browser was redirected to. The state of a pasted address must match the one that was sent.
+func parsePastedCode(pasted string, state string) (string, error) {
+	if len(pasted) == 0 {
+		return "", errors.New("no authorization code was entered")
+	}
+	if !strings.Contains(pasted, "code=") && !strings.Contains(pasted, "error=") {
+		return pasted, nil
+	}
+	u, err := url.Parse(pasted)
+	if err != nil {
+		return "", fmt.Errorf("could not parse pasted address: %v", err)
+	}
+	query := u.Query()
+	if len(u.RawQuery) == 0 {
+		//only the query string was pasted
+		if query, err = url.ParseQuery(strings.TrimPrefix(pasted, "?")); err != nil {
+			return "", fmt.Errorf("could not parse pasted address: %v", err)
+		}
+	}
+	if e := query.Get("error"); len(e) > 0 {
+		return "", fmt.Errorf("authorization was not granted: %s", e)
+	}
+	if query.Get("state") != state {
+		return "", errors.New("pasted address does not belong to this authorization request (state mismatch)")
+	}
+	if len(query.Get("code")) == 0 {
+		return "", errors.New("pasted address does not contain a code")
+	}
+	return query.Get("code"), nil
+}
+
+//randomState returns an unguessable value used to tie the authorization response to this request.
+func randomState() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
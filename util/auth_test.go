@@ -0,0 +1,152 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/photoslibrary/v1"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+//fakeOAuthServer implements the token and device authorization endpoints of an OAuth provider along with the album
+//listing of the Google Photos api. Only the code "good-code" can be exchanged and only the resulting access token can
+//list albums.
+type fakeOAuthServer struct {
+	*httptest.Server
+	//form of the last request to the token endpoint
+	lastToken url.Values
+}
+
+func newFakeOAuthServer() *fakeOAuthServer {
+	f := &fakeOAuthServer{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		f.lastToken = r.Form
+		w.Header().Set("Content-Type", "application/json")
+		grant := r.Form.Get("grant_type")
+		if (grant == "authorization_code" && r.Form.Get("code") == "good-code" && r.Form.Get("code_verifier") != "") ||
+			(grant == "urn:ietf:params:oauth:grant-type:device_code" && r.Form.Get("device_code") == "dev-code") {
+			fmt.Fprint(w, `{"access_token": "granted", "refresh_token": "refresh", "token_type": "Bearer", "expires_in": 3600}`)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error": "invalid_grant"}`)
+	})
+	mux.HandleFunc("/device", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"device_code": "dev-code", "user_code": "ABCD-EFGH",
+			"verification_url": f.URL + "/activate", "expires_in": 60, "interval": 1})
+	})
+	mux.HandleFunc("/v1/albums", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer granted" {
+			http.Error(w, `{"error": {"code": 401, "message": "unauthenticated"}}`, http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(photoslibrary.ListAlbumsResponse{})
+	})
+	f.Server = httptest.NewServer(mux)
+	return f
+}
+
+func (f *fakeOAuthServer) config() *oauth2.Config {
+	return &oauth2.Config{ClientID: "id", ClientSecret: "secret", Scopes: []string{"photos"},
+		Endpoint: oauth2.Endpoint{AuthURL: f.URL + "/auth", TokenURL: f.URL + "/token", DeviceAuthURL: f.URL + "/device"}}
+}
+
+//pasteInput plays the part of the user in the paste flow: when the code is read, it opens the authorization url
+//printed so far and answers with whatever respond returns.
+type pasteInput struct {
+	out     *bytes.Buffer
+	respond func(authURL *url.URL) string
+	reply   *strings.Reader
+}
+
+func (p *pasteInput) Read(b []byte) (int, error) {
+	if p.reply == nil {
+		authURL, _ := url.Parse(regexp.MustCompile(`http\S+/auth\S*`).FindString(p.out.String()))
+		p.reply = strings.NewReader(p.respond(authURL))
+	}
+	return p.reply.Read(b)
+}
+
+//TestPasteAuthorize validates the manual paste flow with both a pasted address and a bare code.
+func TestPasteAuthorize(t *testing.T) {
+	server := newFakeOAuthServer()
+	defer server.Close()
+	cases := []struct {
+		respond     func(authURL *url.URL) string
+		expectError bool
+	}{
+		{func(u *url.URL) string {
+			return "http://localhost/?state=" + u.Query().Get("state") + "&code=good-code&scope=photos\n"
+		}, false},
+		{func(u *url.URL) string { return "  good-code  \n" }, false},
+		//no trailing newline
+		{func(u *url.URL) string { return "good-code" }, false},
+		{func(u *url.URL) string { return "http://localhost/?state=forged&code=good-code\n" }, true},
+		{func(u *url.URL) string { return "http://localhost/?error=access_denied\n" }, true},
+		{func(u *url.URL) string { return "bad-code\n" }, true},
+		{func(u *url.URL) string { return "\n" }, true},
+	}
+	for i, c := range cases {
+		var out bytes.Buffer
+		var seen *url.URL
+		in := &pasteInput{out: &out, respond: func(u *url.URL) string {
+			seen = u
+			return c.respond(u)
+		}}
+		token, err := PasteAuthorize(context.Background(), server.config(), in, &out)
+		if c.expectError {
+			if err == nil {
+				t.Errorf("Case %d: expected an error", i)
+			}
+			continue
+		}
+		if err != nil || token.AccessToken != "granted" || token.RefreshToken != "refresh" {
+			t.Errorf("Case %d: PasteAuthorize returned %+v, %v", i, token, err)
+			continue
+		}
+		query := seen.Query()
+		if query.Get("redirect_uri") != pasteRedirectURL || query.Get("access_type") != "offline" ||
+			query.Get("code_challenge_method") != "S256" {
+			t.Errorf("Case %d: unexpected authorization url %s", i, seen)
+		}
+		if server.lastToken.Get("redirect_uri") != pasteRedirectURL {
+			t.Errorf("Case %d: code was exchanged with redirect %s", i, server.lastToken.Get("redirect_uri"))
+		}
+	}
+}
+
+//TestDeviceAuthorize validates the device flow and that the resulting token is accepted by the api.
+func TestDeviceAuthorize(t *testing.T) {
+	server := newFakeOAuthServer()
+	defer server.Close()
+	conf := server.config()
+	var out bytes.Buffer
+	token, err := DeviceAuthorize(context.Background(), conf, &out)
+	if err != nil || token.AccessToken != "granted" {
+		t.Fatalf("DeviceAuthorize returned %+v, %v", token, err)
+	}
+	if !strings.Contains(out.String(), "ABCD-EFGH") || !strings.Contains(out.String(), server.URL+"/activate") {
+		t.Errorf("Instructions did not include the code and url: %s", out.String())
+	}
+
+	for _, c := range []struct {
+		token       string
+		expectError bool
+	}{{"granted", false}, {"other", true}} {
+		service, _ := photoslibrary.New(conf.Client(context.Background(), &oauth2.Token{AccessToken: c.token}))
+		service.BasePath = server.URL + "/"
+		if err := VerifyPhotosAccess(service); (err != nil) != c.expectError {
+			t.Errorf("VerifyPhotosAccess with token %s returned %v", c.token, err)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"google.golang.org/api/googleapi"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+//ErrQuotaExceeded is wrapped by the APIError returned when an api call was still being rate limited after all retries.
+var ErrQuotaExceeded = errors.New("api quota exceeded")
+
+//APIError describes a failed call to a remote api.
+type APIError struct {
+	//Op describes the operation that failed
+	Op string
+	//Code is the HTTP status code returned by the api, or 0 if no response was received
+	Code int
+	//Attempts is the number of times the call was attempted
+	Attempts int
+	//Err is the error returned by the last attempt
+	Err error
+}
+
+func (e *APIError) Error() string {
+	if e.Code != 0 {
+		return fmt.Sprintf("%s failed with status %d after %d attempt(s): %v", e.Op, e.Code, e.Attempts, e.Err)
+	}
+	return fmt.Sprintf("%s failed after %d attempt(s): %v", e.Op, e.Attempts, e.Err)
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+//Is allows errors.Is(err, ErrQuotaExceeded) to be used to detect quota failures.
+func (e *APIError) Is(target error) bool {
+	return target == ErrQuotaExceeded && e.Code == http.StatusTooManyRequests
+}
+
+//RetryPolicy controls how failed api calls are retried. Calls that fail with a 429 or 5xx status, or without receiving
+//a response, are retried using exponential backoff with full jitter. If the api sends a Retry-After header, the delay
+//it requests is used instead, up to MaxDelay.
+type RetryPolicy struct {
+	//maximum number of times a call is attempted
+	MaxAttempts int
+	//delay before the first retry; it doubles with each subsequent retry
+	BaseDelay time.Duration
+	//upper bound on the delay between attempts
+	MaxDelay time.Duration
+	//Sleep is used to wait between attempts. Defaults to time.Sleep; tests may replace it.
+	Sleep func(time.Duration)
+}
+
+//DefaultRetryPolicy is used when a zero RetryPolicy is provided.
+var DefaultRetryPolicy = RetryPolicy{MaxAttempts: 6, BaseDelay: 500 * time.Millisecond, MaxDelay: time.Minute}
+
+//Do calls fn until it succeeds, returns an error that should not be retried or the maximum number of attempts is
+//reached. Failures are returned as an *APIError.
+func (p RetryPolicy) Do(op string, fn func() error) error {
+	p = p.withDefaults()
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+		code, retryable, retryAfter := classifyError(err)
+		if !retryable || attempt >= p.MaxAttempts {
+			return &APIError{Op: op, Code: code, Attempts: attempt, Err: err}
+		}
+		//the server knows best how long its quota needs to recover, but a bogus or huge delay mustn't stall the run
+		delay := retryAfter
+		if delay > p.MaxDelay {
+			delay = p.MaxDelay
+		}
+		if delay <= 0 {
+			delay = p.backoff(attempt)
+		}
+		p.Sleep(delay)
+	}
+}
+
+//withDefaults fills in any unset fields from DefaultRetryPolicy.
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = DefaultRetryPolicy.MaxAttempts
+	}
+	if p.BaseDelay <= 0 {
+		p.BaseDelay = DefaultRetryPolicy.BaseDelay
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = DefaultRetryPolicy.MaxDelay
+	}
+	if p.Sleep == nil {
+		p.Sleep = time.Sleep
+	}
+	return p
+}
+
+//backoff returns a random delay between 0 and BaseDelay * 2^(attempt-1), capped at MaxDelay.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	ceiling := p.BaseDelay << uint(attempt-1)
+	if ceiling > p.MaxDelay || ceiling <= 0 {
+		ceiling = p.MaxDelay
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+//classifyError returns the HTTP status code associated with the error (if any), whether the call should be retried
+//and the delay requested by the server through the Retry-After header (0 if none).
+func classifyError(err error) (int, bool, time.Duration) {
+	//the token has to be replaced before any call can succeed
+	var tokenErr *TokenError
+	if errors.As(err, &tokenErr) {
+		return 0, false, 0
+	}
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		retryable := apiErr.Code == http.StatusTooManyRequests || apiErr.Code >= 500
+		return apiErr.Code, retryable, parseRetryAfter(apiErr.Header.Get("Retry-After"))
+	}
+	//errors from the http client (e.g. connection resets or timeouts) mean no response was received
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return 0, true, 0
+	}
+	return 0, false, 0
+}
+
+//parseRetryAfter parses the value of a Retry-After header, which is either a number of seconds or an HTTP date.
+func parseRetryAfter(value string) time.Duration {
+	if len(value) == 0 {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if when, err := http.ParseTime(value); err == nil {
+		return time.Until(when)
+	}
+	return 0
+}
@@ -0,0 +1,92 @@
+package util
+
+import (
+	"errors"
+	"google.golang.org/api/googleapi"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+//TestRetryPolicy validates that only transient failures are retried and that failures are reported as an APIError.
+func TestRetryPolicy(t *testing.T) {
+	timeout := &net.OpError{Op: "dial", Err: errors.New("i/o timeout")}
+	quota := &googleapi.Error{Code: 429, Header: http.Header{"Retry-After": []string{"3"}}}
+	longQuota := &googleapi.Error{Code: 429, Header: http.Header{"Retry-After": []string{"3600"}}}
+	cases := []struct {
+		errs             []error
+		expectedCalls    int
+		expectedCode     int
+		expectQuota      bool
+		expectedSleeps   int
+		expectedFirstGap time.Duration
+	}{
+		{nil, 1, 0, false, 0, 0},
+		{[]error{&googleapi.Error{Code: 503}, &googleapi.Error{Code: 500}}, 3, 0, false, 2, -1},
+		{[]error{quota}, 2, 0, false, 1, 3 * time.Second},
+		//the delay asked for is capped at MaxDelay
+		{[]error{longQuota}, 2, 0, false, 1, 5 * time.Second},
+		{[]error{timeout}, 2, 0, false, 1, -1},
+		{[]error{&googleapi.Error{Code: 404}}, 1, 404, false, 0, 0},
+		{[]error{errors.New("bad request")}, 1, 0, false, 0, 0},
+		{[]error{quota, quota, quota, quota}, 4, 429, true, 3, 3 * time.Second},
+	}
+	for _, c := range cases {
+		var sleeps []time.Duration
+		policy := RetryPolicy{MaxAttempts: 4, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Second,
+			Sleep: func(d time.Duration) { sleeps = append(sleeps, d) }}
+		calls := 0
+		err := policy.Do("testing", func() error {
+			calls++
+			if calls <= len(c.errs) {
+				return c.errs[calls-1]
+			}
+			return nil
+		})
+		if calls != c.expectedCalls || len(sleeps) != c.expectedSleeps {
+			t.Errorf("With errors %v expected %d calls and %d sleeps but got %d and %d", c.errs, c.expectedCalls,
+				c.expectedSleeps, calls, len(sleeps))
+		}
+		if c.expectedFirstGap > 0 && sleeps[0] != c.expectedFirstGap {
+			t.Errorf("With errors %v expected to wait %v but waited %v", c.errs, c.expectedFirstGap, sleeps[0])
+		}
+		for _, d := range sleeps {
+			if c.expectedFirstGap < 0 && (d < 0 || d > policy.MaxDelay) {
+				t.Errorf("Backoff delay %v is outside of [0, %v]", d, policy.MaxDelay)
+			}
+		}
+		expectError := c.expectedCalls <= len(c.errs)
+		var apiErr *APIError
+		if !expectError {
+			if err != nil {
+				t.Errorf("With errors %v got unexpected error %v", c.errs, err)
+			}
+		} else if !errors.As(err, &apiErr) || apiErr.Code != c.expectedCode || apiErr.Attempts != calls {
+			t.Errorf("With errors %v got error %#v", c.errs, err)
+		} else if errors.Is(err, ErrQuotaExceeded) != c.expectQuota {
+			t.Errorf("With errors %v errors.Is(ErrQuotaExceeded) was %v", c.errs, !c.expectQuota)
+		}
+	}
+}
+
+//TestParseRetryAfter validates both forms of the Retry-After header.
+func TestParseRetryAfter(t *testing.T) {
+	when := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	cases := []struct {
+		value string
+		min   time.Duration
+		max   time.Duration
+	}{
+		{"", 0, 0},
+		{"120", 2 * time.Minute, 2 * time.Minute},
+		{"soon", 0, 0},
+		{"-5", 0, 0},
+		{when, 58 * time.Minute, time.Hour},
+	}
+	for _, c := range cases {
+		if d := parseRetryAfter(c.value); d < c.min || d > c.max {
+			t.Errorf("parseRetryAfter(%q) returned %v", c.value, d)
+		}
+	}
+}
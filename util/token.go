@@ -0,0 +1,111 @@
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang.org/x/oauth2"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+//TokenError is returned when the OAuth token stored in a file can no longer be used, typically because it expired
+//without a refresh token or because access was revoked. A new token must be obtained with the authtoken command.
+type TokenError struct {
+	File string
+	Err  error
+}
+
+func (e *TokenError) Error() string {
+	return fmt.Sprintf("the OAuth token in %s is no longer valid (run authtoken to obtain a new one): %v", e.File, e.Err)
+}
+
+func (e *TokenError) Unwrap() error {
+	return e.Err
+}
+
+//ReadToken reads a json file containing an oauth token and unmarshals it into a Token struct.
+func ReadToken(file string) (*oauth2.Token, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("could not load token: %v", err)
+	}
+	token := &oauth2.Token{}
+	if err = json.Unmarshal(data, token); err != nil {
+		return nil, fmt.Errorf("could not parse token in %s: %v", file, err)
+	}
+	return token, nil
+}
+
+//WriteToken stores the token in a json file. The token is written to a temporary file that is then renamed so the file
+//is never left partially written.
+func WriteToken(file string, token *oauth2.Token) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file)+".tmp")
+	if err != nil {
+		return err
+	}
+	err = json.NewEncoder(tmp).Encode(token)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		//TempFile already creates the file with 0600 permissions, which is what a credential needs
+		err = os.Rename(tmp.Name(), file)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+//persistingTokenSource writes the token back to its file whenever it is refreshed so later runs start from the newest
+//token.
+type persistingTokenSource struct {
+	file         string
+	base         oauth2.TokenSource
+	mu           sync.Mutex
+	accessToken  string
+	refreshToken string
+}
+
+//Token returns a valid token, refreshing and persisting it if needed. Failures that a retry won't fix are returned as a
+//*TokenError.
+func (s *persistingTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token, err := s.base.Token()
+	if err != nil {
+		var retrieveErr *oauth2.RetrieveError
+		if len(s.refreshToken) == 0 || (errors.As(err, &retrieveErr) && retrieveErr.Response != nil &&
+			retrieveErr.Response.StatusCode < http.StatusInternalServerError) {
+			return nil, &TokenError{File: s.file, Err: err}
+		}
+		return nil, err
+	}
+	if token.AccessToken != s.accessToken {
+		if err := WriteToken(s.file, token); err != nil {
+			//the token can still be used for this run
+			log.Printf("Could not save refreshed token to %s: %v", s.file, err)
+		}
+		s.accessToken = token.AccessToken
+	}
+	return token, nil
+}
+
+//newTokenClient returns an http client that authenticates using the token stored in tokenFile, persisting the token
+//whenever it is refreshed.
+func newTokenClient(conf *oauth2.Config, tokenFile string) (*http.Client, error) {
+	token, err := ReadToken(tokenFile)
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	source := &persistingTokenSource{file: tokenFile, base: conf.TokenSource(ctx, token),
+		accessToken: token.AccessToken, refreshToken: token.RefreshToken}
+	return oauth2.NewClient(ctx, source), nil
+}
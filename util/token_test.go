@@ -0,0 +1,114 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"github.com/cfagiani/gomosaic"
+	"golang.org/x/oauth2"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+//TestTokenRefresh validates that refreshed tokens are written back to the token file and that tokens that can't be
+//refreshed result in a TokenError.
+func TestTokenRefresh(t *testing.T) {
+	refreshes := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("refresh_token") != "good" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error": "invalid_grant", "error_description": "Token has been expired or revoked."}`)
+			return
+		}
+		refreshes++
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token": "fresh%d", "token_type": "Bearer", "expires_in": 3600}`, refreshes)
+	})
+	mux.HandleFunc("/api", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Header.Get("Authorization"))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	conf := &oauth2.Config{ClientID: "id", ClientSecret: "secret", Endpoint: oauth2.Endpoint{TokenURL: server.URL + "/token"}}
+
+	dir, _ := ioutil.TempDir("", "token")
+	defer os.RemoveAll(dir)
+	expired := time.Now().Add(-time.Hour)
+	cases := []struct {
+		token         oauth2.Token
+		expectedAuth  string
+		expectedToken string
+		expectTokErr  bool
+	}{
+		{oauth2.Token{AccessToken: "valid", RefreshToken: "good", Expiry: time.Now().Add(time.Hour)}, "Bearer valid", "valid", false},
+		{oauth2.Token{AccessToken: "old", RefreshToken: "good", Expiry: expired}, "Bearer fresh1", "fresh1", false},
+		{oauth2.Token{AccessToken: "old", RefreshToken: "revoked", Expiry: expired}, "", "old", true},
+		{oauth2.Token{AccessToken: "old", Expiry: expired}, "", "old", true},
+	}
+	for i, c := range cases {
+		file := filepath.Join(dir, fmt.Sprintf("token%d.json", i))
+		if err := WriteToken(file, &c.token); err != nil {
+			t.Fatalf("Could not write token: %v", err)
+		}
+		client, err := newTokenClient(conf, file)
+		if err != nil {
+			t.Fatalf("Could not create client: %v", err)
+		}
+		resp, err := client.Get(server.URL + "/api")
+		var tokenErr *TokenError
+		if c.expectTokErr {
+			if !errors.As(err, &tokenErr) || tokenErr.File != file {
+				t.Errorf("Case %d: expected a TokenError but got %v", i, err)
+			}
+			if _, retryable, _ := classifyError(err); retryable {
+				t.Errorf("Case %d: token errors should not be retried", i)
+			}
+		} else if err != nil {
+			t.Errorf("Case %d: unexpected error %v", i, err)
+		} else {
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if string(body) != c.expectedAuth {
+				t.Errorf("Case %d: request was sent with %q but %q was expected", i, body, c.expectedAuth)
+			}
+		}
+		saved, err := ReadToken(file)
+		if err != nil || saved.AccessToken != c.expectedToken {
+			t.Errorf("Case %d: token file contains %+v, %v but %s was expected", i, saved, err, c.expectedToken)
+		} else if saved.RefreshToken != c.token.RefreshToken {
+			t.Errorf("Case %d: refresh token was not kept", i)
+		}
+	}
+	if _, err := newTokenClient(conf, filepath.Join(dir, "missing.json")); err == nil {
+		t.Errorf("Expected an error for a missing token file")
+	}
+}
+
+//TestGoogleTokenFile validates that the token file is taken from the google sources in the config and that sources
+//with different token files are rejected.
+func TestGoogleTokenFile(t *testing.T) {
+	cases := []struct {
+		sources     []gomosaic.ImageSource
+		expected    string
+		expectError bool
+	}{
+		{[]gomosaic.ImageSource{{Kind: "local", Options: "recurse"}, {Kind: "google", Options: "me.json"}}, "me.json", false},
+		{[]gomosaic.ImageSource{{Kind: "google", Options: "a.json"}, {Kind: "google", Options: "b.json"}}, "", true},
+		{[]gomosaic.ImageSource{{Kind: "google", Options: "a.json"}, {Kind: "google", Options: "a.json"}}, "a.json", false},
+		{[]gomosaic.ImageSource{{Kind: "local", Options: "recurse"}}, "", true},
+		{[]gomosaic.ImageSource{{Kind: "google"}}, "", true},
+	}
+	for _, c := range cases {
+		file, err := GoogleTokenFile(gomosaic.Config{Sources: c.sources})
+		if (err != nil) != c.expectError || file != c.expected {
+			t.Errorf("GoogleTokenFile(%+v) returned %s, %v", c.sources, file, err)
+		}
+	}
+}
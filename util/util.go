@@ -3,7 +3,6 @@ package util
 import (
 	"encoding/json"
 	"github.com/cfagiani/gomosaic"
-	"golang.org/x/net/context"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/photoslibrary/v1"
@@ -12,6 +11,7 @@ import (
 	"os"
 	"strconv"
 	"errors"
+	"fmt"
 )
 
 //utility to get the path of a file by concatenating the directory, the pathSeparator, and the file name.
@@ -44,7 +44,8 @@ func GetInt32(s string) uint32 {
 }
 
 //GetPhotosService will use the client information and token file passed in to initialize a photoslibrary.Service instance
-//that can be used to interact with the Google Photos API.
+//that can be used to interact with the Google Photos API. Refreshed tokens are written back to the token file. Calls
+//made through the service fail with a *TokenError if the token can no longer be used.
 func GetPhotosService(clientId string, clientSecret string, tokenFile string) (*photoslibrary.Service, error) {
 	conf := &oauth2.Config{
 		ClientID:     clientId,
@@ -53,22 +54,33 @@ func GetPhotosService(clientId string, clientSecret string, tokenFile string) (*
 		Endpoint:     google.Endpoint,
 	}
 
-	client := conf.Client(context.Background(), readOauthToken(tokenFile))
-
+	client, err := newTokenClient(conf, tokenFile)
+	if err != nil {
+		return nil, err
+	}
 	return photoslibrary.New(client)
 }
 
-//readOauthToken reads a json file containing an oauth token and unmarshals it into a Token struct.
-func readOauthToken(file string) *oauth2.Token {
-	f, err := os.Open(file)
-	defer f.Close()
-	if err != nil {
-		log.Fatalf("Could not load token: %v", err)
-		os.Exit(1)
+//GoogleTokenFile returns the token file used by the google sources in the config. Tiles don't record which source
+//they were indexed from so all google sources must use the same token file, otherwise an error is returned.
+func GoogleTokenFile(config gomosaic.Config) (string, error) {
+	tokenFile := ""
+	for _, source := range config.Sources {
+		if source.Kind != "google" {
+			continue
+		}
+		if len(tokenFile) == 0 {
+			tokenFile = source.Options
+		} else if source.Options != tokenFile {
+			return "", fmt.Errorf("google sources use different token files (%s and %s) but Google Photos tiles "+
+				"can only be read with one; index every google source with the same token file", tokenFile,
+				source.Options)
+		}
+	}
+	if len(tokenFile) == 0 {
+		return "", errors.New("config file does not contain a google source with a token file in its options")
 	}
-	token := &oauth2.Token{}
-	err = json.NewDecoder(f).Decode(token)
-	return token
+	return tokenFile, nil
 }
 
 //ReadConfig reads in a configuration json file and unmarshals it into a Config struct.